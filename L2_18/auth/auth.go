@@ -0,0 +1,147 @@
+// Package auth provides authentication of calendar service users
+// by static API tokens or HS256-signed JWTs verified locally
+package auth
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TicketTTL is lifetime of stream tickets
+const TicketTTL = time.Minute
+
+// ticketAudience marks JWTs issued as stream tickets
+const ticketAudience = "stream"
+
+// Authenticator establishes user identity from bearer tokens
+type Authenticator struct {
+	tokens map[string]string
+	secret []byte
+	// ticketKey signs stream tickets, it is random so tickets are valid only in this process
+	// and never pass as bearer tokens
+	ticketKey []byte
+}
+
+// NewAuthenticator creates authenticator with API tokens mapped to user IDs
+// and secret for JWT verification, either may be empty
+func NewAuthenticator(tokens map[string]string, secret []byte) (*Authenticator, error) {
+	if len(tokens) == 0 && len(secret) == 0 {
+		return nil, fmt.Errorf("Neither API tokens nor JWT secret are given")
+	}
+	for token, userID := range tokens {
+		if token == "" || userID == "" {
+			return nil, fmt.Errorf("API token and its user cant be empty")
+		}
+	}
+	ticketKey := make([]byte, 32)
+	if _, err := rand.Read(ticketKey); err != nil {
+		return nil, fmt.Errorf("Failed to generate ticket key: %w", err)
+	}
+	return &Authenticator{
+		tokens:    tokens,
+		secret:    secret,
+		ticketKey: ticketKey,
+	}, nil
+}
+
+// LoadTokens reads API tokens from file with "<token> <user_id>" lines.
+// Empty lines and lines starting with # are skipped
+func LoadTokens(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open tokens file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid tokens file line %d: expected token and user ID", lineNum)
+		}
+		tokens[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading tokens file %s: %w", path, err)
+	}
+	return tokens, nil
+}
+
+// Authenticate returns ID of user owning bearer token from Authorization header value
+func (a *Authenticator) Authenticate(header string) (string, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", fmt.Errorf("Bearer token is required")
+	}
+	token = strings.TrimSpace(token)
+
+	if strings.Count(token, ".") == 2 && len(a.secret) != 0 {
+		return a.verifyJWT(token)
+	}
+
+	for known, userID := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return userID, nil
+		}
+	}
+	return "", fmt.Errorf("Invalid token")
+}
+
+// verifyJWT checks signature and expiration of token and returns its subject
+func (a *Authenticator) verifyJWT(token string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) {
+		return a.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return "", fmt.Errorf("Invalid token: %v", err)
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("Invalid token: subject is required")
+	}
+	return claims.Subject, nil
+}
+
+// IssueTicket returns short-lived ticket of user and its expiration time. Tickets authenticate
+// clients like browser EventSource which cant send Authorization header
+func (a *Authenticator) IssueTicket(userID string) (string, time.Time, error) {
+	expires := time.Now().Add(TicketTTL)
+	ticket, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   userID,
+		Audience:  jwt.ClaimStrings{ticketAudience},
+		ExpiresAt: jwt.NewNumericDate(expires),
+	}).SignedString(a.ticketKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Failed to sign ticket: %w", err)
+	}
+	return ticket, expires, nil
+}
+
+// AuthenticateTicket returns ID of user ticket was issued to
+func (a *Authenticator) AuthenticateTicket(ticket string) (string, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(ticket, claims, func(*jwt.Token) (any, error) {
+		return a.ticketKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithAudience(ticketAudience))
+	if err != nil {
+		return "", fmt.Errorf("Invalid ticket: %v", err)
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("Invalid ticket: subject is required")
+	}
+	return claims.Subject, nil
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signToken(t *testing.T, method jwt.SigningMethod, key any, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+	return token
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("secret")
+	authenticator, err := NewAuthenticator(map[string]string{"token-1": "u1"}, secret)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+
+	future := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	past := jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "api token",
+			header: "Bearer token-1",
+			want:   "u1",
+		},
+		{
+			name:   "valid jwt",
+			header: "Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{Subject: "u2", ExpiresAt: future}),
+			want:   "u2",
+		},
+		{
+			name:    "missing header",
+			header:  "",
+			wantErr: true,
+		},
+		{
+			name:    "unknown api token",
+			header:  "Bearer token-2",
+			wantErr: true,
+		},
+		{
+			name:    "basic scheme",
+			header:  "Basic token-1",
+			wantErr: true,
+		},
+		{
+			name:    "expired jwt",
+			header:  "Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{Subject: "u2", ExpiresAt: past}),
+			wantErr: true,
+		},
+		{
+			name:    "jwt without expiration",
+			header:  "Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{Subject: "u2"}),
+			wantErr: true,
+		},
+		{
+			name:    "jwt with other secret",
+			header:  "Bearer " + signToken(t, jwt.SigningMethodHS256, []byte("other"), jwt.RegisteredClaims{Subject: "u2", ExpiresAt: future}),
+			wantErr: true,
+		},
+		{
+			name:    "jwt with other algorithm",
+			header:  "Bearer " + signToken(t, jwt.SigningMethodHS512, secret, jwt.RegisteredClaims{Subject: "u2", ExpiresAt: future}),
+			wantErr: true,
+		},
+		{
+			name:    "jwt without subject",
+			header:  "Bearer " + signToken(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{ExpiresAt: future}),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authenticator.Authenticate(tt.header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Authenticate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTickets(t *testing.T) {
+	secret := []byte("secret")
+	authenticator, err := NewAuthenticator(map[string]string{"token-1": "u1"}, secret)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	ticket, expires, err := authenticator.IssueTicket("u1")
+	if err != nil {
+		t.Fatalf("IssueTicket() error = %v", err)
+	}
+	if expires.Before(time.Now()) || expires.After(time.Now().Add(TicketTTL)) {
+		t.Errorf("IssueTicket() expires at %v", expires)
+	}
+	if got, err := authenticator.AuthenticateTicket(ticket); err != nil || got != "u1" {
+		t.Errorf("AuthenticateTicket() = %q, %v, want u1", got, err)
+	}
+
+	if _, err := authenticator.Authenticate("Bearer " + ticket); err == nil {
+		t.Error("Authenticate() accepted ticket as bearer token")
+	}
+	token := signToken(t, jwt.SigningMethodHS256, secret, jwt.RegisteredClaims{Subject: "u1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))})
+	if _, err := authenticator.AuthenticateTicket(token); err == nil {
+		t.Error("AuthenticateTicket() accepted bearer token as ticket")
+	}
+	other, err := NewAuthenticator(map[string]string{"token-1": "u1"}, secret)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	if _, err := other.AuthenticateTicket(ticket); err == nil {
+		t.Error("AuthenticateTicket() accepted ticket of other authenticator")
+	}
+}
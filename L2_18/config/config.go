@@ -0,0 +1,457 @@
+// Package config provides settings of calendar server read from YAML or TOML file,
+// overridden by CALENDAR_* environment variables and then by command line flags
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Storage backends
+const (
+	StorageMemory = "memory"
+	StorageFile   = "file"
+)
+
+// Duration is time.Duration written in config file as string like "5s" or "1m30s"
+type Duration time.Duration
+
+// UnmarshalText parses duration string
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("Invalid duration %q: expected value like 5s or 1m30s", string(text))
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText formats duration as string
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// TLS holds certificate and key of HTTPS server, server uses plain HTTP if both are empty
+type TLS struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+}
+
+// Enabled reports whether server should use HTTPS
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Storage selects where events are kept
+type Storage struct {
+	// Backend is "memory" or "file"
+	Backend string `yaml:"backend" toml:"backend"`
+	// Path is log file of file backend, state of reminders and webhooks is kept
+	// next to it in files with .reminders and .webhooks suffixes. Log is compacted
+	// in the same directory, so it must be writable
+	Path string `yaml:"path" toml:"path"`
+}
+
+// Auth enables authentication, requests act for user_id they pass if both fields are empty.
+// Server without authentication may listen only on loopback address
+type Auth struct {
+	// TokensFile holds "<token> <user_id>" lines
+	TokensFile string `yaml:"tokens_file" toml:"tokens_file"`
+	// JWTSecret verifies HS256 JWTs
+	JWTSecret string `yaml:"jwt_secret" toml:"jwt_secret"`
+}
+
+// Enabled reports whether requests must be authenticated
+func (a Auth) Enabled() bool {
+	return a.TokensFile != "" || a.JWTSecret != ""
+}
+
+// RateLimit bounds requests of every user or, for unauthenticated requests, of every client IP
+type RateLimit struct {
+	// RequestsPerSecond is rate client may sustain, 0 disables limiting
+	RequestsPerSecond float64 `yaml:"requests_per_second" toml:"requests_per_second"`
+	// Burst is how many requests client may make at once
+	Burst int `yaml:"burst" toml:"burst"`
+}
+
+// Config is complete settings of calendar server
+type Config struct {
+	// Listen is TCP address of server like ":8080" or "127.0.0.1:8080".
+	// Without authentication it must be loopback address
+	Listen string `yaml:"listen" toml:"listen"`
+	TLS    TLS    `yaml:"tls" toml:"tls"`
+	// ReadTimeout bounds reading of whole request, 0 disables it
+	ReadTimeout Duration `yaml:"read_timeout" toml:"read_timeout"`
+	// WriteTimeout bounds writing of response, 0 disables it.
+	// Live event streams are cut when it passes, so it is disabled by default
+	WriteTimeout Duration `yaml:"write_timeout" toml:"write_timeout"`
+	// ShutdownGrace is how long running requests and reminders being delivered may finish on shutdown
+	ShutdownGrace Duration `yaml:"shutdown_grace" toml:"shutdown_grace"`
+	Storage       Storage  `yaml:"storage" toml:"storage"`
+	// LogLevel is debug, info, warn or error
+	LogLevel string `yaml:"log_level" toml:"log_level"`
+	// Debug enables debug mode of GIN with its route listing
+	Debug bool `yaml:"debug" toml:"debug"`
+	// CORSOrigins are origins browsers may call API from, "*" allows any, CORS is off if empty
+	CORSOrigins []string `yaml:"cors_origins" toml:"cors_origins"`
+	Auth        Auth     `yaml:"auth" toml:"auth"`
+	// ReminderWebhook is URL reminders are posted to, they are logged if empty
+	ReminderWebhook string    `yaml:"reminder_webhook" toml:"reminder_webhook"`
+	RateLimit       RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	// TrustedProxies are IPs or CIDRs of reverse proxies whose X-Forwarded-For headers
+	// give client IP, headers are ignored if empty
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// MaxBodyBytes caps size of request bodies
+	MaxBodyBytes int64 `yaml:"max_body_bytes" toml:"max_body_bytes"`
+	// WebhookAllowedNetworks are IPs or CIDRs of loopback, private or link-local networks
+	// webhooks may be sent to, such addresses are rejected otherwise
+	WebhookAllowedNetworks []string `yaml:"webhook_allowed_networks" toml:"webhook_allowed_networks"`
+}
+
+// Default returns settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		Listen:        "127.0.0.1:8080",
+		ReadTimeout:   Duration(15 * time.Second),
+		ShutdownGrace: Duration(5 * time.Second),
+		Storage:       Storage{Backend: StorageMemory},
+		LogLevel:      "info",
+		RateLimit:     RateLimit{RequestsPerSecond: 20, Burst: 40},
+		MaxBodyBytes:  1 << 20,
+	}
+}
+
+// Load builds settings from defaults, config file, environment and command line arguments.
+// Config file is given by -config flag or CALENDAR_CONFIG variable, its format is chosen
+// by extension: .yaml, .yml or .toml. For compatibility port and storage file path
+// may still be given as positional arguments
+func Load(args []string, getenv func(string) string) (Config, error) {
+	cfg := Default()
+
+	flags := flag.NewFlagSet("calendar", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	configPath := flags.String("config", getenv("CALENDAR_CONFIG"), "path of YAML or TOML config file")
+	listen := flags.String("listen", "", "listen address like 127.0.0.1:8080, other than loopback needs authentication")
+	certFile := flags.String("tls-cert", "", "TLS certificate file")
+	keyFile := flags.String("tls-key", "", "TLS key file")
+	readTimeout := flags.Duration("read-timeout", 0, "max duration of reading request")
+	writeTimeout := flags.Duration("write-timeout", 0, "max duration of writing response")
+	shutdownGrace := flags.Duration("shutdown-grace", 0, "time running requests and reminder deliveries get on shutdown")
+	backend := flags.String("storage", "", "storage backend: memory or file")
+	storagePath := flags.String("storage-path", "", "log file of file storage")
+	logLevel := flags.String("log-level", "", "log level: debug, info, warn or error")
+	debug := flags.Bool("debug", false, "enable GIN debug mode")
+	corsOrigins := flags.String("cors-origins", "", "comma separated origins allowed by CORS")
+	rateLimit := flags.Float64("rate-limit", 0, "requests per second of every client, 0 disables limiting")
+	rateBurst := flags.Int("rate-burst", 0, "requests client may make at once")
+	trustedProxies := flags.String("trusted-proxies", "", "comma separated IPs or CIDRs of reverse proxies")
+	maxBodyBytes := flags.Int64("max-body-bytes", 0, "max size of request body")
+	webhookNetworks := flags.String("webhook-allowed-networks", "", "comma separated internal IPs or CIDRs webhooks may be sent to")
+	if err := flags.Parse(args); err != nil {
+		var usage strings.Builder
+		flags.SetOutput(&usage)
+		flags.PrintDefaults()
+		return Config{}, fmt.Errorf("Invalid arguments: %w\nUsage: calendar [flags] [port [storage_path]]\n%s", err, usage.String())
+	}
+
+	if *configPath != "" {
+		if err := cfg.readFile(*configPath); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.applyEnv(getenv); err != nil {
+		return Config{}, err
+	}
+
+	positional := flags.Args()
+	if len(positional) > 2 {
+		return Config{}, fmt.Errorf("Unexpected arguments: %s", strings.Join(positional[2:], " "))
+	}
+	if len(positional) > 0 {
+		host, _, _ := net.SplitHostPort(cfg.Listen)
+		cfg.Listen = net.JoinHostPort(host, positional[0])
+	}
+	if len(positional) > 1 {
+		cfg.Storage = Storage{Backend: StorageFile, Path: positional[1]}
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "tls-cert":
+			cfg.TLS.CertFile = *certFile
+		case "tls-key":
+			cfg.TLS.KeyFile = *keyFile
+		case "read-timeout":
+			cfg.ReadTimeout = Duration(*readTimeout)
+		case "write-timeout":
+			cfg.WriteTimeout = Duration(*writeTimeout)
+		case "shutdown-grace":
+			cfg.ShutdownGrace = Duration(*shutdownGrace)
+		case "storage":
+			cfg.Storage.Backend = *backend
+		case "storage-path":
+			cfg.Storage.Path = *storagePath
+		case "log-level":
+			cfg.LogLevel = *logLevel
+		case "debug":
+			cfg.Debug = *debug
+		case "cors-origins":
+			cfg.CORSOrigins = splitList(*corsOrigins)
+		case "rate-limit":
+			cfg.RateLimit.RequestsPerSecond = *rateLimit
+		case "rate-burst":
+			cfg.RateLimit.Burst = *rateBurst
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*trustedProxies)
+		case "max-body-bytes":
+			cfg.MaxBodyBytes = *maxBodyBytes
+		case "webhook-allowed-networks":
+			cfg.WebhookAllowedNetworks = splitList(*webhookNetworks)
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// readFile overrides settings with those present in config file
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file %s: %w", path, err)
+	}
+
+	// unknown keys are rejected, so misspelled settings dont pass silently
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(c); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case ".toml":
+		decoder := toml.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	default:
+		return fmt.Errorf("Unsupported config file %s: expected .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides settings with non-empty CALENDAR_* variables
+func (c *Config) applyEnv(getenv func(string) string) error {
+	texts := map[string]*string{
+		"CALENDAR_LISTEN":           &c.Listen,
+		"CALENDAR_TLS_CERT":         &c.TLS.CertFile,
+		"CALENDAR_TLS_KEY":          &c.TLS.KeyFile,
+		"CALENDAR_STORAGE":          &c.Storage.Backend,
+		"CALENDAR_STORAGE_PATH":     &c.Storage.Path,
+		"CALENDAR_LOG_LEVEL":        &c.LogLevel,
+		"CALENDAR_TOKENS_FILE":      &c.Auth.TokensFile,
+		"CALENDAR_JWT_SECRET":       &c.Auth.JWTSecret,
+		"CALENDAR_REMINDER_WEBHOOK": &c.ReminderWebhook,
+	}
+	for name, field := range texts {
+		if value := getenv(name); value != "" {
+			*field = value
+		}
+	}
+
+	durations := map[string]*Duration{
+		"CALENDAR_READ_TIMEOUT":   &c.ReadTimeout,
+		"CALENDAR_WRITE_TIMEOUT":  &c.WriteTimeout,
+		"CALENDAR_SHUTDOWN_GRACE": &c.ShutdownGrace,
+	}
+	for name, field := range durations {
+		if value := getenv(name); value != "" {
+			if err := field.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("Invalid %s: %w", name, err)
+			}
+		}
+	}
+
+	if value := getenv("CALENDAR_DEBUG"); value != "" {
+		c.Debug = value == "1" || value == "true"
+	}
+	if value := getenv("CALENDAR_CORS_ORIGINS"); value != "" {
+		c.CORSOrigins = splitList(value)
+	}
+	if value := getenv("CALENDAR_TRUSTED_PROXIES"); value != "" {
+		c.TrustedProxies = splitList(value)
+	}
+	if value := getenv("CALENDAR_WEBHOOK_ALLOWED_NETWORKS"); value != "" {
+		c.WebhookAllowedNetworks = splitList(value)
+	}
+
+	numbers := map[string]func(string) error{
+		"CALENDAR_RATE_LIMIT": func(value string) (err error) {
+			c.RateLimit.RequestsPerSecond, err = strconv.ParseFloat(value, 64)
+			return err
+		},
+		"CALENDAR_RATE_BURST": func(value string) (err error) {
+			c.RateLimit.Burst, err = strconv.Atoi(value)
+			return err
+		},
+		"CALENDAR_MAX_BODY_BYTES": func(value string) (err error) {
+			c.MaxBodyBytes, err = strconv.ParseInt(value, 10, 64)
+			return err
+		},
+	}
+	for name, set := range numbers {
+		if value := getenv(name); value != "" {
+			if err := set(value); err != nil {
+				return fmt.Errorf("Invalid %s %q: expected number", name, value)
+			}
+		}
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isLoopback reports whether host of listen address accepts only local connections
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Validate checks settings and reports all problems at once
+func (c Config) Validate() error {
+	problems := []error{}
+
+	if host, _, err := net.SplitHostPort(c.Listen); err != nil {
+		problems = append(problems, fmt.Errorf("Invalid listen address %q: %v", c.Listen, err))
+	} else if !c.Auth.Enabled() && !isLoopback(host) {
+		problems = append(problems, fmt.Errorf("Listen address %q is not loopback while authentication is disabled, set tokens file or JWT secret", c.Listen))
+	}
+
+	if c.TLS.Enabled() {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			problems = append(problems, fmt.Errorf("TLS needs both certificate and key files"))
+		}
+		for _, path := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+			if path == "" {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				problems = append(problems, fmt.Errorf("Invalid TLS file: %v", err))
+			}
+		}
+	}
+
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 {
+		problems = append(problems, fmt.Errorf("Timeouts cant be negative"))
+	}
+	if c.ShutdownGrace <= 0 {
+		problems = append(problems, fmt.Errorf("Shutdown grace must be positive"))
+	}
+
+	switch c.Storage.Backend {
+	case StorageMemory:
+	case StorageFile:
+		if c.Storage.Path == "" {
+			problems = append(problems, fmt.Errorf("File storage needs path"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("Invalid storage backend %q: expected memory or file", c.Storage.Backend))
+	}
+
+	if c.RateLimit.RequestsPerSecond < 0 {
+		problems = append(problems, fmt.Errorf("Rate limit cant be negative"))
+	}
+	if c.RateLimit.RequestsPerSecond > 0 && c.RateLimit.Burst < 1 {
+		problems = append(problems, fmt.Errorf("Rate limit burst must be at least 1"))
+	}
+	if c.MaxBodyBytes <= 0 {
+		problems = append(problems, fmt.Errorf("Max body size must be positive"))
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problems = append(problems, fmt.Errorf("Invalid trusted proxy %q: expected IP or CIDR", proxy))
+		}
+	}
+
+	if _, err := c.WebhookNetworks(); err != nil {
+		problems = append(problems, err)
+	}
+
+	if _, err := c.Level(); err != nil {
+		problems = append(problems, err)
+	}
+
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			strings.TrimSuffix(parsed.Path, "/") != "" {
+			problems = append(problems, fmt.Errorf("Invalid CORS origin %q: expected * or scheme://host[:port]", origin))
+		}
+	}
+
+	if err := errors.Join(problems...); err != nil {
+		return fmt.Errorf("Invalid configuration: %w", err)
+	}
+	return nil
+}
+
+// WebhookNetworks returns parsed WebhookAllowedNetworks, single IPs become networks of one address
+func (c Config) WebhookNetworks() ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(c.WebhookAllowedNetworks))
+	for _, value := range c.WebhookAllowedNetworks {
+		network, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return nil, fmt.Errorf("Invalid webhook network %q: expected IP or CIDR", value)
+			}
+			network = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks, nil
+}
+
+// Level returns slog level of LogLevel
+func (c Config) Level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("Invalid log level %q: expected debug, info, warn or error", c.LogLevel)
+	}
+	return level, nil
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "calendar.yaml", `
+listen: ":9000"
+read_timeout: 30s
+shutdown_grace: 10s
+storage:
+  backend: file
+  path: events.log
+log_level: debug
+cors_origins: ["https://app.example.com"]
+`)
+
+	cfg, err := Load([]string{"-config", path, "-shutdown-grace", "20s"}, env(map[string]string{
+		"CALENDAR_LISTEN":       ":9100",
+		"CALENDAR_JWT_SECRET":   "secret",
+		"CALENDAR_READ_TIMEOUT": "45s",
+	}))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Listen != ":9100" || cfg.Auth.JWTSecret != "secret" {
+		t.Errorf("environment not applied: %+v", cfg)
+	}
+	if time.Duration(cfg.ReadTimeout) != 45*time.Second || time.Duration(cfg.ShutdownGrace) != 20*time.Second {
+		t.Errorf("timeouts = %v, %v, want 45s, 20s", time.Duration(cfg.ReadTimeout), time.Duration(cfg.ShutdownGrace))
+	}
+	if cfg.Storage.Backend != StorageFile || cfg.Storage.Path != "events.log" || cfg.LogLevel != "debug" {
+		t.Errorf("file settings not applied: %+v", cfg)
+	}
+	if len(cfg.CORSOrigins) != 1 || cfg.CORSOrigins[0] != "https://app.example.com" {
+		t.Errorf("CORS origins = %v", cfg.CORSOrigins)
+	}
+}
+
+func TestLoadTOMLAndPositional(t *testing.T) {
+	path := writeFile(t, "calendar.toml", `
+listen = "127.0.0.1:9000"
+write_timeout = "1m"
+
+[storage]
+backend = "memory"
+`)
+
+	cfg, err := Load([]string{"-config", path, "8081", "events.log"}, env(nil))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Listen != "127.0.0.1:8081" || cfg.Storage.Backend != StorageFile || cfg.Storage.Path != "events.log" {
+		t.Errorf("positional arguments not applied: %+v", cfg)
+	}
+	if time.Duration(cfg.WriteTimeout) != time.Minute {
+		t.Errorf("write timeout = %v, want 1m", time.Duration(cfg.WriteTimeout))
+	}
+
+	cfg, err = Load(nil, env(nil))
+	if err != nil || cfg.Listen != "127.0.0.1:8080" || cfg.Storage.Backend != StorageMemory {
+		t.Errorf("Load() defaults = %+v, %v", cfg, err)
+	}
+
+	for _, listen := range []string{"localhost:8080", "[::1]:8080"} {
+		if _, err := Load([]string{"-listen", listen}, env(nil)); err != nil {
+			t.Errorf("Load() of loopback %s without auth error = %v", listen, err)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		expect string
+	}{
+		{"unknown key", []string{"-config", writeFile(t, "bad.yaml", "listn: \":80\"\n")}, nil, "listn"},
+		{"unknown format", []string{"-config", writeFile(t, "calendar.ini", "")}, nil, "Unsupported config file"},
+		{"bad duration", nil, map[string]string{"CALENDAR_SHUTDOWN_GRACE": "soon"}, "CALENDAR_SHUTDOWN_GRACE"},
+		{"unknown flag", []string{"-port", "80"}, nil, "Usage"},
+		{"half of TLS", []string{"-tls-cert", "missing.pem"}, nil, "both certificate and key"},
+		{"file without path", []string{"-storage", "file"}, nil, "needs path"},
+		{"bad level", []string{"-log-level", "loud"}, nil, "Invalid log level"},
+		{"bad origin", []string{"-cors-origins", "example.com"}, nil, "Invalid CORS origin"},
+		{"bad listen", []string{"-listen", "8080"}, nil, "Invalid listen address"},
+		{"public without auth", []string{"-listen", ":8080"}, nil, "not loopback"},
+		{"public positional port", []string{"-listen", "0.0.0.0:80", "8080"}, nil, "not loopback"},
+		{"bad rate", nil, map[string]string{"CALENDAR_RATE_LIMIT": "fast"}, "CALENDAR_RATE_LIMIT"},
+		{"no burst", []string{"-rate-limit", "5", "-rate-burst", "0"}, nil, "burst"},
+		{"no body", []string{"-max-body-bytes", "0"}, nil, "Max body size"},
+		{"bad proxy", nil, map[string]string{"CALENDAR_TRUSTED_PROXIES": "10.0.0.1, proxy.local"}, "proxy.local"},
+		{"bad webhook network", []string{"-webhook-allowed-networks", "10.0.0.0/8,intranet"}, nil, "intranet"},
+	}
+	for _, tt := range tests {
+		_, err := Load(tt.args, env(tt.env))
+		if err == nil || !strings.Contains(err.Error(), tt.expect) {
+			t.Errorf("%s: Load() error = %v, want %q", tt.name, err, tt.expect)
+		}
+	}
+
+	_, err := Load([]string{"-storage", "disk", "-log-level", "loud"}, env(nil))
+	if err == nil || !strings.Contains(err.Error(), "storage backend") || !strings.Contains(err.Error(), "log level") {
+		t.Errorf("Load() error = %v, want all problems reported", err)
+	}
+}
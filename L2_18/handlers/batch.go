@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// batchRequest is JSON body of batch of event changes
+type batchRequest struct {
+	Atomic     bool `json:"atomic"`
+	Operations []struct {
+		Action string       `json:"action"`
+		ID     string       `json:"id"`
+		Event  eventRequest `json:"event"`
+	} `json:"operations"`
+}
+
+// batchResult is outcome of single operation in response to best-effort batch
+type batchResult struct {
+	ID     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`
+}
+
+// successStatus is status single request of batch action answers with
+var successStatus = map[calendar.BatchAction]int{
+	calendar.BatchCreate: http.StatusCreated,
+	calendar.BatchUpdate: http.StatusOK,
+	calendar.BatchDelete: http.StatusNoContent,
+}
+
+// RESTBatchHandle handles POST /api/v1/users/:user_id/events/batch.
+// Every operation is charged to rate limit of client like single request, following requests
+// of client wait until large batch is paid off.
+// Update changes only fields present in event like PATCH. Atomic batch answers
+// with error of first failed operation and its index, nothing is saved then.
+// Otherwise every operation is tried and results keep status and error of each
+func RESTBatchHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	var request batchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
+		return
+	}
+	chargeOperations(c, len(request.Operations))
+
+	operations := make([]calendar.BatchOperation, 0, len(request.Operations))
+	for _, operation := range request.Operations {
+		operations = append(operations, calendar.BatchOperation{
+			Action: calendar.BatchAction(operation.Action),
+			ID:     operation.ID,
+			Data:   operation.Event.data(),
+		})
+	}
+
+	results, err := calendarDB.Batch(userID, operations, request.Atomic)
+	if err != nil {
+		var batchErr *calendar.BatchError
+		if errors.As(err, &batchErr) {
+			writeCalendarErrorFields(c, err, http.StatusUnprocessableEntity, gin.H{"index": batchErr.Index})
+			return
+		}
+		writeEventError(c, err)
+		return
+	}
+
+	response := make([]batchResult, 0, len(results))
+	failed := 0
+	for i, result := range results {
+		item := batchResult{ID: result.ID, Status: successStatus[operations[i].Action]}
+		if result.Err != nil {
+			failed++
+			item.Status, item.Code = calendarErrorStatus(result.Err, http.StatusUnprocessableEntity)
+			item.Error = result.Err.Error()
+		}
+		response = append(response, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   response,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+	})
+}
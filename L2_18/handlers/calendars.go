@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// calendarView is calendar as seen by requesting user
+type calendarView struct {
+	calendar.Collection
+	Access  calendar.Access `json:"access"`
+	Default bool            `json:"default"`
+}
+
+func newCalendarView(collection calendar.Collection, userID string) calendarView {
+	return calendarView{
+		Collection: collection,
+		Access:     collection.Access(userID),
+		Default:    collection.IsDefault(),
+	}
+}
+
+// bindCalendarJSON reads JSON body into request and writes error response if it is malformed
+func bindCalendarJSON(c *gin.Context, request any) bool {
+	if err := c.ShouldBindJSON(request); err != nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeCalendar writes calendar as seen by user or error of changing it
+func writeCalendar(c *gin.Context, collection calendar.Collection, userID string, err error) {
+	if err != nil {
+		writeEventError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newCalendarView(collection, userID))
+}
+
+// CalendarListHandle handles GET /api/v1/users/:user_id/calendars
+func CalendarListHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	collections, err := calendarDB.Collections(userID)
+	if err != nil {
+		writeCalendarError(c, err)
+		return
+	}
+
+	views := make([]calendarView, 0, len(collections))
+	for _, collection := range collections {
+		views = append(views, newCalendarView(collection, userID))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"calendars": views,
+		"count":     len(views),
+	})
+}
+
+// CalendarCreateHandle handles POST /api/v1/users/:user_id/calendars
+func CalendarCreateHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if !bindCalendarJSON(c, &request) {
+		return
+	}
+
+	collection, err := calendarDB.CreateCollection(userID, request.Name)
+	if err != nil {
+		writeEventError(c, err)
+		return
+	}
+
+	c.Header("Location", "/api/v1/users/"+userID+"/calendars/"+collection.ID)
+	c.JSON(http.StatusCreated, newCalendarView(collection, userID))
+}
+
+// CalendarGetHandle handles GET /api/v1/users/:user_id/calendars/:calendar_id
+func CalendarGetHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	collection, err := calendarDB.Collection(userID, c.Param("calendar_id"))
+	writeCalendar(c, collection, userID, err)
+}
+
+// CalendarRenameHandle handles PATCH /api/v1/users/:user_id/calendars/:calendar_id
+func CalendarRenameHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Name string `json:"name"`
+	}
+	if !bindCalendarJSON(c, &request) {
+		return
+	}
+
+	collection, err := calendarDB.RenameCollection(userID, c.Param("calendar_id"), request.Name)
+	writeCalendar(c, collection, userID, err)
+}
+
+// CalendarDeleteHandle handles DELETE /api/v1/users/:user_id/calendars/:calendar_id.
+// Events of calendar are deleted too
+func CalendarDeleteHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	if err := calendarDB.DeleteCollection(userID, c.Param("calendar_id")); err != nil {
+		writeEventError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CalendarShareHandle handles PUT /api/v1/users/:user_id/calendars/:calendar_id/shares/:share_user_id
+func CalendarShareHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Access string `json:"access" binding:"required"`
+	}
+	if !bindCalendarJSON(c, &request) {
+		return
+	}
+
+	collection, err := calendarDB.ShareCollection(userID, c.Param("calendar_id"), c.Param("share_user_id"), calendar.Access(request.Access))
+	writeCalendar(c, collection, userID, err)
+}
+
+// CalendarUnshareHandle handles DELETE /api/v1/users/:user_id/calendars/:calendar_id/shares/:share_user_id
+func CalendarUnshareHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	collection, err := calendarDB.UnshareCollection(userID, c.Param("calendar_id"), c.Param("share_user_id"))
+	writeCalendar(c, collection, userID, err)
+}
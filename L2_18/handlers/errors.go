@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/internal"
+	"github.com/venexene/calendar/webhook"
+)
+
+// Codes of error responses, clients can branch on them
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeValidation     = "validation_failed"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeTooLarge       = "request_too_large"
+	CodeRateLimited    = "rate_limited"
+	CodeInternal       = "internal_error"
+)
+
+// writeError writes error envelope {"error": message, "code": code}.
+// Code is kept in context for logs and metrics, server errors are logged with their message
+func writeError(c *gin.Context, status int, code string, message string) {
+	writeErrorFields(c, status, code, message, nil)
+}
+
+// writeErrorFields writes error envelope with extra fields
+func writeErrorFields(c *gin.Context, status int, code string, message string, fields gin.H) {
+	c.Set("error_code", code)
+	if status >= http.StatusInternalServerError {
+		requestLogger(c).Error("Request failed", "code", code, "error", message)
+	}
+	body := gin.H{
+		"error": message,
+		"code":  code,
+	}
+	for key, value := range fields {
+		body[key] = value
+	}
+	c.AbortWithStatusJSON(status, body)
+}
+
+// writeCalendarError writes response for error returned by calendar
+func writeCalendarError(c *gin.Context, err error) {
+	writeCalendarErrorStatus(c, err, http.StatusBadRequest)
+}
+
+// writeCalendarErrorStatus writes response for calendar error using given status for invalid input
+func writeCalendarErrorStatus(c *gin.Context, err error, validationStatus int) {
+	writeCalendarErrorFields(c, err, validationStatus, nil)
+}
+
+// writeCalendarErrorFields writes response for calendar error with extra fields.
+// Conflicts add events change clashes with
+func writeCalendarErrorFields(c *gin.Context, err error, validationStatus int, fields gin.H) {
+	status, code := calendarErrorStatus(err, validationStatus)
+	var conflict *calendar.ConflictError
+	if errors.As(err, &conflict) {
+		withConflicts := gin.H{"conflicts": conflict.Events}
+		for key, value := range fields {
+			withConflicts[key] = value
+		}
+		fields = withConflicts
+	}
+	writeErrorFields(c, status, code, err.Error(), fields)
+}
+
+// calendarErrorStatus returns status and code of response for calendar error
+func calendarErrorStatus(err error, validationStatus int) (int, string) {
+	switch {
+	case errors.Is(err, calendar.ErrValidation):
+		return validationStatus, CodeValidation
+	case errors.Is(err, calendar.ErrNotFound), errors.Is(err, webhook.ErrNotFound):
+		return http.StatusNotFound, CodeNotFound
+	case errors.Is(err, calendar.ErrForbidden):
+		return http.StatusForbidden, CodeForbidden
+	case errors.Is(err, calendar.ErrConflict):
+		return http.StatusConflict, CodeConflict
+	default:
+		return http.StatusInternalServerError, CodeInternal
+	}
+}
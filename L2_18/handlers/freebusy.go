@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// FreeBusyHandle handles GET /api/v1/users/:user_id/freebusy.
+// Users are given by repeated user parameter, requesting user is queried if there are none
+func FreeBusyHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Users    []string `form:"user"`
+		From     string   `form:"from" binding:"required"`
+		To       string   `form:"to" binding:"required"`
+		TimeZone string   `form:"time_zone"`
+		Duration string   `form:"duration"`
+		DayStart string   `form:"day_start"`
+		DayEnd   string   `form:"day_end"`
+	}
+	if err := c.ShouldBindQuery(&request); err != nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid query: "+err.Error())
+		return
+	}
+	if len(request.Users) == 0 {
+		request.Users = []string{userID}
+	}
+
+	result, err := calendarDB.FreeBusy(calendar.FreeBusyQuery{
+		Users:    request.Users,
+		From:     request.From,
+		To:       request.To,
+		TimeZone: request.TimeZone,
+		Duration: request.Duration,
+		DayStart: request.DayStart,
+		DayEnd:   request.DayEnd,
+	})
+	if err != nil {
+		writeCalendarError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -2,13 +2,20 @@
 package handlers
 
 import (
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/venexene/calendar/auth"
 	"github.com/venexene/calendar/internal"
 )
 
@@ -23,224 +30,226 @@ func TestServerHandle(c *gin.Context) {
 func AddHandle(c *gin.Context) {
 	db, exists := c.Get("calendar")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Calendar not available",
-		})
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
 		return
 	}
 	calendarDB := db.(*calendar.Calendar)
 
 	var request struct {
-		UserID string `form:"user_id" json:"user_id" binding:"required"`
-		Date   string `form:"date" json:"date" binding:"required"`
-		Event  string `form:"event" json:"event" binding:"required"`
+		UserID     string              `form:"user_id" json:"user_id"`
+		Date       string              `form:"date" json:"date"`
+		Start      string              `form:"start" json:"start"`
+		End        string              `form:"end" json:"end"`
+		AllDay     bool                `form:"all_day" json:"all_day"`
+		TimeZone   string              `form:"time_zone" json:"time_zone"`
+		Recurrence string              `form:"recurrence" json:"recurrence"`
+		Exceptions []string            `form:"exceptions" json:"exceptions"`
+		Reminders  []string            `form:"reminders" json:"reminders"`
+		OnConflict string              `form:"on_conflict" json:"on_conflict"`
+		Event      string              `form:"event" json:"event" binding:"required"`
+		Title      *string             `form:"title" json:"title"`
+		Location   *string             `form:"location" json:"location"`
+		Category   *string             `form:"category" json:"category"`
+		Color      *string             `form:"color" json:"color"`
+		Tags       []string            `form:"tags" json:"tags"`
+		Attendees  []calendar.Attendee `form:"attendees" json:"attendees"`
 	}
 
 	contentType := c.Request.Header.Get("Content-Type")
 	if strings.Contains(contentType, "application/json") {
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid JSON data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
 			return
 		}
 	} else {
 		if err := c.ShouldBind(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid form data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid form data: "+err.Error())
 			return
 		}
 	}
 
-	if request.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "UserID is required",
-		})
+	userID, ok := requestUserID(c, request.UserID)
+	if !ok {
 		return
 	}
 
-	if request.Date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Date is required",
-		})
+	if request.Date == "" && request.Start == "" {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Date or start is required")
 		return
 	}
 
 	if request.Event == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Event is required",
-		})
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Event is required")
 		return
 	}
 
-	err := calendarDB.Add(request.UserID, request.Date, request.Event)
+	id, err := calendarDB.Add(userID, calendar.EventData{
+		Date:       request.Date,
+		Start:      request.Start,
+		End:        request.End,
+		AllDay:     &request.AllDay,
+		TimeZone:   request.TimeZone,
+		Text:       request.Event,
+		Recurrence: request.Recurrence,
+		Exceptions: request.Exceptions,
+		Reminders:  request.Reminders,
+		OnConflict: calendar.ConflictMode(request.OnConflict),
+		Title:      request.Title,
+		Location:   request.Location,
+		Category:   request.Category,
+		Color:      request.Color,
+		Tags:       request.Tags,
+		Attendees:  request.Attendees,
+	})
 	if err != nil {
-		if err.Error() == "Invalid date" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
-		} else {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"error": err.Error(),
-			})
-		}
+		writeCalendarError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"result": "New event created successfully",
-	})
+		"id":     id,
+	}
+	if conflicts := conflictIDs(c, calendarDB, userID, id); len(conflicts) != 0 {
+		response["conflicts"] = conflicts
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // UpdateHandle handles update requests
 func UpdateHandle(c *gin.Context) {
 	db, exists := c.Get("calendar")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Calendar not available",
-		})
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
 		return
 	}
 	calendarDB := db.(*calendar.Calendar)
 
 	var request struct {
-		UserID   string `form:"user_id" json:"user_id" binding:"required"`
-		Date     string `form:"date" json:"date" binding:"required"`
-		Event    string `form:"event" json:"event" binding:"required"`
-		NewEvent string `form:"new_event" json:"new_event" binding:"required"`
+		UserID     string              `form:"user_id" json:"user_id"`
+		ID         string              `form:"id" json:"id" binding:"required"`
+		Date       string              `form:"date" json:"date"`
+		Start      string              `form:"start" json:"start"`
+		End        string              `form:"end" json:"end"`
+		AllDay     *bool               `form:"all_day" json:"all_day"`
+		TimeZone   string              `form:"time_zone" json:"time_zone"`
+		Recurrence string              `form:"recurrence" json:"recurrence"`
+		Exceptions []string            `form:"exceptions" json:"exceptions"`
+		Reminders  []string            `form:"reminders" json:"reminders"`
+		OnConflict string              `form:"on_conflict" json:"on_conflict"`
+		Event      string              `form:"event" json:"event"`
+		Title      *string             `form:"title" json:"title"`
+		Location   *string             `form:"location" json:"location"`
+		Category   *string             `form:"category" json:"category"`
+		Color      *string             `form:"color" json:"color"`
+		Tags       []string            `form:"tags" json:"tags"`
+		Attendees  []calendar.Attendee `form:"attendees" json:"attendees"`
 	}
 
 	contentType := c.Request.Header.Get("Content-Type")
 	if strings.Contains(contentType, "application/json") {
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid JSON data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
 			return
 		}
 	} else {
 		if err := c.ShouldBind(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid form data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid form data: "+err.Error())
 			return
 		}
 	}
 
-	if request.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "UserID is required",
-		})
+	userID, ok := requestUserID(c, request.UserID)
+	if !ok {
 		return
 	}
 
-	if request.Date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Date is required",
-		})
+	if request.ID == "" {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "ID is required")
 		return
 	}
 
-	if request.Event == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Event is required",
-		})
-		return
-	}
-
-	if request.NewEvent == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "New event is required",
-		})
+	if request.Event == "" && request.Date == "" && request.Start == "" && request.End == "" &&
+		request.AllDay == nil && request.TimeZone == "" &&
+		request.Recurrence == "" && request.Exceptions == nil && request.Reminders == nil &&
+		request.Title == nil && request.Location == nil && request.Category == nil &&
+		request.Color == nil && request.Tags == nil && request.Attendees == nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Nothing to update")
 		return
 	}
 
-	err := calendarDB.Update(request.UserID, request.Date, request.Event, request.NewEvent)
+	err := calendarDB.Update(userID, request.ID, calendar.EventData{
+		Date:       request.Date,
+		Start:      request.Start,
+		End:        request.End,
+		AllDay:     request.AllDay,
+		TimeZone:   request.TimeZone,
+		Text:       request.Event,
+		Recurrence: request.Recurrence,
+		Exceptions: request.Exceptions,
+		Reminders:  request.Reminders,
+		OnConflict: calendar.ConflictMode(request.OnConflict),
+		Title:      request.Title,
+		Location:   request.Location,
+		Category:   request.Category,
+		Color:      request.Color,
+		Tags:       request.Tags,
+		Attendees:  request.Attendees,
+	})
 	if err != nil {
-		if err.Error() == "Invalid date" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
-		} else {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"error": err.Error(),
-			})
-		}
+		writeCalendarError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"result": "Event updated successfully",
-	})
+	}
+	if conflicts := conflictIDs(c, calendarDB, userID, request.ID); len(conflicts) != 0 {
+		response["conflicts"] = conflicts
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // DeleteHandle handles delete requests
 func DeleteHandle(c *gin.Context) {
 	db, exists := c.Get("calendar")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Calendar not available",
-		})
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
 		return
 	}
 	calendarDB := db.(*calendar.Calendar)
 
 	var request struct {
-		UserID string `form:"user_id" json:"user_id" binding:"required"`
-		Date   string `form:"date" json:"date" binding:"required"`
-		Event  string `form:"event" json:"event" binding:"required"`
+		UserID string `form:"user_id" json:"user_id"`
+		ID     string `form:"id" json:"id" binding:"required"`
 	}
 
 	contentType := c.Request.Header.Get("Content-Type")
 	if strings.Contains(contentType, "application/json") {
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid JSON data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
 			return
 		}
 	} else {
 		if err := c.ShouldBind(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid form data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid form data: "+err.Error())
 			return
 		}
 	}
 
-	if request.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "UserID is required",
-		})
-		return
-	}
-
-	if request.Date == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Date is required",
-		})
+	userID, ok := requestUserID(c, request.UserID)
+	if !ok {
 		return
 	}
 
-	if request.Event == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Event is required",
-		})
+	if request.ID == "" {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "ID is required")
 		return
 	}
 
-	err := calendarDB.Delete(request.UserID, request.Date, request.Event)
+	err := calendarDB.Delete(userID, request.ID)
 	if err != nil {
-		if err.Error() == "Invalid date" {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": err.Error(),
-			})
-		} else {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"error": err.Error(),
-			})
-		}
+		writeCalendarError(c, err)
 		return
 	}
 
@@ -253,62 +262,55 @@ func DeleteHandle(c *gin.Context) {
 func DayEventsHandle(c *gin.Context) {
 	db, exists := c.Get("calendar")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Calendar not available",
-		})
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
 		return
 	}
 	calendarDB := db.(*calendar.Calendar)
 
 	var request struct {
-		UserID string `form:"user_id" json:"user_id" binding:"required"`
-		Day    string `form:"day" json:"day" binding:"required"`
+		UserID   string   `form:"user_id" json:"user_id"`
+		Day      string   `form:"day" json:"day" binding:"required"`
+		TimeZone string   `form:"time_zone" json:"time_zone"`
+		Category string   `form:"category" json:"category"`
+		Tags     []string `form:"tag" json:"tag"`
 	}
 
 	contentType := c.Request.Header.Get("Content-Type")
 	if strings.Contains(contentType, "application/json") {
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid JSON data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
 			return
 		}
 	} else {
 		if err := c.ShouldBind(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid form data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid form data: "+err.Error())
 			return
 		}
 	}
 
-	if request.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "UserID is required",
-		})
+	userID, ok := requestUserID(c, request.UserID)
+	if !ok {
 		return
 	}
 
 	if request.Day == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Day is required",
-		})
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Day is required")
 		return
 	}
 
-	events, err := calendarDB.GetEventsByDay(request.UserID, request.Day)
+	events, err := calendarDB.GetEventsByDay(userID, request.Day, request.TimeZone)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		writeCalendarError(c, err)
 		return
 	}
+	events = calendar.FilterEvents(events, request.Category, request.Tags)
 
 	c.JSON(http.StatusOK, gin.H{
-		"user_id": request.UserID,
-		"day":     request.Day,
-		"events":  events,
-		"count":   len(events),
+		"user_id":   userID,
+		"day":       request.Day,
+		"time_zone": request.TimeZone,
+		"events":    events,
+		"count":     len(events),
 	})
 }
 
@@ -316,62 +318,55 @@ func DayEventsHandle(c *gin.Context) {
 func WeekEventsHandle(c *gin.Context) {
 	db, exists := c.Get("calendar")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Calendar not available",
-		})
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
 		return
 	}
 	calendarDB := db.(*calendar.Calendar)
 
 	var request struct {
-		UserID string `form:"user_id" json:"user_id" binding:"required"`
-		Week   string `form:"week" json:"week" binding:"required"`
+		UserID   string   `form:"user_id" json:"user_id"`
+		Week     string   `form:"week" json:"week" binding:"required"`
+		TimeZone string   `form:"time_zone" json:"time_zone"`
+		Category string   `form:"category" json:"category"`
+		Tags     []string `form:"tag" json:"tag"`
 	}
 
 	contentType := c.Request.Header.Get("Content-Type")
 	if strings.Contains(contentType, "application/json") {
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid JSON data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
 			return
 		}
 	} else {
 		if err := c.ShouldBind(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid form data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid form data: "+err.Error())
 			return
 		}
 	}
 
-	if request.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "UserID is required",
-		})
+	userID, ok := requestUserID(c, request.UserID)
+	if !ok {
 		return
 	}
 
 	if request.Week == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Week is required",
-		})
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Week is required")
 		return
 	}
 
-	events, err := calendarDB.GetEventsByWeek(request.UserID, request.Week)
+	events, err := calendarDB.GetEventsByWeek(userID, request.Week, request.TimeZone)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		writeCalendarError(c, err)
 		return
 	}
+	events = calendar.FilterEvents(events, request.Category, request.Tags)
 
 	c.JSON(http.StatusOK, gin.H{
-		"user_id": request.UserID,
-		"week":    request.Week,
-		"events":  events,
-		"count":   len(events),
+		"user_id":   userID,
+		"week":      request.Week,
+		"time_zone": request.TimeZone,
+		"events":    events,
+		"count":     len(events),
 	})
 }
 
@@ -379,80 +374,255 @@ func WeekEventsHandle(c *gin.Context) {
 func MonthEventsHandle(c *gin.Context) {
 	db, exists := c.Get("calendar")
 	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Calendar not available",
-		})
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
 		return
 	}
 	calendarDB := db.(*calendar.Calendar)
 
 	var request struct {
-		UserID string `form:"user_id" json:"user_id" binding:"required"`
-		Month  string `form:"month" json:"month" binding:"required"`
+		UserID   string   `form:"user_id" json:"user_id"`
+		Month    string   `form:"month" json:"month" binding:"required"`
+		TimeZone string   `form:"time_zone" json:"time_zone"`
+		Category string   `form:"category" json:"category"`
+		Tags     []string `form:"tag" json:"tag"`
 	}
 
 	contentType := c.Request.Header.Get("Content-Type")
 	if strings.Contains(contentType, "application/json") {
 		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid JSON data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
 			return
 		}
 	} else {
 		if err := c.ShouldBind(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid form data: " + err.Error(),
-			})
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid form data: "+err.Error())
 			return
 		}
 	}
 
-	if request.UserID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "UserID is required",
-		})
+	userID, ok := requestUserID(c, request.UserID)
+	if !ok {
 		return
 	}
 
 	if request.Month == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Month is required",
-		})
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Month is required")
+		return
+	}
+
+	events, err := calendarDB.GetEventsByMonth(userID, request.Month, request.TimeZone)
+	if err != nil {
+		writeCalendarError(c, err)
 		return
 	}
+	events = calendar.FilterEvents(events, request.Category, request.Tags)
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":   userID,
+		"month":     request.Month,
+		"time_zone": request.TimeZone,
+		"events":    events,
+		"count":     len(events),
+	})
+}
+
+// RangeEventsHandle handles requests to get page of events within arbitrary range
+func RangeEventsHandle(c *gin.Context) {
+	db, exists := c.Get("calendar")
+	if !exists {
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
+		return
+	}
+	calendarDB := db.(*calendar.Calendar)
 
-	events, err := calendarDB.GetEventsByMonth(request.UserID, request.Month)
+	var request struct {
+		UserID   string   `form:"user_id"`
+		From     string   `form:"from" binding:"required"`
+		To       string   `form:"to" binding:"required"`
+		TimeZone string   `form:"time_zone"`
+		Query    string   `form:"q"`
+		Category string   `form:"category"`
+		Tags     []string `form:"tag"`
+		Sort     string   `form:"sort"`
+		Limit    int      `form:"limit"`
+		Cursor   string   `form:"cursor"`
+	}
+
+	if err := c.ShouldBindQuery(&request); err != nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid query: "+err.Error())
+		return
+	}
+
+	userID, ok := requestUserID(c, request.UserID)
+	if !ok {
+		return
+	}
+
+	page, err := calendarDB.GetEventsInRange(userID, calendar.EventQuery{
+		From:     request.From,
+		To:       request.To,
+		TimeZone: request.TimeZone,
+		Search:   request.Query,
+		Category: request.Category,
+		Tags:     request.Tags,
+		Sort:     request.Sort,
+		Limit:    request.Limit,
+		Cursor:   request.Cursor,
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		writeCalendarError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"user_id": request.UserID,
-		"month":   request.Month,
-		"events":  events,
-		"count":   len(events),
+		"user_id":     userID,
+		"from":        request.From,
+		"to":          request.To,
+		"events":      page.Events,
+		"count":       len(page.Events),
+		"next_cursor": page.NextCursor,
 	})
 }
 
-// LoggingMiddleware provides middleware logging
+// requestUserID returns ID of user the request acts for and writes error response if there is none.
+// Authenticated identity takes precedence, request may only repeat it
+func requestUserID(c *gin.Context, requested string) (string, bool) {
+	if identity, exists := c.Get("user_id"); exists {
+		userID := identity.(string)
+		if requested != "" && requested != userID {
+			writeError(c, http.StatusForbidden, CodeForbidden, "Access to events of other user is denied")
+			return "", false
+		}
+		return userID, true
+	}
+
+	if requested == "" {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "UserID is required")
+		return "", false
+	}
+	return requested, true
+}
+
+// RequestIDHeader carries ID of request, given ID is kept and new one is generated otherwise
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds length of request ID accepted from client
+const maxRequestIDLength = 128
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// validRequestID reports whether ID from client is safe to repeat in logs and headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLogger returns logger of request carrying its ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	if logger, exists := c.Get("logger"); exists {
+		return logger.(*slog.Logger)
+	}
+	return slog.Default()
+}
+
+// RequestIDMiddleware assigns ID to request, returns it in response header
+// and adds logger carrying it to context for handlers
+func RequestIDMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		c.Header(RequestIDHeader, requestID)
+		c.Set("request_id", requestID)
+		c.Set("logger", logger.With("request_id", requestID))
+		c.Next()
+	}
+}
+
+// LoggingMiddleware writes structured log record of every request,
+// server errors are logged as errors and client errors as warnings
 func LoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 
 		c.Next()
 
-		duration := time.Since(start)
-		log.Printf("[%s] %s %s %s %v\n",
-			time.Now().Format("2006-01-02 15:04:05"),
-			c.Request.Method,
-			c.Request.URL.Path,
-			c.ClientIP(),
-			duration,
-		)
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Int("size", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if code := c.GetString("error_code"); code != "" {
+			attrs = append(attrs, slog.String("error_code", code))
+		}
+		if userID := c.GetString("user_id"); userID != "" {
+			attrs = append(attrs, slog.String("user_id", userID))
+		}
+		requestLogger(c).LogAttrs(c.Request.Context(), level, "Handled request", attrs...)
+	}
+}
+
+// RecoveryMiddleware turns panic of handler into logged internal error
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		requestLogger(c).Error("Handler panicked", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Internal server error")
+	})
+}
+
+// CORSMiddleware lets browsers call API from given origins, "*" allows any origin.
+// Preflight requests are answered without reaching routes
+func CORSMiddleware(origins []string) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || (!allowed["*"] && !allowed[origin]) {
+			c.Next()
+			return
+		}
+
+		c.Header("Vary", "Origin")
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Access-Control-Expose-Headers", "Location, "+RequestIDHeader)
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, "+RequestIDHeader)
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
 	}
 }
 
@@ -463,3 +633,25 @@ func CalendarMiddleware(calendarDB *calendar.Calendar) gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AuthMiddleware authenticates request by bearer token and adds user ID to context.
+// Stream also takes ticket query parameter, browser EventSource cant send Authorization header
+func AuthMiddleware(authenticator *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		var userID string
+		var err error
+		if ticket := c.Query("ticket"); header == "" && ticket != "" && c.FullPath() == streamRoute {
+			userID, err = authenticator.AuthenticateTicket(ticket)
+		} else {
+			userID, err = authenticator.Authenticate(header)
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="calendar"`)
+			writeError(c, http.StatusUnauthorized, CodeUnauthorized, err.Error())
+			return
+		}
+		c.Set("user_id", userID)
+		c.Next()
+	}
+}
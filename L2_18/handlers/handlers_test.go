@@ -0,0 +1,902 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/auth"
+	"github.com/venexene/calendar/internal"
+	"github.com/venexene/calendar/webhook"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func doForm(router http.Handler, method string, path string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if method == http.MethodGet {
+		req = httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+	} else {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEventLifecycle(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+
+	rec := doForm(router, http.MethodPost, "/create_event", url.Values{
+		"user_id": {"u1"}, "date": {"2024-01-10"}, "event": {"meeting"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.ID == "" {
+		t.Fatalf("create response has no id: %s", rec.Body)
+	}
+
+	rec = doForm(router, http.MethodPost, "/update_event", url.Values{
+		"user_id": {"u1"}, "id": {created.ID}, "event": {"standup"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = doForm(router, http.MethodGet, "/events_for_day", url.Values{
+		"user_id": {"u1"}, "day": {"2024-01-10"},
+	})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "standup") {
+		t.Fatalf("day status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = doForm(router, http.MethodPost, "/delete_event", url.Values{
+		"user_id": {"u1"}, "id": {created.ID},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+	var wg sync.WaitGroup
+
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("u%d", w%3)
+			for i := 0; i < 50; i++ {
+				rec := doForm(router, http.MethodPost, "/create_event", url.Values{
+					"user_id": {userID}, "date": {"2024-01-10"}, "event": {fmt.Sprintf("event %d-%d", w, i)},
+				})
+				if rec.Code != http.StatusOK {
+					t.Errorf("create status = %d, body = %s", rec.Code, rec.Body)
+					return
+				}
+				var created struct {
+					ID string `json:"id"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+					t.Errorf("create response: %v", err)
+					return
+				}
+
+				for _, query := range []struct{ path, param, date string }{
+					{"/events_for_day", "day", "2024-01-10"},
+					{"/events_for_week", "week", "2024-01-08"},
+					{"/events_for_month", "month", "2024-01-01"},
+				} {
+					rec = doForm(router, http.MethodGet, query.path, url.Values{
+						"user_id": {userID}, query.param: {query.date},
+					})
+					if rec.Code != http.StatusOK {
+						t.Errorf("%s status = %d, body = %s", query.path, rec.Code, rec.Body)
+					}
+				}
+
+				rec = doForm(router, http.MethodPost, "/update_event", url.Values{
+					"user_id": {userID}, "id": {created.ID}, "event": {fmt.Sprintf("updated %d-%d", w, i)},
+				})
+				if rec.Code != http.StatusOK {
+					t.Errorf("update status = %d, body = %s", rec.Code, rec.Body)
+				}
+
+				rec = doForm(router, http.MethodPost, "/delete_event", url.Values{
+					"user_id": {userID}, "id": {created.ID},
+				})
+				if rec.Code != http.StatusOK {
+					t.Errorf("delete status = %d, body = %s", rec.Code, rec.Body)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	rec := doForm(router, http.MethodGet, "/events_for_month", url.Values{
+		"user_id": {"u0"}, "month": {"2024-01-01"},
+	})
+	if !strings.Contains(rec.Body.String(), `"count":0`) {
+		t.Errorf("events left after concurrent requests: %s", rec.Body)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator(map[string]string{"token-1": "u1"}, nil)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	router := NewRouter(calendar.NewCalendar(), Options{Authenticator: authenticator})
+
+	request := func(token string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/create_event", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		form  url.Values
+		want  int
+	}{
+		{"no token", "", url.Values{"date": {"2024-01-10"}, "event": {"a"}}, http.StatusUnauthorized},
+		{"wrong token", "token-2", url.Values{"date": {"2024-01-10"}, "event": {"a"}}, http.StatusUnauthorized},
+		{"own events", "token-1", url.Values{"date": {"2024-01-10"}, "event": {"a"}}, http.StatusOK},
+		{"same user id", "token-1", url.Values{"user_id": {"u1"}, "date": {"2024-01-10"}, "event": {"b"}}, http.StatusOK},
+		{"other user id", "token-1", url.Values{"user_id": {"u2"}, "date": {"2024-01-10"}, "event": {"c"}}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := request(tt.token, tt.form); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d, body = %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/events_for_day?day=2024-01-10", nil)
+	req.Header.Set("Authorization", "Bearer token-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"count":2`) || !strings.Contains(rec.Body.String(), `"user_id":"u1"`) {
+		t.Errorf("events of authenticated user = %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/server_check", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("server_check status = %d, want public access", rec.Code)
+	}
+
+	calendarDB := calendar.NewCalendar()
+	metered := NewRouter(calendarDB, Options{Authenticator: authenticator, Metrics: NewMetrics(calendarDB)})
+	if rec := doJSON(metered, http.MethodGet, "/metrics", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("metrics without token status = %d, want 401", rec.Code)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer token-1")
+	rec = httptest.NewRecorder()
+	metered.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "calendar_events") {
+		t.Errorf("metrics with token status = %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/users/u1/stream/ticket", nil)
+	req.Header.Set("Authorization", "Bearer token-1")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	var issued struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &issued); err != nil || rec.Code != http.StatusCreated || issued.Ticket == "" {
+		t.Fatalf("ticket status = %d, body = %s", rec.Code, rec.Body)
+	}
+	ticketTests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"ticket of other user", "/api/v1/users/u2/stream?ticket=" + issued.Ticket, http.StatusForbidden},
+		{"ticket outside stream", "/api/v1/users/u1/events?ticket=" + issued.Ticket, http.StatusUnauthorized},
+		{"invalid ticket", "/api/v1/users/u1/stream?ticket=token-1", http.StatusUnauthorized},
+	}
+	for _, tt := range ticketTests {
+		if rec := doJSON(router, http.MethodGet, tt.path, ""); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/api/v1/users/u1/stream?ticket=" + issued.Ticket)
+	if err != nil {
+		t.Fatalf("GET stream error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("stream with ticket status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func doJSON(router http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRESTEvents(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+	const base = "/api/v1/users/u1/events"
+
+	rec := doJSON(router, http.MethodPost, base, `{"start":"2024-01-10T09:00:00Z","end":"2024-01-10T10:00:00Z","text":"meeting","recurrence":"FREQ=DAILY;COUNT=3"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.ID == "" {
+		t.Fatalf("create response has no id: %s", rec.Body)
+	}
+	if location := rec.Header().Get("Location"); location != base+"/"+created.ID {
+		t.Errorf("Location = %q", location)
+	}
+	item := base + "/" + created.ID
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		expect string
+	}{
+		{"get", http.MethodGet, item, "", http.StatusOK, `"text":"meeting"`},
+		{"get missing", http.MethodGet, base + "/missing", "", http.StatusNotFound, "Event not found"},
+		{"list", http.MethodGet, base + "?from=2024-01-01&to=2024-01-31", "", http.StatusOK, `"count":3`},
+		{"list without range", http.MethodGet, base, "", http.StatusBadRequest, "error"},
+		{"create invalid", http.MethodPost, base, `{"date":"2024-01-10","text":"bad","recurrence":"FREQ=HOURLY"}`, http.StatusUnprocessableEntity, "error"},
+		{"create unknown field", http.MethodPost, base, `{"date":"2024-01-10","new_event":"bad"}`, http.StatusUnprocessableEntity, "new_event"},
+		{"create malformed", http.MethodPost, base, `{"date":`, http.StatusBadRequest, CodeInvalidRequest},
+		{"patch", http.MethodPatch, item, `{"text":"standup"}`, http.StatusOK, `"recurrence":"FREQ=DAILY;COUNT=3"`},
+		{"patch missing", http.MethodPatch, base + "/missing", `{"text":"standup"}`, http.StatusNotFound, "error"},
+		{"patch end", http.MethodPatch, item, `{"end":"2024-01-10T11:30:00Z"}`, http.StatusOK, `"start":"2024-01-10T09:00:00Z","end":"2024-01-10T11:30:00Z"`},
+		{"patch nothing", http.MethodPatch, item, `{}`, http.StatusUnprocessableEntity, "Nothing to update"},
+		{"put incomplete", http.MethodPut, item, `{"text":"review"}`, http.StatusUnprocessableEntity, "error"},
+		{"put", http.MethodPut, item, `{"date":"2024-01-11","text":"review"}`, http.StatusOK, `"text":"review"`},
+		{"list after put", http.MethodGet, base + "?from=2024-01-01&to=2024-01-31", "", http.StatusOK, `"count":1`},
+		{"legacy alias", http.MethodGet, "/events_for_day?user_id=u1&day=2024-01-11", "", http.StatusOK, `"text":"review"`},
+		{"delete", http.MethodDelete, item, "", http.StatusNoContent, ""},
+		{"delete again", http.MethodDelete, item, "", http.StatusNotFound, "error"},
+	}
+
+	for _, tt := range tests {
+		rec := doJSON(router, tt.method, tt.path, tt.body)
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.expect) {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestEventDetailsRoutes(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+	const base = "/api/v1/users/u1/events"
+
+	rec := doJSON(router, http.MethodPost, base, `{"date":"2024-01-10","text":"quarter goals","title":"Planning","location":"Room 4","category":"work","color":"#1a73e8","tags":["Team","q1"],"attendees":[{"id":"u2","status":"accepted"},{"id":"ann@example.com"}]}`)
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"tags":["team","q1"],"attendees":[{"id":"u2","status":"accepted"},{"id":"ann@example.com","status":"needs_action"}]`) {
+		t.Errorf("create body = %s", rec.Body)
+	}
+	item := base + "/" + created.ID
+
+	form := url.Values{"user_id": {"u1"}, "date": {"2024-01-13"}, "event": {"retro"}, "tags": {"form"}, "attendees": {`{"id":"u4","status":"accepted"}`}}
+	req := httptest.NewRequest(http.MethodPost, "/create_event", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("legacy form create status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		expect string
+	}{
+		{"bad color", http.MethodPatch, item, `{"color":"blue"}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"bad status", http.MethodPatch, item, `{"attendees":[{"id":"u2","status":"maybe"}]}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"patch keeps", http.MethodPatch, item, `{"location":""}`, http.StatusOK, `"title":"Planning","category":"work"`},
+		{"filter tag", http.MethodGet, base + "?from=2024-01-01&to=2024-01-31&tag=team&tag=Q1", "", http.StatusOK, `"count":1`},
+		{"filter other tag", http.MethodGet, base + "?from=2024-01-01&to=2024-01-31&tag=home", "", http.StatusOK, `"count":0`},
+		{"filter category", http.MethodGet, "/events?user_id=u1&from=2024-01-01&to=2024-01-31&category=Work", "", http.StatusOK, `"count":1`},
+		{"legacy filter", http.MethodGet, "/events_for_day?user_id=u1&day=2024-01-10&category=work&tag=team", "", http.StatusOK, `"count":1`},
+		{"legacy filter other", http.MethodGet, "/events_for_week?user_id=u1&week=2024-01-08&tag=home", "", http.StatusOK, `"count":0`},
+		{"legacy update", http.MethodPost, "/update_event", `{"user_id":"u1","id":"` + created.ID + `","title":"Review","tags":[]}`, http.StatusOK, ""},
+		{"legacy updated", http.MethodGet, item, "", http.StatusOK, `"title":"Review","category":"work"`},
+		{"legacy create", http.MethodPost, "/create_event", `{"user_id":"u1","date":"2024-01-12","event":"demo","category":"demo","attendees":[{"id":"u3"}]}`, http.StatusOK, ""},
+		{"legacy create filter", http.MethodGet, "/events_for_month?user_id=u1&month=2024-01-01&category=demo", "", http.StatusOK, `"attendees":[{"id":"u3","status":"needs_action"}]`},
+		{"legacy form filter", http.MethodGet, "/events_for_day?user_id=u1&day=2024-01-13&tag=form", "", http.StatusOK, `"attendees":[{"id":"u4","status":"accepted"}]`},
+		{"legacy bad color", http.MethodPost, "/create_event", `{"user_id":"u1","date":"2024-01-12","event":"demo","color":"blue"}`, http.StatusBadRequest, ""},
+		{"put resets", http.MethodPut, item, `{"date":"2024-01-10","text":"quarter goals"}`, http.StatusOK, `"time_zone":"UTC","text":"quarter goals"}`},
+	}
+	for _, tt := range tests {
+		rec := doJSON(router, tt.method, tt.path, tt.body)
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.expect) {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		form   url.Values
+		status int
+		code   string
+	}{
+		{"invalid date", http.MethodPost, "/create_event", url.Values{"user_id": {"u1"}, "date": {"2024-02-30"}, "event": {"a"}}, http.StatusBadRequest, CodeValidation},
+		{"malformed date", http.MethodPost, "/create_event", url.Values{"user_id": {"u1"}, "date": {"10.01.2024"}, "event": {"a"}}, http.StatusBadRequest, CodeInvalidRequest},
+		{"missing event", http.MethodPost, "/create_event", url.Values{"user_id": {"u1"}, "date": {"2024-01-10"}}, http.StatusBadRequest, CodeInvalidRequest},
+		{"update missing", http.MethodPost, "/update_event", url.Values{"user_id": {"u1"}, "id": {"missing"}, "event": {"a"}}, http.StatusNotFound, CodeNotFound},
+		{"delete missing", http.MethodPost, "/delete_event", url.Values{"user_id": {"u1"}, "id": {"missing"}}, http.StatusNotFound, CodeNotFound},
+		{"invalid day", http.MethodGet, "/events_for_day", url.Values{"user_id": {"u1"}, "day": {"2024-02-31"}}, http.StatusBadRequest, CodeValidation},
+		{"invalid time zone", http.MethodGet, "/events_for_week", url.Values{"user_id": {"u1"}, "week": {"2024-01-08"}, "time_zone": {"Mars/Olympus"}}, http.StatusBadRequest, CodeValidation},
+		{"invalid range", http.MethodGet, "/events", url.Values{"user_id": {"u1"}, "from": {"2024-02-01"}, "to": {"2024-01-01"}}, http.StatusBadRequest, CodeValidation},
+		{"no user", http.MethodGet, "/events_for_month", url.Values{"month": {"2024-01-01"}}, http.StatusBadRequest, CodeInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		rec := doForm(router, tt.method, tt.path, tt.form)
+		var body struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: invalid error body %s", tt.name, rec.Body)
+		}
+		if rec.Code != tt.status || body.Code != tt.code || body.Error == "" {
+			t.Errorf("%s: status = %d, body = %s, want %d with code %s", tt.name, rec.Code, rec.Body, tt.status, tt.code)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := LoadOpenAPI()
+	if err != nil {
+		t.Fatalf("LoadOpenAPI() error = %v", err)
+	}
+	dispatcher, err := webhook.NewDispatcher(webhook.Options{})
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	defer dispatcher.Close()
+	router := NewRouter(calendar.NewCalendar(), Options{Webhooks: dispatcher})
+
+	for _, route := range router.Routes() {
+		if strings.HasPrefix(route.Path, "/docs") || strings.HasPrefix(route.Path, "/openapi.") {
+			continue
+		}
+		segments := strings.Split(route.Path, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				segments[i] = "{" + segment[1:] + "}"
+			}
+		}
+		path := doc.Paths.Find(strings.Join(segments, "/"))
+		if path == nil || path.GetOperation(route.Method) == nil {
+			t.Errorf("route %s %s is not described in OpenAPI document", route.Method, route.Path)
+		}
+	}
+
+	for _, page := range []struct{ path, expect string }{
+		{"/openapi.yaml", "openapi: 3"},
+		{"/openapi.json", `"openapi":"3`},
+		{"/docs/", "swagger-ui"},
+		{"/docs/swagger-initializer.js", "/openapi.yaml"},
+		{"/docs/swagger-ui-bundle.js", "SwaggerUIBundle"},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, page.path, nil))
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), page.expect) {
+			t.Errorf("GET %s status = %d, want page containing %q", page.path, rec.Code, page.expect)
+		}
+	}
+
+	feed := "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20240110\r\nSUMMARY:imported\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	req := httptest.NewRequest(http.MethodPost, "/import_ics?user_id=u1", strings.NewReader(feed))
+	req.Header.Set("Content-Type", "text/calendar")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"count":1`) {
+		t.Errorf("import status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/import_ics?user_id=u1&calendar_id=u2", strings.NewReader(feed))
+	req.Header.Set("Content-Type", "text/calendar")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("import into foreign calendar status = %d, body = %s", rec.Code, rec.Body)
+	}
+	rec = doJSON(router, http.MethodGet, "/export_ics?user_id=u1&calendar_id=u1", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "SUMMARY:imported") {
+		t.Errorf("export status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = doJSON(router, http.MethodPost, "/create_event", `{"user_id":"u1","date":"2024-01-10","new_event":"a"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), CodeInvalidRequest) {
+		t.Errorf("create with unknown field status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWebhookRoutes(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(webhook.EventHeader)
+	}))
+	defer server.Close()
+
+	calendarDB := calendar.NewCalendar()
+	dispatcher, err := webhook.NewDispatcher(webhook.Options{AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}})
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	defer dispatcher.Close()
+	calendarDB.OnChange(dispatcher.Handle)
+	router := NewRouter(calendarDB, Options{Webhooks: dispatcher})
+	const base = "/api/v1/users/u1/webhooks"
+
+	rec := doJSON(router, http.MethodPost, base, `{"url":"`+server.URL+`","events":["created"]}`)
+	var created webhook.Subscription
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated || created.Secret == "" {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = doJSON(router, http.MethodPost, "/api/v1/users/u1/events", `{"date":"2024-01-10","text":"meeting"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create event status = %d, body = %s", rec.Code, rec.Body)
+	}
+	select {
+	case eventType := <-received:
+		if eventType != "event.created" {
+			t.Errorf("webhook received %s", eventType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		expect string
+	}{
+		{"list", http.MethodGet, base, "", http.StatusOK, `"count":1`},
+		{"invalid url", http.MethodPost, base, `{"url":"ftp://example.com"}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"unknown event", http.MethodPost, base, `{"url":"https://example.com","events":["moved"]}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"internal url", http.MethodPost, base, `{"url":"http://169.254.169.254/latest"}`, http.StatusUnprocessableEntity, "internal address"},
+		{"deliveries", http.MethodGet, base + "/" + created.ID + "/deliveries", "", http.StatusOK, `"type":"event.created"`},
+		{"deliveries of missing", http.MethodGet, base + "/missing/deliveries", "", http.StatusNotFound, CodeNotFound},
+		{"delete", http.MethodDelete, base + "/" + created.ID, "", http.StatusNoContent, ""},
+		{"delete again", http.MethodDelete, base + "/" + created.ID, "", http.StatusNotFound, CodeNotFound},
+	}
+	for _, tt := range tests {
+		rec := doJSON(router, tt.method, tt.path, tt.body)
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.expect) || strings.Contains(rec.Body.String(), created.Secret) {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	calendarDB := calendar.NewCalendar()
+	server := httptest.NewServer(NewRouter(calendarDB, Options{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/users/u1/stream")
+	if err != nil {
+		t.Fatalf("GET stream error = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream status = %d, content type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != ": connected" || !lines.Scan() {
+		t.Fatalf("stream starts with %q", lines.Text())
+	}
+
+	if _, err := calendarDB.Add("u2", calendar.EventData{Date: "2024-01-10", Text: "other"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := calendarDB.Add("u1", calendar.EventData{Date: "2024-01-10", Text: "meeting"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	var received []string
+	for lines.Scan() && lines.Text() != "" {
+		received = append(received, lines.Text())
+	}
+	got := strings.Join(received, "\n")
+	if !strings.HasPrefix(got, "event:event.created\ndata:") || !strings.Contains(got, `"text":"meeting"`) {
+		t.Errorf("stream event = %s", got)
+	}
+
+	calendarDB.Hub().Close()
+	for lines.Scan() {
+	}
+	if err := lines.Err(); err != nil {
+		t.Errorf("stream did not end cleanly: %v", err)
+	}
+}
+
+func TestCalendarRoutes(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+	const base = "/api/v1/users/u1/calendars"
+
+	rec := doJSON(router, http.MethodPost, base, `{"name":"team"}`)
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || rec.Code != http.StatusCreated || created.ID == "" {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+	item := base + "/" + created.ID
+
+	rec = doJSON(router, http.MethodPost, "/api/v1/users/u1/events", `{"calendar_id":"`+created.ID+`","date":"2024-01-10","text":"standup"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create event status = %d, body = %s", rec.Code, rec.Body)
+	}
+	sharedEvents := "/api/v1/users/u2/events?from=2024-01-01&to=2024-01-31&calendar_id=" + created.ID
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		expect string
+	}{
+		{"list", http.MethodGet, base, "", http.StatusOK, `"count":2`},
+		{"create empty", http.MethodPost, base, `{"name":""}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"rename", http.MethodPatch, item, `{"name":"platform"}`, http.StatusOK, `"name":"platform"`},
+		{"events of unshared", http.MethodGet, sharedEvents, "", http.StatusNotFound, "Calendar not found"},
+		{"share invalid", http.MethodPut, item + "/shares/u2", `{"access":"admin"}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"share", http.MethodPut, item + "/shares/u2", `{"access":"read"}`, http.StatusOK, `"u2":"read"`},
+		{"get shared", http.MethodGet, "/api/v1/users/u2/calendars/" + created.ID, "", http.StatusOK, `"access":"read"`},
+		{"events of shared", http.MethodGet, sharedEvents, "", http.StatusOK, `"text":"standup"`},
+		{"write to read-only", http.MethodPost, "/api/v1/users/u2/events", `{"calendar_id":"` + created.ID + `","date":"2024-01-11","text":"demo"}`, http.StatusForbidden, CodeForbidden},
+		{"rename by shared", http.MethodPatch, "/api/v1/users/u2/calendars/" + created.ID, `{"name":"mine"}`, http.StatusForbidden, CodeForbidden},
+		{"unshare", http.MethodDelete, item + "/shares/u2", "", http.StatusOK, `"shares":{}`},
+		{"unshare again", http.MethodDelete, item + "/shares/u2", "", http.StatusNotFound, CodeNotFound},
+		{"delete default", http.MethodDelete, base + "/u1", "", http.StatusUnprocessableEntity, CodeValidation},
+		{"delete", http.MethodDelete, item, "", http.StatusNoContent, ""},
+		{"get deleted", http.MethodGet, item, "", http.StatusNotFound, CodeNotFound},
+	}
+	for _, tt := range tests {
+		rec := doJSON(router, tt.method, tt.path, tt.body)
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.expect) {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestFreeBusyRoute(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+
+	rec := doJSON(router, http.MethodPost, "/api/v1/users/u2/events", `{"start":"2024-01-10T09:00:00Z","end":"2024-01-10T10:00:00Z","text":"sync"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create event status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	path := "/api/v1/users/u1/freebusy?user=u1&user=u2&from=2024-01-10&to=2024-01-11&duration=2h&day_start=09:00&day_end=18:00"
+	rec = doJSON(router, http.MethodGet, path, "")
+	if rec.Code != http.StatusOK ||
+		!strings.Contains(rec.Body.String(), `"free_days":["2024-01-11"]`) ||
+		!strings.Contains(rec.Body.String(), `{"start":"2024-01-10T10:00:00Z","end":"2024-01-10T18:00:00Z"}`) {
+		t.Errorf("freebusy status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = doJSON(router, http.MethodGet, "/api/v1/users/u1/freebusy?from=2024-01-10&to=2024-01-11&day_start=9am", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid day start status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestEventConflicts(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+	const base = "/api/v1/users/u1/events"
+
+	rec := doJSON(router, http.MethodPost, base, `{"start":"2024-01-10T10:00:00Z","end":"2024-01-10T11:00:00Z","text":"meeting"}`)
+	var meeting struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &meeting); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		expect string
+	}{
+		{"duplicate", http.MethodPost, base, `{"start":"2024-01-10T10:00:00Z","end":"2024-01-10T11:00:00Z","text":"meeting"}`, http.StatusConflict, `"conflicts":[{"id":"` + meeting.ID + `"`},
+		{"reject", http.MethodPost, base, `{"start":"2024-01-10T10:30:00Z","end":"2024-01-10T11:30:00Z","text":"call","on_conflict":"reject"}`, http.StatusConflict, CodeConflict},
+		{"unknown mode", http.MethodPost, base, `{"date":"2024-01-10","text":"call","on_conflict":"ignore"}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"allow", http.MethodPost, base, `{"start":"2024-01-10T10:30:00Z","end":"2024-01-10T11:30:00Z","text":"call"}`, http.StatusCreated, `"conflicts":["` + meeting.ID + `"]`},
+		{"legacy allow", http.MethodPost, "/create_event", `{"user_id":"u1","date":"2024-01-10","event":"offsite"}`, http.StatusOK, `"conflicts":[`},
+	}
+	for _, tt := range tests {
+		rec := doJSON(router, tt.method, tt.path, tt.body)
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.expect) {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestBatchRoute(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+	const base = "/api/v1/users/u1/events"
+
+	rec := doJSON(router, http.MethodPost, base, `{"date":"2024-01-10","text":"meeting"}`)
+	var meeting struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &meeting); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		want   int
+		expect string
+	}{
+		{"atomic failure", `{"atomic":true,"operations":[{"action":"create","event":{"date":"2024-01-11","text":"lunch"}},{"action":"delete","id":"missing"}]}`, http.StatusNotFound, `"index":1`},
+		{"atomic invalid", `{"atomic":true,"operations":[{"action":"update","id":"` + meeting.ID + `","event":{"date":"2024-01-12","time_zone":"Mars/Base"}}]}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"unknown action", `{"operations":[{"action":"rename","id":"` + meeting.ID + `"}]}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"empty", `{"operations":[]}`, http.StatusUnprocessableEntity, CodeValidation},
+		{"best effort", `{"operations":[{"action":"create","event":{"date":"2024-01-11","text":"lunch"}},{"action":"create","event":{"date":"2024-01-10","text":"meeting"}},{"action":"delete","id":"` + meeting.ID + `"}]}`, http.StatusOK, `"status":409,"error":`},
+	}
+	for _, tt := range tests {
+		rec := doJSON(router, http.MethodPost, base+"/batch", tt.body)
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.expect) {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	rec = doJSON(router, http.MethodGet, base+"?from=2024-01-01&to=2024-01-31", "")
+	if !strings.Contains(rec.Body.String(), `"count":1`) || !strings.Contains(rec.Body.String(), "lunch") {
+		t.Errorf("events after batches = %s, want only lunch", rec.Body)
+	}
+}
+
+func TestSearchRoute(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{})
+	for _, body := range []string{
+		`{"date":"2024-01-10","text":"quarter planning","tags":["team"]}`,
+		`{"date":"2024-01-11","text":"lunch","location":"Planet cafe"}`,
+	} {
+		if rec := doJSON(router, http.MethodPost, "/api/v1/users/u1/events", body); rec.Code != http.StatusCreated {
+			t.Fatalf("create status = %d, body = %s", rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		want   int
+		expect string
+	}{
+		{"prefix", "/api/v1/users/u1/search?q=plan", http.StatusOK, `"count":2`},
+		{"tag", "/api/v1/users/u1/search?q=plan+team", http.StatusOK, `"text":"quarter planning"`},
+		{"range", "/api/v1/users/u1/search?q=plan&from=2024-01-11&to=2024-01-11", http.StatusOK, `"count":1`},
+		{"missing query", "/api/v1/users/u1/search", http.StatusBadRequest, CodeInvalidRequest},
+		{"half range", "/api/v1/users/u1/search?q=plan&from=2024-01-11", http.StatusBadRequest, CodeValidation},
+		{"other calendar", "/api/v1/users/u1/search?q=plan&calendar_id=u2", http.StatusNotFound, CodeNotFound},
+	}
+	for _, tt := range tests {
+		rec := doJSON(router, http.MethodGet, tt.path, "")
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.expect) {
+			t.Errorf("%s: status = %d, want %d, body = %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestMetricsAndLogging(t *testing.T) {
+	calendarDB := calendar.NewCalendar()
+	var logs bytes.Buffer
+	router := NewRouter(calendarDB, Options{
+		Logger:  slog.New(slog.NewJSONHandler(&logs, nil)),
+		Metrics: NewMetrics(calendarDB),
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/u1/events", strings.NewReader(`{"date":"2024-01-10","text":"meeting"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(RequestIDHeader, "req-42")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated || rec.Header().Get(RequestIDHeader) != "req-42" {
+		t.Fatalf("create status = %d, request ID = %q", rec.Code, rec.Header().Get(RequestIDHeader))
+	}
+
+	var record struct {
+		Level     string `json:"level"`
+		RequestID string `json:"request_id"`
+		Route     string `json:"route"`
+		Status    int    `json:"status"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &record); err != nil {
+		t.Fatalf("log record %q: %v", logs.String(), err)
+	}
+	if record.Level != "INFO" || record.RequestID != "req-42" || record.Route != "/api/v1/users/:user_id/events" || record.Status != http.StatusCreated {
+		t.Errorf("log record = %+v", record)
+	}
+
+	rec = doJSON(router, http.MethodPost, "/api/v1/users/u1/events", `{"date":"2024-01-10"}`)
+	if rec.Code != http.StatusUnprocessableEntity || rec.Header().Get(RequestIDHeader) == "" {
+		t.Errorf("invalid create status = %d, request ID = %q", rec.Code, rec.Header().Get(RequestIDHeader))
+	}
+	if !strings.Contains(logs.String(), `"level":"WARN"`) || !strings.Contains(logs.String(), `"error_code":"validation_failed"`) {
+		t.Errorf("logs of failed request = %s", logs.String())
+	}
+
+	rec = doJSON(router, http.MethodGet, "/metrics", "")
+	for _, want := range []string{
+		`calendar_http_requests_total{method="POST",route="/api/v1/users/:user_id/events",status="201"} 1`,
+		`calendar_http_errors_total{code="validation_failed",method="POST",route="/api/v1/users/:user_id/events"} 1`,
+		`calendar_http_request_duration_seconds_count{method="POST",route="/api/v1/users/:user_id/events"} 2`,
+		"calendar_events 1",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("metrics miss %s", want)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	router := NewRouter(calendar.NewCalendar(), Options{CORSOrigins: []string{"https://app.example.com"}})
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/users/u1/events", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		!strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Errorf("preflight status = %d, headers = %v", rec.Code, rec.Header())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/server_check", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("request of other origin status = %d, headers = %v", rec.Code, rec.Header())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	limiter := NewRateLimiter(1, 2)
+	now := time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	router := NewRouter(calendar.NewCalendar(), Options{RateLimiter: limiter, MaxBodyBytes: 256})
+
+	const path = "/api/v1/users/u1/events?from=2024-01-01&to=2024-01-31"
+	for i := 0; i < 2; i++ {
+		if rec := doJSON(router, http.MethodGet, path, ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, body = %s", i, rec.Code, rec.Body)
+		}
+	}
+	rec := doJSON(router, http.MethodGet, path, "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" || !strings.Contains(rec.Body.String(), CodeRateLimited) {
+		t.Errorf("limited status = %d, Retry-After = %q, body = %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body)
+	}
+	if rec := doJSON(router, http.MethodGet, "/server_check", ""); rec.Code != http.StatusOK {
+		t.Errorf("server check status = %d, want it unlimited", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status with spoofed X-Forwarded-For = %d, want limit kept", rec.Code)
+	}
+
+	now = now.Add(time.Second)
+	if rec := doJSON(router, http.MethodGet, path, ""); rec.Code != http.StatusOK {
+		t.Errorf("status after refill = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	now = now.Add(time.Minute)
+	body := `{"date":"2024-01-10","text":"` + strings.Repeat("x", 300) + `"}`
+	rec = doJSON(router, http.MethodPost, "/api/v1/users/u1/events", body)
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), CodeTooLarge) {
+		t.Errorf("large body status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	now = now.Add(time.Minute)
+	batch := `{"operations":[{"action":"delete","id":"a"},{"action":"delete","id":"b"},{"action":"delete","id":"c"},{"action":"delete","id":"d"}]}`
+	if rec := doJSON(router, http.MethodPost, "/api/v1/users/u1/events/batch", batch); rec.Code != http.StatusOK {
+		t.Fatalf("batch status = %d, body = %s", rec.Code, rec.Body)
+	}
+	now = now.Add(time.Second)
+	if rec := doJSON(router, http.MethodGet, path, ""); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("status after batch = %d, Retry-After = %q, want batch charged per operation", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	authenticator, err := auth.NewAuthenticator(map[string]string{"token-1": "u1"}, nil)
+	if err != nil {
+		t.Fatalf("NewAuthenticator() error = %v", err)
+	}
+	router = NewRouter(calendar.NewCalendar(), Options{Authenticator: authenticator, RateLimiter: limiter})
+	guess := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	for i := 0; i < 2; i++ {
+		if code := guess(fmt.Sprintf("guess-%d", i)); code != http.StatusUnauthorized {
+			t.Fatalf("guess %d status = %d, want 401", i, code)
+		}
+	}
+	if code := guess("guess-2"); code != http.StatusTooManyRequests {
+		t.Errorf("status after failed guesses = %d, want 429", code)
+	}
+}
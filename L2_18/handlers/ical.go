@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// ExportICSHandle handles requests to export user events as iCalendar feed.
+// Events of calendars given by calendar_id are exported, default calendar of user if none
+func ExportICSHandle(c *gin.Context) {
+	db, exists := c.Get("calendar")
+	if !exists {
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
+		return
+	}
+	calendarDB := db.(*calendar.Calendar)
+
+	userID, ok := requestUserID(c, c.Query("user_id"))
+	if !ok {
+		return
+	}
+
+	feed, err := calendarDB.ExportICS(userID, c.QueryArray("calendar_id"))
+	if err != nil {
+		writeCalendarError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="calendar.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", feed)
+}
+
+// ImportICSHandle handles requests to import iCalendar file into user events.
+// File is taken from multipart field "file" or from raw request body,
+// events are added to calendar given by calendar_id or to default calendar of user
+func ImportICSHandle(c *gin.Context) {
+	db, exists := c.Get("calendar")
+	if !exists {
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
+		return
+	}
+	calendarDB := db.(*calendar.Calendar)
+
+	var body io.Reader = c.Request.Body
+	userID := c.Query("user_id")
+	calendarID := c.Query("calendar_id")
+
+	contentType := c.Request.Header.Get("Content-Type")
+	if strings.Contains(contentType, "multipart/form-data") {
+		if userID == "" {
+			userID = c.PostForm("user_id")
+		}
+		if calendarID == "" {
+			calendarID = c.PostForm("calendar_id")
+		}
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid file: "+err.Error())
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid file: "+err.Error())
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	userID, ok := requestUserID(c, userID)
+	if !ok {
+		return
+	}
+
+	count, err := calendarDB.ImportICS(userID, calendarID, body)
+	if err != nil {
+		writeCalendarError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": "Events imported successfully",
+		"count":  count,
+	})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// unmatchedRoute labels requests no route matched, so unknown paths dont blow up label sets
+const unmatchedRoute = "unmatched"
+
+// Metrics collects Prometheus metrics of calendar server
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// NewMetrics creates metrics of requests and of sizes of calendar storage
+func NewMetrics(calendarDB *calendar.Calendar) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendar_http_requests_total",
+			Help: "Number of handled HTTP requests by route and status",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "calendar_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "calendar_http_errors_total",
+			Help: "Number of error responses by route and error code",
+		}, []string{"method", "route", "code"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.errors,
+		newStorageCollector(calendarDB),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Middleware records count, latency and errors of requests
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		m.requests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.duration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		if code := c.GetString("error_code"); code != "" {
+			m.errors.WithLabelValues(method, route, code).Inc()
+		}
+	}
+}
+
+// Handler serves metrics in Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// storageCollector reports sizes of calendar storage at scrape time
+type storageCollector struct {
+	calendarDB *calendar.Calendar
+	events     *prometheus.Desc
+	recurring  *prometheus.Desc
+	reminded   *prometheus.Desc
+	calendars  *prometheus.Desc
+	failures   *prometheus.Desc
+}
+
+func newStorageCollector(calendarDB *calendar.Calendar) *storageCollector {
+	return &storageCollector{
+		calendarDB: calendarDB,
+		events:     prometheus.NewDesc("calendar_events", "Number of stored events", nil, nil),
+		recurring:  prometheus.NewDesc("calendar_recurring_events", "Number of stored recurring events", nil, nil),
+		reminded:   prometheus.NewDesc("calendar_reminded_events", "Number of stored events having reminders", nil, nil),
+		calendars:  prometheus.NewDesc("calendar_calendars", "Number of saved named and changed default calendars", nil, nil),
+		failures:   prometheus.NewDesc("calendar_storage_stats_failed", "1 if sizes of storage could not be read", nil, nil),
+	}
+}
+
+// Describe sends descriptions of storage metrics
+func (s *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.events
+	ch <- s.recurring
+	ch <- s.reminded
+	ch <- s.calendars
+	ch <- s.failures
+}
+
+// Collect sends current sizes of storage
+func (s *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := s.calendarDB.Stats()
+	if err != nil {
+		ch <- prometheus.MustNewConstMetric(s.failures, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(s.failures, prometheus.GaugeValue, 0)
+	ch <- prometheus.MustNewConstMetric(s.events, prometheus.GaugeValue, float64(stats.Events))
+	ch <- prometheus.MustNewConstMetric(s.recurring, prometheus.GaugeValue, float64(stats.RecurringEvents))
+	ch <- prometheus.MustNewConstMetric(s.reminded, prometheus.GaugeValue, float64(stats.RemindedEvents))
+	ch <- prometheus.MustNewConstMetric(s.calendars, prometheus.GaugeValue, float64(stats.Calendars))
+}
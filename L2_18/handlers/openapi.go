@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// openAPISpec is OpenAPI document describing every route of router
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// swaggerInitializer points Swagger UI to served document
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "/openapi.yaml",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+func init() {
+	openapi3filter.RegisterBodyDecoder("text/calendar", openapi3filter.PlainBodyDecoder)
+	openapi3filter.RegisterBodyDecoder("application/x-www-form-urlencoded", formBodyDecoder)
+}
+
+// formBodyDecoder decodes form body leaving out fields missing in it, so optional fields may be omitted
+func formBodyDecoder(body io.Reader, header http.Header, schema *openapi3.SchemaRef, encFn openapi3filter.EncodingFn) (any, error) {
+	value, err := openapi3filter.UrlencodedBodyDecoder(body, header, schema, encFn)
+	if object, ok := value.(map[string]any); ok {
+		for name, field := range object {
+			if field == nil {
+				delete(object, name)
+			}
+		}
+	}
+	return value, err
+}
+
+// LoadOpenAPI parses and validates embedded OpenAPI document
+func LoadOpenAPI() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load OpenAPI document: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("Invalid OpenAPI document: %w", err)
+	}
+	return doc, nil
+}
+
+// OpenAPIHandle serves OpenAPI document as YAML
+func OpenAPIHandle(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml; charset=utf-8", openAPISpec)
+}
+
+// OpenAPIJSONHandle serves OpenAPI document as JSON
+func OpenAPIJSONHandle(doc *openapi3.T) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	}
+}
+
+// SwaggerUIHandle serves Swagger UI from embedded assets
+func SwaggerUIHandle(c *gin.Context) {
+	path := c.Param("filepath")
+	if path == "/swagger-initializer.js" {
+		c.Data(http.StatusOK, "application/javascript; charset=utf-8", []byte(swaggerInitializer))
+		return
+	}
+	c.FileFromFS(path, http.FS(swaggerFiles.FS))
+}
+
+// ValidationMiddleware rejects requests not matching OpenAPI document.
+// Like handlers of REST API, it answers /api/v1 bodies not matching their schema with 422,
+// other invalid requests are answered with 400. Routes missing in document are passed as is
+func ValidationMiddleware(doc *openapi3.T) gin.HandlerFunc {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to route OpenAPI document: %v", err))
+	}
+
+	options := &openapi3filter.Options{
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+	options.WithCustomSchemaErrorFunc(func(err *openapi3.SchemaError) string {
+		if pointer := err.JSONPointer(); len(pointer) != 0 {
+			return fmt.Sprintf("%s: %s", strings.Join(pointer, "."), err.Reason)
+		}
+		return err.Reason
+	})
+
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		err = openapi3filter.ValidateRequest(c.Request.Context(), &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		})
+		if err != nil {
+			if strings.HasPrefix(c.Request.URL.Path, "/api/v1/") && isBodySchemaError(err) {
+				writeError(c, http.StatusUnprocessableEntity, CodeValidation, validationMessage(err))
+				return
+			}
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, validationMessage(err))
+			return
+		}
+		c.Next()
+	}
+}
+
+// isBodySchemaError reports whether request body is well-formed but doesnt match its schema
+func isBodySchemaError(err error) bool {
+	var requestErr *openapi3filter.RequestError
+	if !errors.As(err, &requestErr) || requestErr.RequestBody == nil {
+		return false
+	}
+	var schemaErr *openapi3.SchemaError
+	return errors.As(requestErr.Err, &schemaErr)
+}
+
+// validationMessage returns short description of request validation error
+func validationMessage(err error) string {
+	if requestErr, ok := err.(*openapi3filter.RequestError); ok {
+		if requestErr.Err == nil {
+			return "Invalid request: " + requestErr.Reason
+		}
+		switch {
+		case requestErr.Parameter != nil:
+			return fmt.Sprintf("Invalid %s parameter %q: %v", requestErr.Parameter.In, requestErr.Parameter.Name, requestErr.Err)
+		case requestErr.RequestBody != nil:
+			return fmt.Sprintf("Invalid request body: %v", requestErr.Err)
+		}
+	}
+	if routeErr, ok := err.(*routers.RouteError); ok {
+		return routeErr.Reason
+	}
+	return err.Error()
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sweepInterval is how often buckets of idle clients are dropped
+const sweepInterval = time.Minute
+
+// bucket is token bucket of single client
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter keeps token bucket per client. Bucket holds up to burst requests
+// and refills at rate requests per second, safe for concurrent use
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewRateLimiter creates limiter allowing rate requests per second with bursts of given size
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow takes token from bucket of client. If bucket is empty it returns false
+// and time until next token is available
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, wait := l.refill(key)
+	if wait > 0 {
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Take takes n tokens from bucket of client even if it holds fewer,
+// then following requests of client wait until debt is paid off
+func (l *RateLimiter) Take(key string, n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, _ := l.refill(key)
+	b.tokens -= float64(n)
+}
+
+// Wait returns time until bucket of client has token, 0 if it has one now.
+// Unlike Allow it takes nothing from bucket
+func (l *RateLimiter) Wait(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, wait := l.refill(key)
+	return wait
+}
+
+// refill adds tokens bucket of client earned since its last use and returns bucket
+// with time until it has token. Limiter must be locked
+func (l *RateLimiter) refill(key string) (*bucket, time.Duration) {
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return b, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	return b, 0
+}
+
+// sweep drops buckets that refilled completely, clients of such buckets start afresh anyway.
+// Limiter must be locked
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimitMiddleware limits requests of every authenticated user or, without authentication,
+// of every client IP, and answers 429 with Retry-After when limit is exceeded.
+// It must follow AuthMiddleware to see authenticated user
+func RateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if userID := c.GetString("user_id"); userID != "" {
+			key = "user:" + userID
+		}
+
+		if allowed, wait := limiter.Allow(key); !allowed {
+			writeRateLimited(c, wait)
+			return
+		}
+		c.Set("rate_limiter", limiter)
+		c.Set("rate_limit_key", key)
+		c.Next()
+	}
+}
+
+// chargeOperations charges request doing several operations for each of them beyond first one,
+// which RateLimitMiddleware already charged. Client pays for them by waiting before next requests
+func chargeOperations(c *gin.Context, operations int) {
+	if limiter, ok := c.Get("rate_limiter"); ok && operations > 1 {
+		limiter.(*RateLimiter).Take(c.GetString("rate_limit_key"), operations-1)
+	}
+}
+
+// AuthFailureLimitMiddleware charges every failed authentication to client IP and answers 429
+// once IP used up its bucket, so tokens cant be guessed at full speed.
+// It must precede AuthMiddleware
+func AuthFailureLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "auth:" + c.ClientIP()
+		if wait := limiter.Wait(key); wait > 0 {
+			writeRateLimited(c, wait)
+			return
+		}
+		c.Next()
+		if c.Writer.Status() == http.StatusUnauthorized {
+			limiter.Allow(key)
+		}
+	}
+}
+
+// writeRateLimited answers 429 telling client when to retry
+func writeRateLimited(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(c, http.StatusTooManyRequests, CodeRateLimited, "Too many requests, retry later")
+}
+
+// BodyLimitMiddleware rejects requests with bodies larger than limit bytes.
+// Declared length is checked upfront, bodies without it fail when reading passes limit
+func BodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			writeError(c, http.StatusRequestEntityTooLarge, CodeTooLarge,
+				"Request body cant be larger than "+strconv.FormatInt(limit, 10)+" bytes")
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// eventRequest is JSON body of REST requests changing events
+type eventRequest struct {
+	Calendar   string   `json:"calendar_id"`
+	Date       string   `json:"date"`
+	Start      string   `json:"start"`
+	End        string   `json:"end"`
+	AllDay     *bool    `json:"all_day"`
+	TimeZone   string   `json:"time_zone"`
+	Text       string   `json:"text"`
+	Recurrence string   `json:"recurrence"`
+	Exceptions []string `json:"exceptions"`
+	Reminders  []string `json:"reminders"`
+	OnConflict string   `json:"on_conflict"`
+
+	Title     *string             `json:"title"`
+	Location  *string             `json:"location"`
+	Category  *string             `json:"category"`
+	Color     *string             `json:"color"`
+	Tags      []string            `json:"tags"`
+	Attendees []calendar.Attendee `json:"attendees"`
+}
+
+func (r eventRequest) data() calendar.EventData {
+	return calendar.EventData{
+		Calendar:   r.Calendar,
+		Date:       r.Date,
+		Start:      r.Start,
+		End:        r.End,
+		AllDay:     r.AllDay,
+		TimeZone:   r.TimeZone,
+		Text:       r.Text,
+		Recurrence: r.Recurrence,
+		Exceptions: r.Exceptions,
+		Reminders:  r.Reminders,
+		OnConflict: calendar.ConflictMode(r.OnConflict),
+		Title:      r.Title,
+		Location:   r.Location,
+		Category:   r.Category,
+		Color:      r.Color,
+		Tags:       r.Tags,
+		Attendees:  r.Attendees,
+	}
+}
+
+// restContext returns calendar and user of REST request and writes error response if they are unavailable
+func restContext(c *gin.Context) (*calendar.Calendar, string, bool) {
+	db, exists := c.Get("calendar")
+	if !exists {
+		writeError(c, http.StatusInternalServerError, CodeInternal, "Calendar not available")
+		return nil, "", false
+	}
+
+	userID, ok := requestUserID(c, c.Param("user_id"))
+	if !ok {
+		return nil, "", false
+	}
+	return db.(*calendar.Calendar), userID, true
+}
+
+// bindEvent reads event from JSON body and writes error response if it is malformed
+func bindEvent(c *gin.Context) (eventRequest, bool) {
+	var request eventRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
+		return eventRequest{}, false
+	}
+	return request, true
+}
+
+// writeEventError writes response for error of changing event, invalid event data is reported as 422
+func writeEventError(c *gin.Context, err error) {
+	writeCalendarErrorStatus(c, err, http.StatusUnprocessableEntity)
+}
+
+// writeEvent writes current state of event
+func writeEvent(c *gin.Context, calendarDB *calendar.Calendar, userID string, id string, status int) {
+	event, err := calendarDB.Get(userID, id)
+	if err != nil {
+		writeEventError(c, err)
+		return
+	}
+	c.JSON(status, event)
+}
+
+// flaggedEvent is event with IDs of events it overlaps
+type flaggedEvent struct {
+	event     calendar.Event
+	conflicts []string
+}
+
+// MarshalJSON encodes event with extra field "conflicts" if there are any
+func (f flaggedEvent) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(f.event)
+	if err != nil || len(f.conflicts) == 0 {
+		return data, err
+	}
+	conflicts, err := json.Marshal(f.conflicts)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data[:len(data)-1], `,"conflicts":`...)
+	data = append(data, conflicts...)
+	return append(data, '}'), nil
+}
+
+// conflictIDs returns IDs of events overlapping stored event.
+// Change is already saved then, so failure to find conflicts only leaves it unflagged
+func conflictIDs(c *gin.Context, calendarDB *calendar.Calendar, userID string, id string) []string {
+	conflicts, err := calendarDB.Conflicts(userID, id)
+	if err != nil {
+		requestLogger(c).Warn("Failed to find conflicts of event", "event_id", id, "error", err)
+		return nil
+	}
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, event := range conflicts {
+		if !seen[event.ID()] {
+			seen[event.ID()] = true
+			ids = append(ids, event.ID())
+		}
+	}
+	return ids
+}
+
+// writeChangedEvent writes current state of created or updated event flagged with its conflicts
+func writeChangedEvent(c *gin.Context, calendarDB *calendar.Calendar, userID string, id string, status int) {
+	event, err := calendarDB.Get(userID, id)
+	if err != nil {
+		writeEventError(c, err)
+		return
+	}
+	c.JSON(status, flaggedEvent{event: event, conflicts: conflictIDs(c, calendarDB, userID, id)})
+}
+
+// RESTListHandle handles GET /api/v1/users/:user_id/events.
+// Events of several calendars are merged when calendar_id is repeated
+func RESTListHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		From      string   `form:"from" binding:"required"`
+		To        string   `form:"to" binding:"required"`
+		TimeZone  string   `form:"time_zone"`
+		Calendars []string `form:"calendar_id"`
+		Query     string   `form:"q"`
+		Category  string   `form:"category"`
+		Tags      []string `form:"tag"`
+		Sort      string   `form:"sort"`
+		Limit     int      `form:"limit"`
+		Cursor    string   `form:"cursor"`
+	}
+	if err := c.ShouldBindQuery(&request); err != nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid query: "+err.Error())
+		return
+	}
+
+	page, err := calendarDB.GetEventsInRange(userID, calendar.EventQuery{
+		From:      request.From,
+		To:        request.To,
+		TimeZone:  request.TimeZone,
+		Calendars: request.Calendars,
+		Search:    request.Query,
+		Category:  request.Category,
+		Tags:      request.Tags,
+		Sort:      request.Sort,
+		Limit:     request.Limit,
+		Cursor:    request.Cursor,
+	})
+	if err != nil {
+		writeCalendarError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":      page.Events,
+		"count":       len(page.Events),
+		"next_cursor": page.NextCursor,
+	})
+}
+
+// RESTCreateHandle handles POST /api/v1/users/:user_id/events
+func RESTCreateHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+	request, ok := bindEvent(c)
+	if !ok {
+		return
+	}
+
+	id, err := calendarDB.Add(userID, request.data())
+	if err != nil {
+		writeEventError(c, err)
+		return
+	}
+
+	c.Header("Location", "/api/v1/users/"+userID+"/events/"+id)
+	writeChangedEvent(c, calendarDB, userID, id, http.StatusCreated)
+}
+
+// RESTGetHandle handles GET /api/v1/users/:user_id/events/:event_id
+func RESTGetHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	writeEvent(c, calendarDB, userID, c.Param("event_id"), http.StatusOK)
+}
+
+// RESTReplaceHandle handles PUT /api/v1/users/:user_id/events/:event_id.
+// Fields missing in body are reset, so recurrence, reminders, tags, attendees and other optional
+// fields are removed unless given.
+// Event stays in its calendar if calendar_id is missing
+func RESTReplaceHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+	request, ok := bindEvent(c)
+	if !ok {
+		return
+	}
+
+	if request.Text == "" || (request.Date == "" && request.Start == "") {
+		writeError(c, http.StatusUnprocessableEntity, CodeValidation, "Text and date or start are required")
+		return
+	}
+
+	id := c.Param("event_id")
+	if err := calendarDB.Update(userID, id, request.data().Replacing()); err != nil {
+		writeEventError(c, err)
+		return
+	}
+	writeChangedEvent(c, calendarDB, userID, id, http.StatusOK)
+}
+
+// RESTPatchHandle handles PATCH /api/v1/users/:user_id/events/:event_id.
+// Only fields present in body are changed
+func RESTPatchHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+	request, ok := bindEvent(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("event_id")
+	if err := calendarDB.Update(userID, id, request.data()); err != nil {
+		writeEventError(c, err)
+		return
+	}
+	writeChangedEvent(c, calendarDB, userID, id, http.StatusOK)
+}
+
+// RESTDeleteHandle handles DELETE /api/v1/users/:user_id/events/:event_id
+func RESTDeleteHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	if err := calendarDB.Delete(userID, c.Param("event_id")); err != nil {
+		writeEventError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/auth"
+	"github.com/venexene/calendar/internal"
+	"github.com/venexene/calendar/webhook"
+)
+
+// Options configures optional parts of router
+type Options struct {
+	// Authenticator enables authentication of calendar routes, requests act for user from request if nil
+	Authenticator *auth.Authenticator
+	// Webhooks enables webhook routes, it should receive changes of calendar
+	Webhooks *webhook.Dispatcher
+	// Logger receives request logs, default logger of slog if nil
+	Logger *slog.Logger
+	// Metrics enables /metrics route and collection of request metrics
+	Metrics *Metrics
+	// CORSOrigins are origins browsers may call API from, "*" allows any, CORS is off if empty
+	CORSOrigins []string
+	// RateLimiter limits requests of calendar routes per user or client IP, no limit if nil
+	RateLimiter *RateLimiter
+	// TrustedProxies are IPs or CIDRs of proxies whose X-Forwarded-For headers give client IP.
+	// Headers are ignored if empty, so clients cant pick IP they are limited by
+	TrustedProxies []string
+	// MaxBodyBytes caps size of request bodies, no cap if 0
+	MaxBodyBytes int64
+}
+
+// NewRouter creates GIN router with all calendar routes.
+// Requests of routes described in OpenAPI document are validated against it
+func NewRouter(calendarDB *calendar.Calendar, options Options) *gin.Engine {
+	doc, err := LoadOpenAPI()
+	if err != nil {
+		panic(err)
+	}
+
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(options.TrustedProxies); err != nil {
+		panic(err)
+	}
+
+	router.Use(RequestIDMiddleware(logger))
+	router.Use(LoggingMiddleware())
+	if options.Metrics != nil {
+		router.Use(options.Metrics.Middleware())
+	}
+	router.Use(RecoveryMiddleware())
+	if len(options.CORSOrigins) != 0 {
+		router.Use(CORSMiddleware(options.CORSOrigins))
+	}
+	if options.MaxBodyBytes > 0 {
+		router.Use(BodyLimitMiddleware(options.MaxBodyBytes))
+	}
+	router.Use(CalendarMiddleware(calendarDB))
+
+	router.GET("/server_check", func(c *gin.Context) {
+		TestServerHandle(c)
+	})
+
+	router.GET("/openapi.yaml", func(c *gin.Context) {
+		OpenAPIHandle(c)
+	})
+
+	router.GET("/openapi.json", OpenAPIJSONHandle(doc))
+
+	router.GET("/docs", func(c *gin.Context) {
+		c.Redirect(http.StatusMovedPermanently, "/docs/")
+	})
+
+	router.GET("/docs/*filepath", func(c *gin.Context) {
+		SwaggerUIHandle(c)
+	})
+
+	api := router.Group("/")
+	if options.Authenticator != nil {
+		if options.RateLimiter != nil {
+			api.Use(AuthFailureLimitMiddleware(options.RateLimiter))
+		}
+		api.Use(AuthMiddleware(options.Authenticator))
+	}
+	if options.RateLimiter != nil {
+		api.Use(RateLimitMiddleware(options.RateLimiter))
+	}
+	api.Use(ValidationMiddleware(doc))
+
+	// Metrics reveal activity of all users, so they are read with credentials like calendars
+	if options.Metrics != nil {
+		api.GET("/metrics", gin.WrapH(options.Metrics.Handler()))
+	}
+
+	api.POST("/create_event", func(c *gin.Context) {
+		AddHandle(c)
+	})
+
+	api.POST("/update_event", func(c *gin.Context) {
+		UpdateHandle(c)
+	})
+
+	api.POST("/delete_event", func(c *gin.Context) {
+		DeleteHandle(c)
+	})
+
+	api.GET("/events_for_day", func(c *gin.Context) {
+		DayEventsHandle(c)
+	})
+
+	api.GET("/events_for_week", func(c *gin.Context) {
+		WeekEventsHandle(c)
+	})
+
+	api.GET("/events_for_month", func(c *gin.Context) {
+		MonthEventsHandle(c)
+	})
+
+	api.GET("/events", func(c *gin.Context) {
+		RangeEventsHandle(c)
+	})
+
+	api.GET("/export_ics", func(c *gin.Context) {
+		ExportICSHandle(c)
+	})
+
+	api.POST("/import_ics", func(c *gin.Context) {
+		ImportICSHandle(c)
+	})
+
+	events := api.Group("/api/v1/users/:user_id/events")
+
+	events.GET("", func(c *gin.Context) {
+		RESTListHandle(c)
+	})
+
+	events.POST("", func(c *gin.Context) {
+		RESTCreateHandle(c)
+	})
+
+	events.POST("/batch", func(c *gin.Context) {
+		RESTBatchHandle(c)
+	})
+
+	events.GET("/:event_id", func(c *gin.Context) {
+		RESTGetHandle(c)
+	})
+
+	events.PUT("/:event_id", func(c *gin.Context) {
+		RESTReplaceHandle(c)
+	})
+
+	events.PATCH("/:event_id", func(c *gin.Context) {
+		RESTPatchHandle(c)
+	})
+
+	events.DELETE("/:event_id", func(c *gin.Context) {
+		RESTDeleteHandle(c)
+	})
+
+	calendars := api.Group("/api/v1/users/:user_id/calendars")
+
+	calendars.GET("", func(c *gin.Context) {
+		CalendarListHandle(c)
+	})
+
+	calendars.POST("", func(c *gin.Context) {
+		CalendarCreateHandle(c)
+	})
+
+	calendars.GET("/:calendar_id", func(c *gin.Context) {
+		CalendarGetHandle(c)
+	})
+
+	calendars.PATCH("/:calendar_id", func(c *gin.Context) {
+		CalendarRenameHandle(c)
+	})
+
+	calendars.DELETE("/:calendar_id", func(c *gin.Context) {
+		CalendarDeleteHandle(c)
+	})
+
+	calendars.PUT("/:calendar_id/shares/:share_user_id", func(c *gin.Context) {
+		CalendarShareHandle(c)
+	})
+
+	calendars.DELETE("/:calendar_id/shares/:share_user_id", func(c *gin.Context) {
+		CalendarUnshareHandle(c)
+	})
+
+	api.GET("/api/v1/users/:user_id/freebusy", func(c *gin.Context) {
+		FreeBusyHandle(c)
+	})
+
+	api.GET("/api/v1/users/:user_id/search", func(c *gin.Context) {
+		SearchHandle(c)
+	})
+
+	api.GET(streamRoute, func(c *gin.Context) {
+		StreamHandle(c)
+	})
+
+	if options.Authenticator != nil {
+		api.POST(streamRoute+"/ticket", StreamTicketHandle(options.Authenticator))
+	}
+
+	if options.Webhooks != nil {
+		webhooks := api.Group("/api/v1/users/:user_id/webhooks")
+
+		webhooks.GET("", WebhookListHandle(options.Webhooks))
+
+		webhooks.POST("", WebhookCreateHandle(options.Webhooks))
+
+		webhooks.DELETE("/:webhook_id", WebhookDeleteHandle(options.Webhooks))
+
+		webhooks.GET("/:webhook_id/deliveries", WebhookDeliveriesHandle(options.Webhooks))
+	}
+
+	return router
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// searchResult is event matching search with its relevance
+type searchResult struct {
+	Event calendar.Event `json:"event"`
+	Score float64        `json:"score"`
+}
+
+// SearchHandle handles GET /api/v1/users/:user_id/search.
+// All calendars user can read are searched unless calendar_id is given
+func SearchHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Query     string   `form:"q" binding:"required"`
+		From      string   `form:"from"`
+		To        string   `form:"to"`
+		TimeZone  string   `form:"time_zone"`
+		Calendars []string `form:"calendar_id"`
+		Limit     int      `form:"limit"`
+	}
+	if err := c.ShouldBindQuery(&request); err != nil {
+		writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid query: "+err.Error())
+		return
+	}
+
+	found, err := calendarDB.Search(userID, calendar.SearchQuery{
+		Query:     request.Query,
+		From:      request.From,
+		To:        request.To,
+		TimeZone:  request.TimeZone,
+		Calendars: request.Calendars,
+		Limit:     request.Limit,
+	})
+	if err != nil {
+		writeCalendarError(c, err)
+		return
+	}
+
+	results := make([]searchResult, 0, len(found))
+	for _, result := range found {
+		results = append(results, searchResult{Event: result.Event, Score: result.Score})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"results": results,
+		"count":   len(results),
+	})
+}
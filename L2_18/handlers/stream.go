@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/auth"
+)
+
+// streamHeartbeat is period of comments keeping idle stream open through proxies
+const streamHeartbeat = 20 * time.Second
+
+// streamRoute is route of stream, it accepts stream tickets instead of bearer token
+const streamRoute = "/api/v1/users/:user_id/stream"
+
+// StreamHandle handles GET /api/v1/users/:user_id/stream.
+// Changes of user events are pushed as Server-Sent Events named event.created, event.updated
+// and event.deleted. Stream ends if client falls behind, clients should reconnect and reload events
+func StreamHandle(c *gin.Context) {
+	calendarDB, userID, ok := restContext(c)
+	if !ok {
+		return
+	}
+
+	subscription := calendarDB.Hub().Subscribe(userID)
+	defer subscription.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	io.WriteString(c.Writer, ": connected\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case change, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			c.SSEvent("event."+string(change.Type), gin.H{
+				"type":        change.Type,
+				"occurred_at": change.Time.UTC().Format(time.RFC3339Nano),
+				"event":       change.Event,
+			})
+			c.Writer.Flush()
+		case <-heartbeat.C:
+			io.WriteString(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
+
+// StreamTicketHandle handles POST /api/v1/users/:user_id/stream/ticket.
+// Ticket authenticates stream of user for a minute when passed as ticket query parameter
+func StreamTicketHandle(authenticator *auth.Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requestUserID(c, c.Param("user_id"))
+		if !ok {
+			return
+		}
+
+		ticket, expires, err := authenticator.IssueTicket(userID)
+		if err != nil {
+			writeError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"ticket":     ticket,
+			"expires_at": expires.UTC().Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/webhook"
+)
+
+// WebhookCreateHandle handles POST /api/v1/users/:user_id/webhooks
+func WebhookCreateHandle(dispatcher *webhook.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requestUserID(c, c.Param("user_id"))
+		if !ok {
+			return
+		}
+
+		var request struct {
+			URL    string   `json:"url" binding:"required"`
+			Events []string `json:"events"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			writeError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON data: "+err.Error())
+			return
+		}
+
+		subscription, err := dispatcher.Register(userID, request.URL, request.Events)
+		if err != nil {
+			writeCalendarErrorStatus(c, err, http.StatusUnprocessableEntity)
+			return
+		}
+
+		c.Header("Location", "/api/v1/users/"+userID+"/webhooks/"+subscription.ID)
+		c.JSON(http.StatusCreated, subscription)
+	}
+}
+
+// WebhookListHandle handles GET /api/v1/users/:user_id/webhooks
+func WebhookListHandle(dispatcher *webhook.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requestUserID(c, c.Param("user_id"))
+		if !ok {
+			return
+		}
+
+		subscriptions := dispatcher.Subscriptions(userID)
+		c.JSON(http.StatusOK, gin.H{
+			"webhooks": subscriptions,
+			"count":    len(subscriptions),
+		})
+	}
+}
+
+// WebhookDeleteHandle handles DELETE /api/v1/users/:user_id/webhooks/:webhook_id
+func WebhookDeleteHandle(dispatcher *webhook.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requestUserID(c, c.Param("user_id"))
+		if !ok {
+			return
+		}
+
+		if err := dispatcher.Unregister(userID, c.Param("webhook_id")); err != nil {
+			writeCalendarError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// WebhookDeliveriesHandle handles GET /api/v1/users/:user_id/webhooks/:webhook_id/deliveries
+func WebhookDeliveriesHandle(dispatcher *webhook.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requestUserID(c, c.Param("user_id"))
+		if !ok {
+			return
+		}
+
+		deliveries, err := dispatcher.Deliveries(userID, c.Param("webhook_id"))
+		if err != nil {
+			writeCalendarError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"deliveries": deliveries,
+			"count":      len(deliveries),
+		})
+	}
+}
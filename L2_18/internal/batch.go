@@ -0,0 +1,175 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxBatchSize is max number of operations in single batch
+const MaxBatchSize = 1000
+
+// BatchAction is kind of batch operation
+type BatchAction string
+
+// Batch actions
+const (
+	BatchCreate BatchAction = "create"
+	BatchUpdate BatchAction = "update"
+	BatchDelete BatchAction = "delete"
+)
+
+// BatchOperation is single change of batch
+type BatchOperation struct {
+	Action BatchAction
+	// ID is ID of updated or deleted event
+	ID string
+	// Data is created event or changed fields of updated event, like for Add and Update
+	Data EventData
+}
+
+// BatchResult is outcome of single operation of batch
+type BatchResult struct {
+	// ID is ID of created, updated or deleted event
+	ID string
+	// Err is error of failed operation, nil on success
+	Err error
+}
+
+// BatchError reports operation that failed atomic batch, it unwraps to error of operation
+type BatchError struct {
+	// Index is position of failed operation in batch
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("Operation #%d failed: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// applied is operation of batch already saved, kept to undo it
+type applied struct {
+	action BatchAction
+	// before is state of updated or deleted event, after is state of created or updated event
+	before Event
+	after  Event
+}
+
+// Batch applies operations in order as user, every operation sees changes of previous ones.
+// Atomic batch is all-or-nothing: first failed operation undoes previous ones and
+// Batch returns BatchError. Storages supporting transactions save atomic batch at once,
+// so crash doesnt leave it half-saved. Otherwise every operation is tried and results
+// tell which failed.
+// Listeners are notified only about changes that stay saved
+func (c *Calendar) Batch(userID string, operations []BatchOperation, atomic bool) ([]BatchResult, error) {
+	if len(operations) == 0 {
+		return nil, invalidf("Batch has no operations")
+	}
+	if len(operations) > MaxBatchSize {
+		return nil, invalidf("Batch cant have more than %d operations", MaxBatchSize)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var tx Transactional = noTransaction{}
+	if atomic {
+		tx = c.begin()
+	}
+	results := make([]BatchResult, len(operations))
+	done := make([]applied, 0, len(operations))
+	for i, operation := range operations {
+		change, err := c.apply(userID, operation)
+		if err != nil && atomic {
+			return nil, c.rollback(tx, done, &BatchError{Index: i, Err: err})
+		}
+		results[i] = BatchResult{ID: operation.ID, Err: err}
+		if err != nil {
+			continue
+		}
+		if change.action == BatchCreate {
+			results[i].ID = change.after.id
+		}
+		done = append(done, change)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, c.rollback(tx, done, err)
+	}
+
+	c.notifyApplied(done)
+	return results, nil
+}
+
+// begin opens transaction of storage, so changes are saved together.
+// Storages without transactions save every change at once. Calendar must be locked for writing
+func (c *Calendar) begin() Transactional {
+	tx, ok := c.storage.(Transactional)
+	if !ok {
+		return noTransaction{}
+	}
+	tx.Begin()
+	return tx
+}
+
+// rollback undoes saved operations and discards transaction, err is returned
+// with failure of undo if any. Calendar must be locked for writing
+func (c *Calendar) rollback(tx Transactional, done []applied, err error) error {
+	defer tx.Discard()
+	if undoErr := c.undo(done); undoErr != nil {
+		return errors.Join(err, undoErr)
+	}
+	return err
+}
+
+// notifyApplied notifies listeners about saved operations in order. Calendar must be locked for writing
+func (c *Calendar) notifyApplied(done []applied) {
+	for _, change := range done {
+		switch change.action {
+		case BatchCreate:
+			c.changed(EventCreated, change.after)
+		case BatchUpdate:
+			c.changed(EventUpdated, change.after, change.before.calendarID)
+		case BatchDelete:
+			c.changed(EventDeleted, change.before)
+		}
+	}
+}
+
+// apply saves single operation of batch. Calendar must be locked for writing
+func (c *Calendar) apply(userID string, operation BatchOperation) (applied, error) {
+	switch operation.Action {
+	case BatchCreate:
+		event, err := c.add(userID, "", operation.Data)
+		return applied{action: BatchCreate, after: event}, err
+	case BatchUpdate:
+		before, after, err := c.update(userID, operation.ID, operation.Data)
+		return applied{action: BatchUpdate, before: before, after: after}, err
+	case BatchDelete:
+		event, err := c.remove(userID, operation.ID)
+		return applied{action: BatchDelete, before: event}, err
+	default:
+		return applied{}, invalidf("Invalid batch action %q: expected create, update or delete", string(operation.Action))
+	}
+}
+
+// undo reverts saved operations in reverse order. Calendar must be locked for writing
+func (c *Calendar) undo(done []applied) error {
+	for i := len(done) - 1; i >= 0; i-- {
+		var err error
+		switch change := done[i]; change.action {
+		case BatchCreate:
+			err = c.storage.Delete(change.after.id)
+		case BatchUpdate:
+			err = c.storage.Update(change.before)
+		case BatchDelete:
+			err = c.storage.Insert(change.before)
+		}
+		if err != nil {
+			return fmt.Errorf("Error undoing batch, %d operations stay saved: %w", i+1, err)
+		}
+	}
+	return nil
+}
@@ -2,151 +2,548 @@
 package calendar
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Calendar represents an event storage system
+// Calendar represents an event storage system, safe for concurrent use
 type Calendar struct {
-	events []Event
+	mu        sync.RWMutex
+	storage   Storage
+	listeners []func(Change)
+	hub       *Hub
+	index     *searchIndex
 }
 
-// NewCalendar creates new calendar object
+// NewCalendar creates new calendar object with in-memory storage
 func NewCalendar() *Calendar {
+	return NewCalendarWithStorage(NewMemoryStorage())
+}
+
+// NewCalendarWithStorage creates new calendar object backed by given storage
+func NewCalendarWithStorage(storage Storage) *Calendar {
 	return &Calendar{
-		events: []Event{},
+		storage: storage,
+		hub:     NewHub(),
+		index:   newSearchIndex(),
 	}
 }
 
-// Event represents a calendar event with user, date and description
-type Event struct {
-	userID string
-	date   time.Time
-	text   string
+// Close closes storage of calendar
+func (c *Calendar) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.storage.Close()
 }
 
-func newEvent(userID string, date string, text string) (*Event, error) {
-	dateTime, err := time.Parse("2006-01-02", date)
+// Stats returns numbers of objects kept in storage of calendar
+func (c *Calendar) Stats() (StorageStats, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.storage.Stats()
+}
+
+// Add adds new event into caldenar and returns its ID.
+// User needs write access to calendar of event, event is owned by owner of calendar.
+// Exact duplicates are rejected, overlapping events are rejected in ConflictReject mode
+func (c *Calendar) Add(userID string, data EventData) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	event, err := c.add(userID, "", data)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid date: %v", err)
+		return "", err
 	}
+	c.changed(EventCreated, event)
+	return event.id, nil
+}
 
-	if userID == "" {
-		return nil, fmt.Errorf("UserID cant be empty")
+// add saves new event without notifying about it, new ID is generated if id is empty.
+// Calendar must be locked for writing
+func (c *Calendar) add(userID string, id string, data EventData) (Event, error) {
+	if err := data.OnConflict.validate(); err != nil {
+		return Event{}, fmt.Errorf("Error creating new event: %w", err)
 	}
+	event, err := newEvent(userID, data)
+	if err != nil {
+		return Event{}, fmt.Errorf("Error creating new event: %w", err)
+	}
+	if id != "" {
+		event.id = id
+	}
+
+	collection, err := c.accessible(userID, data.Calendar, AccessWrite)
+	if err != nil {
+		return Event{}, fmt.Errorf("Error creating new event: %w", err)
+	}
+	event.userID = collection.OwnerID
+	event.calendarID = collection.ID
 
-	if text == "" {
-		return nil, fmt.Errorf("Event text cant be empty")
+	if err := c.checkConflicts(userID, *event, data.OnConflict); err != nil {
+		return Event{}, fmt.Errorf("Error creating new event: %w", err)
 	}
 
-	return &Event{
-		userID: userID,
-		date:   dateTime,
-		text:   text,
-	}, nil
+	if err := c.storage.Insert(*event); err != nil {
+		return Event{}, fmt.Errorf("Error saving new event: %w", err)
+	}
+	return *event, nil
 }
 
-// Add adds new event into caldenar
-func (c *Calendar) Add(userID string, date string, text string) error {
-	event, err := newEvent(userID, date, text)
+// findEvent returns event by ID if user has required access to its calendar.
+// Events of calendars user cant read are reported as missing
+func (c *Calendar) findEvent(userID string, id string, required Access) (Event, error) {
+	event, err := c.storage.Get(id)
 	if err != nil {
-		return fmt.Errorf("Error creating new event: %v", err)
+		return Event{}, ErrNotFound
 	}
-	c.events = append(c.events, *event)
-	return nil
+	if _, err := c.accessible(userID, event.calendarID, required); err != nil {
+		if errors.Is(err, ErrCalendarNotFound) {
+			return Event{}, ErrNotFound
+		}
+		return Event{}, err
+	}
+	return event, nil
 }
 
-func (c *Calendar) findEvent(userID string, date time.Time, text string) (int, *Event, error) {
-	for i, event := range c.events {
-		if event.userID == userID && event.date == date && event.text == text {
-			return i, &c.events[i], nil
-		}
+// Get returns event by ID from calendar user can read
+func (c *Calendar) Get(userID string, id string) (Event, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.findEvent(userID, id, AccessRead)
+}
+
+// Update changes event with non-empty fields of data, data without changes is invalid.
+// Missing start, end and time zone are kept from event when other time fields change,
+// moving event to other calendar requires write access to both calendars.
+// Changes making event exact duplicate are rejected, in ConflictReject mode
+// moving event in time or to other calendar is rejected if it would overlap other events
+func (c *Calendar) Update(userID string, id string, data EventData) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, event, err := c.update(userID, id, data)
+	if err != nil {
+		return err
 	}
-	return 0, nil, fmt.Errorf("Event not found")
+	c.changed(EventUpdated, event, stored.calendarID)
+	return nil
 }
 
-// Update provides ability to change event text
-func (c *Calendar) Update(userID string, date string, text string, newText string) error {
-	dateTime, err := time.Parse("2006-01-02", date)
+// update changes event without notifying about it and returns its states before and after change.
+// Calendar must be locked for writing
+func (c *Calendar) update(userID string, id string, data EventData) (Event, Event, error) {
+	if err := data.OnConflict.validate(); err != nil {
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", err)
+	}
+	if data.empty() {
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", invalidf("Nothing to update"))
+	}
+
+	event, err := c.findEvent(userID, id, AccessWrite)
 	if err != nil {
-		return fmt.Errorf("Invalid date: %v", err)
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", err)
+	}
+
+	stored := event
+	if data.Calendar != "" && data.Calendar != event.calendarID {
+		collection, err := c.accessible(userID, data.Calendar, AccessWrite)
+		if err != nil {
+			return Event{}, Event{}, fmt.Errorf("Error updating event: %w", err)
+		}
+		event.userID = collection.OwnerID
+		event.calendarID = collection.ID
+	}
+
+	if data.changesTime() {
+		if err := event.changeTime(data); err != nil {
+			return Event{}, Event{}, fmt.Errorf("Error updating event: %w", invalid(err))
+		}
 	}
 
-	_, event, err := c.findEvent(userID, dateTime, text)
+	if err := event.setRecurrence(data); err != nil {
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", invalid(err))
+	}
+
+	if err := event.setReminders(data); err != nil {
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", invalid(err))
+	}
+
+	if err := event.setDetails(data); err != nil {
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", invalid(err))
+	}
+
+	if data.Text != "" {
+		if err := validText(data.Text); err != nil {
+			return Event{}, Event{}, fmt.Errorf("Error updating event: %w", err)
+		}
+		event.text = data.Text
+	}
+
+	mode := data.OnConflict
+	moved := event.calendarID != stored.calendarID || data.changesTime() ||
+		data.Recurrence != "" || data.Exceptions != nil
+	if !moved {
+		mode = ConflictAllow
+	}
+	if err := c.checkConflicts(userID, event, mode); err != nil {
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", err)
+	}
+
+	if err := c.storage.Update(event); err != nil {
+		return Event{}, Event{}, fmt.Errorf("Error updating event: %w", err)
+	}
+	return stored, event, nil
+}
+
+// Delete delets event from calendar, user needs write access to it
+func (c *Calendar) Delete(userID string, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	event, err := c.remove(userID, id)
 	if err != nil {
-		return fmt.Errorf("Error updating event: %v", err)
+		return err
 	}
-	event.text = newText
+	c.changed(EventDeleted, event)
 	return nil
 }
 
-// Delete delets event from calendar
-func (c *Calendar) Delete(userID string, date string, text string) error {
-	dateTime, err := time.Parse("2006-01-02", date)
+// remove deletes event without notifying about it and returns its last state.
+// Calendar must be locked for writing
+func (c *Calendar) remove(userID string, id string) (Event, error) {
+	event, err := c.findEvent(userID, id, AccessWrite)
 	if err != nil {
-		return fmt.Errorf("Invalid date: %v", err)
+		return Event{}, fmt.Errorf("Error deleting event: %w", err)
+	}
+	if err := c.storage.Delete(id); err != nil {
+		return Event{}, fmt.Errorf("Error deleting event: %w", err)
 	}
+	return event, nil
+}
 
-	ind, _, err := c.findEvent(userID, dateTime, text)
+// eventsBetween returns events of calendars happening within [from, to) ordered by start.
+// Recurring events are expanded into occurrences
+func (c *Calendar) eventsBetween(calendarIDs []string, from time.Time, to time.Time) ([]Event, error) {
+	c.mu.RLock()
+	events, err := c.storedBetween(calendarIDs, from, to)
+	c.mu.RUnlock()
 	if err != nil {
-		return fmt.Errorf("Error deleting event: %v", err)
+		return nil, err
 	}
-	c.events = append(c.events[:ind], c.events[ind+1:]...)
-	return nil
+	return expandEvents(events, from, to), nil
 }
 
-// GetEventsByDay returns events texts by day
-func (c *Calendar) GetEventsByDay(userID string, day string) ([]string, error) {
-	dayEvents := []string{}
+// storedBetween returns stored events of calendars that may happen within [from, to).
+// Calendar must be locked
+func (c *Calendar) storedBetween(calendarIDs []string, from time.Time, to time.Time) ([]Event, error) {
+	events := []Event{}
+	for _, calendarID := range calendarIDs {
+		calendarEvents, err := c.storage.Range(calendarID, from, to)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, calendarEvents...)
+	}
+	return events, nil
+}
 
-	if dayDate, err := time.Parse("2006-01-02", day); err == nil {
-		for _, event := range c.events {
-			if event.userID == userID && event.date == dayDate {
-				dayEvents = append(dayEvents, event.text)
-			}
+// expandEvents returns events and occurrences of recurring events happening within [from, to)
+// ordered by start
+func expandEvents(events []Event, from time.Time, to time.Time) []Event {
+	found := []Event{}
+	for _, event := range events {
+		if event.recurrence != nil {
+			found = append(found, event.occurrences(from, to)...)
+		} else if event.overlaps(from, to) {
+			found = append(found, event)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		start, _ := found[i].span(from.Location())
+		other, _ := found[j].span(from.Location())
+		return start.Before(other)
+	})
+	return found
+}
+
+// readable returns IDs of calendars after checking user can read them.
+// No calendars mean default calendar of user
+func (c *Calendar) readable(userID string, calendarIDs []string) ([]string, error) {
+	if len(calendarIDs) == 0 {
+		return []string{userID}, nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := map[string]struct{}{}
+	found := make([]string, 0, len(calendarIDs))
+	for _, id := range calendarIDs {
+		collection, err := c.accessible(userID, id, AccessRead)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[collection.ID]; !ok {
+			seen[collection.ID] = struct{}{}
+			found = append(found, collection.ID)
 		}
-		return dayEvents, nil
+	}
+	return found, nil
+}
+
+// GetEventsByDay returns events of default calendar of user by day in given time zone
+func (c *Calendar) GetEventsByDay(userID string, day string, timeZone string) ([]Event, error) {
+	from, err := parseDay(day, timeZone)
+	if err != nil {
+		return nil, invalid(err)
 	}
 
-	return nil, fmt.Errorf("Invalid format of date")
+	return c.eventsBetween([]string{userID}, from, from.AddDate(0, 0, 1))
 }
 
-// GetEventsByWeek returns events texts by week
-func (c *Calendar) GetEventsByWeek(userID string, week string) ([]string, error) {
-	weekEvents := []string{}
+// GetEventsByWeek returns events of default calendar of user by week starting from given day in given time zone
+func (c *Calendar) GetEventsByWeek(userID string, week string, timeZone string) ([]Event, error) {
+	from, err := parseDay(week, timeZone)
+	if err != nil {
+		return nil, invalid(err)
+	}
 
-	startDate, err := time.Parse("2006-01-02", week)
+	return c.eventsBetween([]string{userID}, from, from.AddDate(0, 0, 7))
+}
+
+// GetEventsByMonth returns events of default calendar of user by month of given day in given time zone
+func (c *Calendar) GetEventsByMonth(userID string, day string, timeZone string) ([]Event, error) {
+	date, err := parseDay(day, timeZone)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid date format")
+		return nil, invalid(err)
 	}
 
-	endDate := startDate.AddDate(0, 0, 6)
+	from := date.AddDate(0, 0, 1-date.Day())
+	return c.eventsBetween([]string{userID}, from, from.AddDate(0, 1, 0))
+}
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
 
-	for _, event := range c.events {
-		if event.userID == userID &&
-			(event.date.Equal(startDate) || event.date.After(startDate)) &&
-			(event.date.Equal(endDate) || event.date.Before(endDate)) {
-			weekEvents = append(weekEvents, event.text)
+// EventQuery describes query of events within arbitrary range
+type EventQuery struct {
+	// From is start of range as day in 2006-01-02 format or RFC 3339 time
+	From string
+	// To is end of range, the day itself is included when given as day, RFC 3339 time is excluded
+	To string
+	// TimeZone is IANA name of zone days of range belong to, UTC if empty
+	TimeZone string
+	// Calendars are IDs of calendars whose events are merged, default calendar of user if empty
+	Calendars []string
+	// Search keeps only events whose text, title or location contains the text, case-insensitive
+	Search string
+	// Category keeps only events of the category, case-insensitive
+	Category string
+	// Tags keep only events having all of the tags
+	Tags []string
+	// Sort is order of events: "start" (default), "-start", "text" or "-text"
+	Sort string
+	// Limit is max number of events in page, 50 by default
+	Limit int
+	// Cursor is NextCursor of previous page
+	Cursor string
+}
+
+// EventPage is single page of query result
+type EventPage struct {
+	Events []Event
+	// NextCursor continues query after this page, empty on last page
+	NextCursor string
+}
+
+// pageCursor is position after last event of page
+type pageCursor struct {
+	Start time.Time `json:"s"`
+	ID    string    `json:"i"`
+	Text  string    `json:"t,omitempty"`
+}
+
+func parseRangeBound(value string, location *time.Location, isEnd bool) (time.Time, error) {
+	if day, err := time.ParseInLocation(dateLayout, value, location); err == nil {
+		if isEnd {
+			return day.AddDate(0, 0, 1), nil
 		}
+		return day, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, invalidf("Invalid range bound %q: expected 2006-01-02 or RFC 3339", value)
 	}
+	return t.In(location), nil
+}
 
-	return weekEvents, nil
+// parseRange parses bounds of query range and time zone they are given in
+func parseRange(fromValue string, toValue string, timeZone string) (time.Time, time.Time, *time.Location, error) {
+	location, err := loadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, time.Time{}, nil, invalid(err)
+	}
+	if fromValue == "" || toValue == "" {
+		return time.Time{}, time.Time{}, nil, invalidf("Range start and end are required")
+	}
+	from, err := parseRangeBound(fromValue, location, false)
+	if err != nil {
+		return time.Time{}, time.Time{}, nil, err
+	}
+	to, err := parseRangeBound(toValue, location, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, nil, err
+	}
+	if !to.After(from) {
+		return time.Time{}, time.Time{}, nil, invalidf("Range end must be after its start")
+	}
+	return from, to, location, nil
 }
 
-// GetEventsByMonth returns events texts by month
-func (c *Calendar) GetEventsByMonth(userID string, day string) ([]string, error) {
-	monthEvents := []string{}
+// eventLess returns ordering of events by sort field
+func eventLess(sortBy string) (func(a, b pageCursor) bool, error) {
+	byStart := func(a, b pageCursor) bool {
+		if !a.Start.Equal(b.Start) {
+			return a.Start.Before(b.Start)
+		}
+		return a.ID < b.ID
+	}
+	byText := func(a, b pageCursor) bool {
+		if a.Text != b.Text {
+			return a.Text < b.Text
+		}
+		return byStart(a, b)
+	}
 
-	if monthDate, err := time.Parse("2006-01-02", day); err == nil {
-		for _, event := range c.events {
-			if event.userID == userID &&
-				event.date.Month() == monthDate.Month() &&
-				event.date.Year() == monthDate.Year() {
-				monthEvents = append(monthEvents, event.text)
-			}
+	switch sortBy {
+	case "", "start":
+		return byStart, nil
+	case "-start":
+		return func(a, b pageCursor) bool { return byStart(b, a) }, nil
+	case "text":
+		return byText, nil
+	case "-text":
+		return func(a, b pageCursor) bool { return byText(b, a) }, nil
+	default:
+		return nil, invalidf("Invalid sort %q", sortBy)
+	}
+}
+
+// matches reports whether event passes filters of query, search is lowercase query text
+func (e Event) matches(query EventQuery, search string) bool {
+	if search != "" && !strings.Contains(strings.ToLower(e.text), search) &&
+		!strings.Contains(strings.ToLower(e.title), search) &&
+		!strings.Contains(strings.ToLower(e.location), search) {
+		return false
+	}
+	if query.Category != "" && !strings.EqualFold(e.category, strings.TrimSpace(query.Category)) {
+		return false
+	}
+	return e.hasTags(query.Tags)
+}
+
+// FilterEvents keeps events of category having all tags like EventQuery, empty filters keep all events
+func FilterEvents(events []Event, category string, tags []string) []Event {
+	query := EventQuery{Category: category, Tags: tags}
+	found := []Event{}
+	for _, event := range events {
+		if event.matches(query, "") {
+			found = append(found, event)
 		}
-		return monthEvents, nil
 	}
+	return found
+}
 
-	return nil, fmt.Errorf("Invalid format of date")
+// GetEventsInRange returns page of events of calendars user can read happening within query range
+func (c *Calendar) GetEventsInRange(userID string, query EventQuery) (EventPage, error) {
+	from, to, location, err := parseRange(query.From, query.To, query.TimeZone)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = defaultPageLimit
+	}
+	if limit < 0 || limit > maxPageLimit {
+		return EventPage{}, invalidf("Limit must be between 1 and %d", maxPageLimit)
+	}
+
+	less, err := eventLess(query.Sort)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	var after *pageCursor
+	if query.Cursor != "" {
+		data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+		if err == nil {
+			after = &pageCursor{}
+			err = json.Unmarshal(data, after)
+		}
+		if err != nil {
+			return EventPage{}, invalidf("Invalid cursor")
+		}
+	}
+
+	calendarIDs, err := c.readable(userID, query.Calendars)
+	if err != nil {
+		return EventPage{}, err
+	}
+	events, err := c.eventsBetween(calendarIDs, from, to)
+	if err != nil {
+		return EventPage{}, err
+	}
+
+	search := strings.ToLower(query.Search)
+	keys := make([]pageCursor, 0, len(events))
+	matched := make([]Event, 0, len(events))
+	for _, event := range events {
+		if !event.matches(query, search) {
+			continue
+		}
+		start, _ := event.span(location)
+		key := pageCursor{Start: start, ID: event.id}
+		if strings.HasSuffix(query.Sort, "text") {
+			key.Text = event.text
+		}
+		if after != nil && !less(*after, key) {
+			continue
+		}
+		keys = append(keys, key)
+		matched = append(matched, event)
+	}
+
+	order := make([]int, len(matched))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return less(keys[order[i]], keys[order[j]])
+	})
+
+	page := EventPage{Events: []Event{}}
+	for _, i := range order {
+		if len(page.Events) == limit {
+			data, err := json.Marshal(keys[order[limit-1]])
+			if err != nil {
+				return EventPage{}, fmt.Errorf("Failed to encode cursor: %w", err)
+			}
+			page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+			break
+		}
+		page.Events = append(page.Events, matched[i])
+	}
+	return page, nil
 }
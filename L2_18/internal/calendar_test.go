@@ -0,0 +1,1688 @@
+package calendar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func texts(events []Event) []string {
+	eventTexts := make([]string, 0, len(events))
+	for _, event := range events {
+		eventTexts = append(eventTexts, event.Text())
+	}
+	return eventTexts
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		date    string
+		text    string
+		wantErr bool
+	}{
+		{
+			name:    "valid event",
+			userID:  "u1",
+			date:    "2024-01-10",
+			text:    "meeting",
+			wantErr: false,
+		},
+		{
+			name:    "invalid date",
+			userID:  "u1",
+			date:    "10.01.2024",
+			text:    "meeting",
+			wantErr: true,
+		},
+		{
+			name:    "empty user",
+			userID:  "",
+			date:    "2024-01-10",
+			text:    "meeting",
+			wantErr: true,
+		},
+		{
+			name:    "empty text",
+			userID:  "u1",
+			date:    "2024-01-10",
+			text:    "",
+			wantErr: true,
+		},
+		{
+			name:    "too long text",
+			userID:  "u1",
+			date:    "2024-01-10",
+			text:    strings.Repeat("ы", maxEventText+1),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCalendar()
+			id, err := c.Add(tt.userID, EventData{Date: tt.date, Text: tt.text})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && id == "" {
+				t.Errorf("Add() returned empty ID")
+			}
+		})
+	}
+}
+
+func TestUpdateAndDelete(t *testing.T) {
+	c := NewCalendar()
+	id, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	if err := c.Update("u2", id, EventData{Text: "stolen"}); err == nil {
+		t.Errorf("Update() of foreign event succeeded")
+	}
+	if err := c.Update("u1", id, EventData{Date: "2024-01-11", Text: "standup"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	events, err := c.GetEventsByDay("u1", "2024-01-11", "")
+	if err != nil {
+		t.Fatalf("GetEventsByDay() error = %v", err)
+	}
+	if len(events) != 1 || events[0].Text() != "standup" || events[0].ID() != id {
+		t.Errorf("GetEventsByDay() = %v, want [standup]", texts(events))
+	}
+
+	if err := c.Delete("u2", id); err == nil {
+		t.Errorf("Delete() of foreign event succeeded")
+	}
+	if err := c.Delete("u1", id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := c.Delete("u1", id); err == nil {
+		t.Errorf("Delete() of deleted event succeeded")
+	}
+}
+
+func TestPartialTimeUpdate(t *testing.T) {
+	c := NewCalendar()
+	timed, err := c.Add("u1", EventData{Start: "2024-01-10T09:00:00Z", End: "2024-01-10T10:00:00Z", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	allDay, err := c.Add("u1", EventData{Date: "2024-01-12", Text: "holiday"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	yes, no := true, false
+
+	tests := []struct {
+		name  string
+		id    string
+		data  EventData
+		start string
+		end   string
+		day   bool
+	}{
+		{"end only", timed, EventData{End: "2024-01-10T11:00:00Z"}, "2024-01-10T09:00:00Z", "2024-01-10T11:00:00Z", false},
+		{"time zone keeps instant", timed, EventData{TimeZone: "Europe/Berlin"}, "2024-01-10T10:00:00+01:00", "2024-01-10T12:00:00+01:00", false},
+		{"all day widens", timed, EventData{AllDay: &yes}, "2024-01-10T00:00:00+01:00", "2024-01-11T00:00:00+01:00", true},
+		{"time zone keeps days", allDay, EventData{TimeZone: "Asia/Tokyo"}, "2024-01-12T00:00:00+09:00", "2024-01-13T00:00:00+09:00", true},
+		{"all-day end", allDay, EventData{End: "2024-01-14T00:00:00+09:00"}, "2024-01-12T00:00:00+09:00", "2024-01-14T00:00:00+09:00", true},
+		{"not all day", allDay, EventData{AllDay: &no}, "2024-01-12T00:00:00+09:00", "2024-01-14T00:00:00+09:00", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.Update("u1", tt.id, tt.data); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			event, err := c.Get("u1", tt.id)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			start, end := event.Start().Format(time.RFC3339), event.End().Format(time.RFC3339)
+			if start != tt.start || end != tt.end || event.AllDay() != tt.day {
+				t.Errorf("event = %s - %s all day %v, want %s - %s all day %v", start, end, event.AllDay(), tt.start, tt.end, tt.day)
+			}
+		})
+	}
+
+	if err := c.Update("u1", timed, EventData{End: "2024-01-09T00:00:00Z"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Update() with end before start error = %v, want ErrValidation", err)
+	}
+	if err := c.Update("u1", timed, EventData{OnConflict: ConflictReject}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Update() without changes error = %v, want ErrValidation", err)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	c := NewCalendar()
+	for _, e := range []struct{ userID, date, text string }{
+		{"u1", "2024-01-10", "a"},
+		{"u1", "2024-01-12", "b"},
+		{"u1", "2024-01-20", "c"},
+		{"u1", "2024-02-01", "d"},
+		{"u2", "2024-01-10", "e"},
+	} {
+		if _, err := c.Add(e.userID, EventData{Date: e.date, Text: e.text}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query func(string, string, string) ([]Event, error)
+		date  string
+		want  int
+	}{
+		{"day", c.GetEventsByDay, "2024-01-10", 1},
+		{"week", c.GetEventsByWeek, "2024-01-08", 2},
+		{"month", c.GetEventsByMonth, "2024-01-01", 3},
+		{"empty month", c.GetEventsByMonth, "2024-03-01", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := tt.query("u1", tt.date, "")
+			if err != nil {
+				t.Fatalf("query error = %v", err)
+			}
+			if len(events) != tt.want {
+				t.Errorf("got %d events, want %d", len(events), tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeZones(t *testing.T) {
+	c := NewCalendar()
+	allDay := true
+	for _, data := range []EventData{
+		{Start: "2024-01-10T22:00:00Z", End: "2024-01-10T23:00:00Z", Text: "late call"},
+		{Date: "2024-01-10", TimeZone: "America/New_York", Text: "holiday"},
+		{Start: "2024-01-12T10:00:00+03:00", End: "2024-01-13T10:00:00+03:00", AllDay: &allDay, Text: "trip"},
+	} {
+		if _, err := c.Add("u1", data); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		day      string
+		timeZone string
+		want     []string
+	}{
+		{"utc day", "2024-01-10", "", []string{"holiday", "late call"}},
+		{"next day in moscow", "2024-01-11", "Europe/Moscow", []string{"late call"}},
+		{"all-day is floating", "2024-01-10", "Asia/Tokyo", []string{"holiday"}},
+		{"multi-day first", "2024-01-12", "", []string{"trip"}},
+		{"multi-day last", "2024-01-13", "", []string{"trip"}},
+		{"after multi-day", "2024-01-14", "", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := c.GetEventsByDay("u1", tt.day, tt.timeZone)
+			if err != nil {
+				t.Fatalf("GetEventsByDay() error = %v", err)
+			}
+			if fmt.Sprint(texts(events)) != fmt.Sprint(tt.want) {
+				t.Errorf("GetEventsByDay() = %v, want %v", texts(events), tt.want)
+			}
+		})
+	}
+
+	if _, err := c.GetEventsByDay("u1", "2024-01-10", "Mars/Olympus"); err == nil {
+		t.Errorf("GetEventsByDay() accepted unknown time zone")
+	}
+	if _, err := c.Add("u1", EventData{Start: "2024-01-10T10:00:00Z", End: "2024-01-10T09:00:00Z", Text: "x"}); err == nil {
+		t.Errorf("Add() accepted end before start")
+	}
+}
+
+func TestRecurrence(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    EventData
+		from    string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "daily with count",
+			data: EventData{Date: "2024-01-30", Recurrence: "FREQ=DAILY;COUNT=5"},
+			from: "2024-02-01",
+			want: 3,
+		},
+		{
+			name: "weekly on weekdays",
+			data: EventData{Start: "2024-01-01T09:00:00Z", Recurrence: "FREQ=WEEKLY;BYDAY=MO,WE"},
+			from: "2024-01-01",
+			want: 10,
+		},
+		{
+			name: "weekly with exceptions",
+			data: EventData{Start: "2024-01-01T09:00:00Z", Recurrence: "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240110", Exceptions: []string{"2024-01-03"}},
+			from: "2024-01-01",
+			want: 3,
+		},
+		{
+			name: "every other week",
+			data: EventData{Date: "2024-01-01", Recurrence: "FREQ=WEEKLY;INTERVAL=2"},
+			from: "2024-01-01",
+			want: 3,
+		},
+		{
+			name: "monthly skips short months",
+			data: EventData{Date: "2024-01-31", Recurrence: "FREQ=MONTHLY"},
+			from: "2024-02-01",
+			want: 0,
+		},
+		{
+			name: "monthly on month day",
+			data: EventData{Date: "2024-01-20", Recurrence: "FREQ=MONTHLY;BYMONTHDAY=5"},
+			from: "2024-02-01",
+			want: 1,
+		},
+		{
+			name: "yearly",
+			data: EventData{Date: "2020-01-15", Recurrence: "FREQ=YEARLY"},
+			from: "2024-01-01",
+			want: 1,
+		},
+		{
+			name:    "unknown frequency",
+			data:    EventData{Date: "2024-01-01", Recurrence: "FREQ=HOURLY"},
+			wantErr: true,
+		},
+		{
+			name:    "until with count",
+			data:    EventData{Date: "2024-01-01", Recurrence: "FREQ=DAILY;COUNT=2;UNTIL=20240110"},
+			wantErr: true,
+		},
+		{
+			name:    "exceptions without rule",
+			data:    EventData{Date: "2024-01-01", Exceptions: []string{"2024-01-01"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCalendar()
+			tt.data.Text = "repeat"
+			_, err := c.Add("u1", tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			events, err := c.GetEventsByMonth("u1", tt.from, "")
+			if err != nil {
+				t.Fatalf("GetEventsByMonth() error = %v", err)
+			}
+			if len(events) != tt.want {
+				t.Errorf("got %d occurrences, want %d", len(events), tt.want)
+			}
+		})
+	}
+}
+
+func TestFileStorageRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+
+	storage, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	c := NewCalendarWithStorage(storage)
+	id, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	deletedID, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "lunch"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u1", EventData{Date: "2024-01-03", Text: "weekly", Recurrence: "FREQ=WEEKLY", Exceptions: []string{"2024-01-17"}, Reminders: []string{"30m"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := c.Update("u1", id, EventData{Text: "standup"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := c.Delete("u1", deletedID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	work, err := c.CreateCollection("u1", "work")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	if _, err := c.ShareCollection("u1", work.ID, "u2", AccessRead); err != nil {
+		t.Fatalf("ShareCollection() error = %v", err)
+	}
+	if _, err := c.Add("u1", EventData{Calendar: work.ID, Date: "2024-01-10", Text: "review"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	storage, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() reopen error = %v", err)
+	}
+	c = NewCalendarWithStorage(storage)
+	defer c.Close()
+
+	events, err := c.GetEventsByDay("u1", "2024-01-10", "")
+	if err != nil {
+		t.Fatalf("GetEventsByDay() error = %v", err)
+	}
+	if got := texts(events); len(got) != 2 || got[0] != "standup" || got[1] != "weekly" {
+		t.Errorf("restored events = %v, want [standup weekly]", got)
+	}
+
+	events, err = c.GetEventsByMonth("u1", "2024-01-01", "")
+	if err != nil {
+		t.Fatalf("GetEventsByMonth() error = %v", err)
+	}
+	if len(events) != 5 {
+		t.Errorf("restored recurring event has %d occurrences in month, want 5", len(events))
+	}
+
+	reminded, err := c.storage.Reminded()
+	if err != nil || len(reminded) != 1 || fmt.Sprint(reminded[0].Reminders()) != "[30m0s]" {
+		t.Errorf("restored reminded events = %v, error = %v", texts(reminded), err)
+	}
+
+	page, err := c.GetEventsInRange("u2", EventQuery{From: "2024-01-10", To: "2024-01-10", Calendars: []string{work.ID}})
+	if err != nil {
+		t.Fatalf("GetEventsInRange() of restored calendar error = %v", err)
+	}
+	if got := texts(page.Events); len(got) != 1 || got[0] != "review" {
+		t.Errorf("restored shared events = %v, want [review]", got)
+	}
+}
+
+func TestFileStorageTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	storage, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	c := NewCalendarWithStorage(storage)
+	defer c.Close()
+
+	id, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	records := func() int {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		return bytes.Count(data, []byte("\n"))
+	}
+
+	if _, err := c.Batch("u1", []BatchOperation{
+		{Action: BatchCreate, Data: EventData{Date: "2024-01-11", Text: "lunch"}},
+		{Action: BatchUpdate, ID: id, Data: EventData{Text: "standup"}},
+	}, true); err != nil {
+		t.Fatalf("Batch() error = %v", err)
+	}
+	if got := records(); got != 2 {
+		t.Errorf("log has %d records after atomic batch, want 2", got)
+	}
+	if _, err := c.Batch("u1", []BatchOperation{
+		{Action: BatchCreate, Data: EventData{Date: "2024-01-12", Text: "trip"}},
+		{Action: BatchDelete, ID: "missing"},
+	}, true); err == nil {
+		t.Fatalf("Batch() with missing event succeeded")
+	}
+	if got := records(); got != 2 {
+		t.Errorf("log has %d records after failed batch, want 2", got)
+	}
+
+	// uncommitted transaction is lost as if service crashed before commit
+	storage.Begin()
+	if err := c.Update("u1", id, EventData{Text: "retro"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	restored, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() reopen error = %v", err)
+	}
+	defer restored.Close()
+	events, err := NewCalendarWithStorage(restored).GetEventsByWeek("u1", "2024-01-10", "")
+	if err != nil {
+		t.Fatalf("GetEventsByWeek() error = %v", err)
+	}
+	if got := fmt.Sprint(texts(events)); got != "[standup lunch]" {
+		t.Errorf("restored events = %s, want [standup lunch]", got)
+	}
+}
+
+func TestFileStorageTruncatedTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	log := `{"op":"insert","event":{"id":"a","user_id":"u1","start":"2024-01-10T00:00:00Z","end":"2024-01-10T00:00:00Z","all_day":true,"text":"meeting"}}` + "\n" +
+		`{"op":"update","event":{"id":"a","user_id":"u1","start":"2024-01-10T00:00:00Z","end":"2024-01-10T00:00:00Z","all_day":true,"text":"standup"}}` + "\n"
+	// last record was cut short by crash while it was written
+	torn := `{"op":"insert","event":{"id":"b","user_id":"u1","sta`
+	if err := os.WriteFile(path, []byte(log+torn), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	storage, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != log {
+		t.Errorf("log after replay = %q, want incomplete record cut off", data)
+	}
+	c := NewCalendarWithStorage(storage)
+	if _, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "lunch"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	c.Close()
+
+	storage, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() reopen error = %v", err)
+	}
+	defer storage.Close()
+	events, err := NewCalendarWithStorage(storage).GetEventsByDay("u1", "2024-01-10", "")
+	if err != nil {
+		t.Fatalf("GetEventsByDay() error = %v", err)
+	}
+	if got := fmt.Sprint(texts(events)); got != "[standup lunch]" && got != "[lunch standup]" {
+		t.Errorf("restored events = %s, want standup and lunch", got)
+	}
+
+	if err := os.WriteFile(path, []byte(torn+"\n"+log), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := NewFileStorage(path); err == nil {
+		t.Errorf("NewFileStorage() accepted broken record in the middle of log")
+	}
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	var log strings.Builder
+	log.WriteString(`{"op":"save_calendar","calendar":{"id":"work","owner_id":"u1","name":"work"}}` + "\n")
+	for i := 0; i < compactMinRecords; i++ {
+		fmt.Fprintf(&log, `{"op":"insert","event":{"id":"e%d","user_id":"u1","start":"2024-01-10T09:00:00Z","end":"2024-01-10T10:00:00Z","text":"draft"}}`+"\n", i)
+		if i%100 != 0 {
+			fmt.Fprintf(&log, `{"op":"delete","id":"e%d"}`+"\n", i)
+		}
+	}
+	if err := os.WriteFile(path, []byte(log.String()), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	storage, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if got := bytes.Count(data, []byte("\n")); got != 11 {
+		t.Errorf("compacted log has %d records, want calendar and 10 events", got)
+	}
+
+	c := NewCalendarWithStorage(storage)
+	if _, err := c.Add("u1", EventData{Calendar: "work", Date: "2024-01-11", Text: "review"}); err != nil {
+		t.Fatalf("Add() after compaction error = %v", err)
+	}
+	c.Close()
+
+	storage, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() reopen error = %v", err)
+	}
+	defer storage.Close()
+	stats, err := storage.Stats()
+	if err != nil || stats.Events != 11 || stats.Calendars != 1 {
+		t.Errorf("restored stats = %+v, error = %v, want 11 events in 1 calendar", stats, err)
+	}
+}
+
+func TestFileStorageSubseconds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	storage, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	c := NewCalendarWithStorage(storage)
+	id, err := c.Add("u1", EventData{Start: "2024-01-10T09:00:00.123456789Z", End: "2024-01-10T10:00:00.5Z", Text: "standup"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	event, _ := storage.Get(id)
+	c.Close()
+
+	storage, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() reopen error = %v", err)
+	}
+	defer storage.Close()
+	restored, err := storage.Get(id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if restored.start.Nanosecond() != 123456789 || !restored.start.Equal(event.start) || !restored.end.Equal(event.end) {
+		t.Errorf("restored span = %v - %v, want %v - %v", restored.start, restored.end, event.start, event.end)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	c := NewCalendar()
+	var wg sync.WaitGroup
+
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("u%d", w%2)
+			for i := 0; i < 100; i++ {
+				id, err := c.Add(userID, EventData{Date: "2024-01-10", Text: fmt.Sprintf("event %d-%d", w, i)})
+				if err != nil {
+					t.Errorf("Add() error = %v", err)
+					return
+				}
+				if _, err := c.GetEventsByDay(userID, "2024-01-10", ""); err != nil {
+					t.Errorf("GetEventsByDay() error = %v", err)
+				}
+				if _, err := c.GetEventsByWeek(userID, "2024-01-08", ""); err != nil {
+					t.Errorf("GetEventsByWeek() error = %v", err)
+				}
+				if err := c.Update(userID, id, EventData{Text: fmt.Sprintf("updated %d-%d", w, i)}); err != nil {
+					t.Errorf("Update() error = %v", err)
+				}
+				if i%2 == 0 {
+					if err := c.Delete(userID, id); err != nil {
+						t.Errorf("Delete() error = %v", err)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	total := 0
+	for _, userID := range []string{"u0", "u1"} {
+		events, err := c.GetEventsByMonth(userID, "2024-01-01", "")
+		if err != nil {
+			t.Fatalf("GetEventsByMonth() error = %v", err)
+		}
+		total += len(events)
+	}
+	if total != 8*50 {
+		t.Errorf("got %d events after concurrent access, want %d", total, 8*50)
+	}
+}
+
+func TestICalRoundTrip(t *testing.T) {
+	c := NewCalendar()
+	for _, data := range []EventData{
+		{Date: "2024-01-10", Text: "holiday, long; one"},
+		{Start: "2024-01-11T09:00:00+03:00", End: "2024-01-11T10:00:00+03:00", TimeZone: "Europe/Moscow", Text: "meeting"},
+		{Start: "2024-01-01T08:00:00Z", End: "2024-01-01T08:15:00Z", Recurrence: "FREQ=WEEKLY;BYDAY=MO,WE", Exceptions: []string{"2024-01-03"}, Text: "standup"},
+	} {
+		if _, err := c.Add("u1", data); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	feed, err := c.ExportICS("u1", nil)
+	if err != nil {
+		t.Fatalf("ExportICS() error = %v", err)
+	}
+	for _, want := range []string{"BEGIN:VCALENDAR", "DTSTART;VALUE=DATE:20240110", `SUMMARY:holiday\, long\; one`, "DTSTART;TZID=Europe/Moscow:20240111T090000", "RRULE:FREQ=WEEKLY;BYDAY=MO,WE", "EXDATE:20240103T080000Z",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Moscow\r\nBEGIN:STANDARD\r\nDTSTART:20230101T030000\r\nTZOFFSETFROM:+0300\r\nTZOFFSETTO:+0300\r\nTZNAME:MSK\r\nEND:STANDARD\r\nEND:VTIMEZONE"} {
+		if !strings.Contains(string(feed), want) {
+			t.Errorf("ExportICS() has no %q:\n%s", want, feed)
+		}
+	}
+
+	imported := NewCalendar()
+	count, err := imported.ImportICS("u2", "", bytes.NewReader(feed))
+	if err != nil {
+		t.Fatalf("ImportICS() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("ImportICS() imported %d events, want 3", count)
+	}
+
+	want, _ := c.GetEventsByMonth("u1", "2024-01-01", "Europe/Moscow")
+	got, err := imported.GetEventsByMonth("u2", "2024-01-01", "Europe/Moscow")
+	if err != nil {
+		t.Fatalf("GetEventsByMonth() error = %v", err)
+	}
+	if fmt.Sprint(texts(got)) != fmt.Sprint(texts(want)) {
+		t.Errorf("imported events = %v, want %v", texts(got), texts(want))
+	}
+
+	if _, err := imported.ImportICS("u2", "", strings.NewReader("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:x\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n")); err == nil {
+		t.Errorf("ImportICS() accepted event without DTSTART")
+	}
+}
+
+func TestICalTimeZones(t *testing.T) {
+	c := NewCalendar()
+	for _, data := range []EventData{
+		{Start: "2024-06-10T09:00:00+02:00", TimeZone: "Europe/Berlin", Recurrence: "FREQ=WEEKLY", Text: "sync"},
+		{Start: "2024-06-11T09:00:00+02:00", TimeZone: "Europe/Berlin", Text: "review"},
+		{Start: "2024-06-12T09:00:00-04:00", TimeZone: "America/New_York", Text: "call"},
+	} {
+		if _, err := c.Add("u1", data); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	feed, err := c.ExportICS("u1", nil)
+	if err != nil {
+		t.Fatalf("ExportICS() error = %v", err)
+	}
+
+	if count := strings.Count(string(feed), "BEGIN:VTIMEZONE"); count != 2 {
+		t.Errorf("ExportICS() has %d VTIMEZONE components, want one per zone", count)
+	}
+	for _, want := range []string{
+		"BEGIN:DAYLIGHT\r\nDTSTART:20240331T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST\r\nRRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\nEND:DAYLIGHT",
+		"BEGIN:STANDARD\r\nDTSTART:20241027T030000\r\nTZOFFSETFROM:+0200\r\nTZOFFSETTO:+0100\r\nTZNAME:CET\r\nRRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU\r\nEND:STANDARD",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=2SU",
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=1SU",
+	} {
+		if !strings.Contains(string(feed), want) {
+			t.Errorf("ExportICS() has no %q:\n%s", want, feed)
+		}
+	}
+	if strings.Index(string(feed), "END:VTIMEZONE") > strings.Index(string(feed), "BEGIN:VEVENT") {
+		t.Errorf("ExportICS() describes time zones after events")
+	}
+}
+
+func TestICalImportComponents(t *testing.T) {
+	feed := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:standup",
+		"DTSTART;TZID=W. Europe Standard Time:20240110T090000",
+		"SUMMARY:Standup",
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"TRIGGER:-PT15M",
+		"DESCRIPTION:This is an event reminder",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:review",
+		"DTSTART;TZID=W. Europe Standard Time:20240710T090000",
+		"SUMMARY:Review",
+		"END:VEVENT",
+		"BEGIN:VTIMEZONE",
+		"TZID:W. Europe Standard Time",
+		"BEGIN:STANDARD",
+		"DTSTART:16011028T030000",
+		"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0100",
+		"END:STANDARD",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:16010325T020000",
+		"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=3",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"END:DAYLIGHT",
+		"END:VTIMEZONE",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	c := NewCalendar()
+	if _, err := c.ImportICS("u1", "", strings.NewReader(feed)); err != nil {
+		t.Fatalf("ImportICS() error = %v", err)
+	}
+	for _, tt := range []struct {
+		id    string
+		text  string
+		start string
+	}{
+		{"standup", "Standup", "2024-01-10T08:00:00Z"},
+		{"review", "Review", "2024-07-10T07:00:00Z"},
+	} {
+		event, err := c.Get("u1", tt.id)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", tt.id, err)
+		}
+		if event.Text() != tt.text || event.Start().UTC().Format(time.RFC3339) != tt.start {
+			t.Errorf("imported %s = %q at %s, want %q at %s", tt.id, event.Text(), event.Start().UTC().Format(time.RFC3339), tt.text, tt.start)
+		}
+	}
+
+	if _, err := c.ImportICS("u1", "", strings.NewReader("BEGIN:VEVENT\r\nDTSTART:20240110T090000Z\r\nSUMMARY:x\r\nEND:VALARM\r\n")); err == nil {
+		t.Errorf("ImportICS() accepted mismatched END")
+	}
+}
+
+func TestICalFolding(t *testing.T) {
+	c := NewCalendar()
+	text := strings.Repeat("встреча ", 40)
+	if _, err := c.Add("u1", EventData{Date: "2024-01-10", Text: text}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	feed, err := c.ExportICS("u1", nil)
+	if err != nil {
+		t.Fatalf("ExportICS() error = %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(feed), "\r\n"), "\r\n") {
+		if len(line) > icalLineLen || !utf8.ValidString(line) {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+	}
+
+	imported := NewCalendar()
+	if _, err := imported.ImportICS("u1", "", bytes.NewReader(feed)); err != nil {
+		t.Fatalf("ImportICS() error = %v", err)
+	}
+	events, err := imported.GetEventsByDay("u1", "2024-01-10", "")
+	if err != nil || len(events) != 1 || events[0].Text() != text {
+		t.Errorf("imported folded text = %v, error = %v", texts(events), err)
+	}
+}
+
+func TestICalReimport(t *testing.T) {
+	c := NewCalendar()
+	if _, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "holiday"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	feed, err := c.ExportICS("u1", nil)
+	if err != nil {
+		t.Fatalf("ExportICS() error = %v", err)
+	}
+	foreign := "BEGIN:VEVENT\r\nUID:42/meeting@example.com\r\nDTSTART:20240111T090000Z\r\nSUMMARY:%s\r\nEND:VEVENT\r\n"
+
+	tests := []struct {
+		name   string
+		userID string
+		feed   string
+		want   []string
+	}{
+		{"own feed updates", "u1", string(feed), []string{"holiday"}},
+		{"foreign uid", "u1", fmt.Sprintf(foreign, "meeting"), []string{"holiday", "meeting"}},
+		{"foreign uid again", "u1", fmt.Sprintf(foreign, "planning"), []string{"holiday", "planning"}},
+		{"feed of other user", "u2", string(feed), []string{"holiday"}},
+		{"feed of other user again", "u2", string(feed), []string{"holiday"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := c.ImportICS(tt.userID, "", strings.NewReader(tt.feed)); err != nil {
+				t.Fatalf("ImportICS() error = %v", err)
+			}
+			events, err := c.GetEventsByWeek(tt.userID, "2024-01-10", "")
+			if err != nil {
+				t.Fatalf("GetEventsByWeek() error = %v", err)
+			}
+			if fmt.Sprint(texts(events)) != fmt.Sprint(tt.want) {
+				t.Errorf("events = %v, want %v", texts(events), tt.want)
+			}
+		})
+	}
+
+	duplicate := "BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20240112\r\nSUMMARY:trip\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20240110\r\nSUMMARY:holiday\r\nEND:VEVENT\r\n"
+	if _, err := c.ImportICS("u1", "", strings.NewReader(duplicate)); !errors.Is(err, ErrConflict) {
+		t.Errorf("ImportICS() of duplicate error = %v, want ErrConflict", err)
+	}
+	if events, _ := c.GetEventsByWeek("u1", "2024-01-10", ""); len(events) != 2 {
+		t.Errorf("failed import saved events: %v", texts(events))
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	c := NewCalendar()
+	id, err := c.Add("u1", EventData{Start: "2024-01-10T09:00:00Z", End: "2024-01-10T10:00:00Z", TimeZone: "Europe/Moscow", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	events, err := c.GetEventsByWeek("u1", "2024-01-08", "")
+	if err != nil {
+		t.Fatalf("GetEventsByWeek() error = %v", err)
+	}
+
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `[{"id":"` + id + `","user_id":"u1","calendar_id":"u1","date":"2024-01-10","start":"2024-01-10T12:00:00+03:00","end":"2024-01-10T13:00:00+03:00","all_day":false,"time_zone":"Europe/Moscow","text":"meeting"}]`
+	if string(data) != want {
+		t.Errorf("Marshal() = %s, want %s", data, want)
+	}
+}
+
+func TestGetEventsInRange(t *testing.T) {
+	c := NewCalendar()
+	for _, data := range []EventData{
+		{Date: "2024-01-05", Text: "Planning"},
+		{Start: "2024-01-10T09:00:00Z", Text: "Team sync"},
+		{Start: "2024-01-10T10:00:00Z", Text: "team lunch"},
+		{Date: "2024-01-31", Text: "Report"},
+		{Date: "2024-02-02", Text: "Team retro"},
+		{Date: "2024-01-01", Recurrence: "FREQ=WEEKLY;COUNT=3", Text: "weekly"},
+	} {
+		if _, err := c.Add("u1", data); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   EventQuery
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "days range includes end day",
+			query: EventQuery{From: "2024-01-05", To: "2024-01-31"},
+			want:  []string{"Planning", "weekly", "Team sync", "team lunch", "weekly", "Report"},
+		},
+		{
+			name:  "time range excludes end",
+			query: EventQuery{From: "2024-01-10T00:00:00Z", To: "2024-01-10T09:00:00Z"},
+			want:  []string{},
+		},
+		{
+			name:  "search",
+			query: EventQuery{From: "2024-01-01", To: "2024-02-29", Search: "TEAM"},
+			want:  []string{"Team sync", "team lunch", "Team retro"},
+		},
+		{
+			name:  "descending",
+			query: EventQuery{From: "2024-01-01", To: "2024-01-10", Sort: "-start"},
+			want:  []string{"team lunch", "Team sync", "weekly", "Planning", "weekly"},
+		},
+		{
+			name:  "by text",
+			query: EventQuery{From: "2024-01-01", To: "2024-01-10", Sort: "text"},
+			want:  []string{"Planning", "Team sync", "team lunch", "weekly", "weekly"},
+		},
+		{
+			name:    "end before start",
+			query:   EventQuery{From: "2024-01-10", To: "2024-01-01"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown sort",
+			query:   EventQuery{From: "2024-01-01", To: "2024-01-10", Sort: "id"},
+			wantErr: true,
+		},
+		{
+			name:    "broken cursor",
+			query:   EventQuery{From: "2024-01-01", To: "2024-01-10", Cursor: "???"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := c.GetEventsInRange("u1", tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetEventsInRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fmt.Sprint(texts(page.Events)) != fmt.Sprint(tt.want) {
+				t.Errorf("GetEventsInRange() = %v, want %v", texts(page.Events), tt.want)
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		query := EventQuery{From: "2024-01-01", To: "2024-02-29", Limit: 2}
+		got := []string{}
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatalf("pagination does not end")
+			}
+			page, err := c.GetEventsInRange("u1", query)
+			if err != nil {
+				t.Fatalf("GetEventsInRange() error = %v", err)
+			}
+			got = append(got, texts(page.Events)...)
+			if page.NextCursor == "" {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		want := []string{"weekly", "Planning", "weekly", "Team sync", "team lunch", "weekly", "Report", "Team retro"}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("paged events = %v, want %v", got, want)
+		}
+	})
+}
+
+func TestErrorKinds(t *testing.T) {
+	c := NewCalendar()
+	id, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "a"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	_, addErr := c.Add("u1", EventData{Date: "2024-13-40", Text: "a"})
+	_, rangeErr := c.GetEventsInRange("u1", EventQuery{From: "2024-01-10", To: "2024-01-01"})
+	_, dayErr := c.GetEventsByDay("u1", "tomorrow", "")
+	_, importErr := c.ImportICS("u1", "", strings.NewReader("BEGIN:VEVENT\r\nSUMMARY:x\r\nEND:VEVENT\r\n"))
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"invalid date", addErr, ErrValidation},
+		{"invalid update", c.Update("u1", id, EventData{Recurrence: "FREQ=HOURLY"}), ErrValidation},
+		{"invalid range", rangeErr, ErrValidation},
+		{"invalid day", dayErr, ErrValidation},
+		{"invalid import", importErr, ErrValidation},
+		{"update missing", c.Update("u1", "missing", EventData{Text: "b"}), ErrNotFound},
+		{"delete of other user", c.Delete("u2", id), ErrNotFound},
+		{"duplicate id", c.storage.Insert(Event{id: id, userID: "u1"}), ErrConflict},
+	}
+
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, tt.err, tt.want)
+		}
+	}
+
+	var validation *ValidationError
+	if !errors.As(addErr, &validation) || !strings.Contains(addErr.Error(), "Invalid date") {
+		t.Errorf("Add() error = %v, want ValidationError about date", addErr)
+	}
+	if errors.Is(c.Delete("u1", "missing"), ErrValidation) {
+		t.Error("not found error matches ErrValidation")
+	}
+}
+
+func TestReminders(t *testing.T) {
+	storage, err := NewFileStorage(filepath.Join(t.TempDir(), "events.log"))
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	c := NewCalendarWithStorage(storage)
+	defer c.Close()
+
+	meeting, err := c.Add("u1", EventData{Start: "2024-01-10T09:00:00Z", Text: "meeting", Reminders: []string{"1d", "15m", "15m"}})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u2", EventData{Start: "2024-01-10T12:00:00Z", Text: "daily", Recurrence: "FREQ=DAILY;COUNT=3", Reminders: []string{"10m"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u2", EventData{Date: "2024-01-10", TimeZone: "Asia/Tokyo", Text: "holiday", Reminders: []string{"1d"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u3", EventData{Start: "2024-01-10T09:00:00Z", Text: "silent"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	due := func(from string, to string) string {
+		reminders, err := c.DueReminders(at(from), at(to))
+		if err != nil {
+			t.Fatalf("DueReminders() error = %v", err)
+		}
+		fired := []string{}
+		for _, reminder := range reminders {
+			fired = append(fired, fmt.Sprintf("%s@%s", reminder.Event.Text(), reminder.At.UTC().Format("01-02T15:04")))
+		}
+		return strings.Join(fired, " ")
+	}
+
+	tests := []struct {
+		from string
+		to   string
+		want string
+	}{
+		{"2024-01-08T00:00:00Z", "2024-01-14T00:00:00Z", "holiday@01-08T15:00 meeting@01-09T09:00 meeting@01-10T08:45 daily@01-10T11:50 daily@01-11T11:50 daily@01-12T11:50"},
+		{"2024-01-10T08:45:00Z", "2024-01-10T08:46:00Z", "meeting@01-10T08:45"},
+		{"2024-01-10T08:46:00Z", "2024-01-10T11:50:00Z", ""},
+		{"2024-01-11T11:50:00Z", "2024-01-11T11:50:01Z", "daily@01-11T11:50"},
+	}
+	for _, tt := range tests {
+		if got := due(tt.from, tt.to); got != tt.want {
+			t.Errorf("DueReminders(%s, %s) = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	event, err := c.Get("u1", meeting)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	data, err := json.Marshal(event)
+	if err != nil || !strings.Contains(string(data), `"reminders":["15m","1d"]`) {
+		t.Errorf("event JSON = %s, error = %v", data, err)
+	}
+
+	if err := c.Update("u1", meeting, EventData{Reminders: []string{"-5m"}}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Update() with negative reminder error = %v, want validation error", err)
+	}
+	if _, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "a", Reminders: []string{"soon"}}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Add() with invalid reminder error = %v, want validation error", err)
+	}
+	if err := c.Update("u1", meeting, EventData{Reminders: []string{}}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got := due("2024-01-09T00:00:00Z", "2024-01-11T00:00:00Z"); strings.Contains(got, "meeting") {
+		t.Errorf("removed reminders still fire: %s", got)
+	}
+}
+
+func TestChangeListeners(t *testing.T) {
+	c := NewCalendar()
+	changes := []string{}
+	c.OnChange(func(change Change) {
+		changes = append(changes, fmt.Sprintf("%s:%s", change.Type, change.Event.Text()))
+	})
+
+	id, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := c.Update("u1", id, EventData{Text: "standup"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := c.Update("u1", id, EventData{Date: "bad"}); err == nil {
+		t.Fatal("Update() error = nil for invalid date")
+	}
+	if err := c.Delete("u1", id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := c.ImportICS("u1", "", strings.NewReader("BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20240110\r\nSUMMARY:imported\r\nEND:VEVENT\r\n")); err != nil {
+		t.Fatalf("ImportICS() error = %v", err)
+	}
+
+	want := "[created:meeting updated:standup deleted:standup created:imported]"
+	if got := fmt.Sprint(changes); got != want {
+		t.Errorf("changes = %s, want %s", got, want)
+	}
+}
+
+func TestHub(t *testing.T) {
+	c := NewCalendar()
+	first := c.Hub().Subscribe("u1")
+	other := c.Hub().Subscribe("u2")
+	slow := c.Hub().Subscribe("u1")
+
+	id, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if change := <-first.C; change.Type != EventCreated || change.Event.ID() != id {
+		t.Errorf("first change = %s %s", change.Type, change.Event.ID())
+	}
+	first.Close()
+	if _, ok := <-first.C; ok {
+		t.Error("closed subscription still receives changes")
+	}
+	select {
+	case change := <-other.C:
+		t.Errorf("subscriber of u2 received %s of u1", change.Type)
+	default:
+	}
+
+	for i := 0; i < subscriptionBuffer; i++ {
+		if err := c.Update("u1", id, EventData{Text: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("Update() error = %v", err)
+		}
+	}
+	received := 0
+	for range slow.C {
+		received++
+	}
+	if received != subscriptionBuffer {
+		t.Errorf("slow subscriber received %d changes before being dropped, want %d", received, subscriptionBuffer)
+	}
+
+	c.Hub().Close()
+	if _, ok := <-other.C; ok {
+		t.Error("subscription open after hub closed")
+	}
+	if _, ok := <-c.Hub().Subscribe("u1").C; ok {
+		t.Error("subscription to closed hub is open")
+	}
+	other.Close()
+}
+
+func TestCollections(t *testing.T) {
+	c := NewCalendar()
+	team, err := c.CreateCollection("u1", " team ")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	if team.Name != "team" || team.OwnerID != "u1" || team.IsDefault() {
+		t.Errorf("created calendar = %+v", team)
+	}
+	if _, err := c.CreateCollection("u1", " "); !errors.Is(err, ErrValidation) {
+		t.Errorf("CreateCollection() with empty name error = %v, want validation", err)
+	}
+
+	personal, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "dentist"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	standup, err := c.Add("u1", EventData{Calendar: team.ID, Date: "2024-01-10", Text: "standup"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	if _, err := c.Get("u2", standup); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of unshared event error = %v, want not found", err)
+	}
+	if _, err := c.ShareCollection("u2", team.ID, "u3", AccessRead); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ShareCollection() by stranger error = %v, want not found", err)
+	}
+	if _, err := c.ShareCollection("u1", team.ID, "u2", AccessRead); err != nil {
+		t.Fatalf("ShareCollection() error = %v", err)
+	}
+	if _, err := c.ShareCollection("u1", team.ID, "u1", AccessRead); !errors.Is(err, ErrValidation) {
+		t.Errorf("ShareCollection() with owner error = %v, want validation", err)
+	}
+
+	feed := c.Hub().Subscribe("u2")
+	defer feed.Close()
+
+	if event, err := c.Get("u2", standup); err != nil || event.UserID() != "u1" || event.CalendarID() != team.ID {
+		t.Errorf("Get() of shared event = %v, %v", event, err)
+	}
+	if _, err := c.Get("u2", personal); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of event in other calendar error = %v, want not found", err)
+	}
+	if err := c.Update("u2", standup, EventData{Text: "retro"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Update() with read access error = %v, want forbidden", err)
+	}
+	if _, err := c.Add("u2", EventData{Calendar: team.ID, Date: "2024-01-11", Text: "demo"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Add() with read access error = %v, want forbidden", err)
+	}
+
+	if _, err := c.ShareCollection("u1", team.ID, "u2", AccessWrite); err != nil {
+		t.Fatalf("ShareCollection() error = %v", err)
+	}
+	demo, err := c.Add("u2", EventData{Calendar: team.ID, Date: "2024-01-11", Text: "demo"})
+	if err != nil {
+		t.Fatalf("Add() with write access error = %v", err)
+	}
+	if change := <-feed.C; change.Type != EventCreated || change.Event.ID() != demo || change.Event.UserID() != "u1" {
+		t.Errorf("shared user received %s of %s owned by %s", change.Type, change.Event.ID(), change.Event.UserID())
+	}
+	if err := c.Update("u2", demo, EventData{Calendar: "u2"}); err != nil {
+		t.Fatalf("Update() moving event error = %v", err)
+	}
+	if event, err := c.Get("u2", demo); err != nil || event.UserID() != "u2" || event.CalendarID() != "u2" {
+		t.Errorf("moved event = %v, %v", event, err)
+	}
+	if _, err := c.Get("u1", demo); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of event moved out of calendar error = %v, want not found", err)
+	}
+
+	page, err := c.GetEventsInRange("u2", EventQuery{From: "2024-01-01", To: "2024-01-31", Calendars: []string{"u2", team.ID, team.ID}})
+	if err != nil {
+		t.Fatalf("GetEventsInRange() error = %v", err)
+	}
+	if got := fmt.Sprint(texts(page.Events)); got != "[standup demo]" {
+		t.Errorf("merged events = %s, want [standup demo]", got)
+	}
+	if _, err := c.GetEventsInRange("u2", EventQuery{From: "2024-01-01", To: "2024-01-31", Calendars: []string{"u1"}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetEventsInRange() of unshared calendar error = %v, want not found", err)
+	}
+
+	exported, err := c.ExportICS("u2", []string{team.ID})
+	if err != nil || !strings.Contains(string(exported), "SUMMARY:standup") || strings.Contains(string(exported), "SUMMARY:dentist") {
+		t.Errorf("ExportICS() of shared calendar = %s, %v", exported, err)
+	}
+	if _, err := c.ExportICS("u2", []string{"u1"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ExportICS() of unshared calendar error = %v, want not found", err)
+	}
+	retro := "BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20240112\r\nSUMMARY:retro\r\nEND:VEVENT\r\n"
+	if _, err := c.ImportICS("u2", "u1", strings.NewReader(retro)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ImportICS() into unshared calendar error = %v, want not found", err)
+	}
+	if _, err := c.ImportICS("u2", team.ID, strings.NewReader(retro)); err != nil {
+		t.Fatalf("ImportICS() into shared calendar error = %v", err)
+	}
+	if page, _ := c.GetEventsInRange("u1", EventQuery{From: "2024-01-12", To: "2024-01-13", Calendars: []string{team.ID}}); len(page.Events) != 1 || page.Events[0].UserID() != "u1" {
+		t.Errorf("imported events of shared calendar = %v", texts(page.Events))
+	}
+
+	collections, err := c.Collections("u2")
+	if err != nil {
+		t.Fatalf("Collections() error = %v", err)
+	}
+	if len(collections) != 2 || collections[0].ID != "u2" || collections[1].Access("u2") != AccessWrite {
+		t.Errorf("Collections() = %+v", collections)
+	}
+
+	if err := c.DeleteCollection("u2", team.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("DeleteCollection() by shared user error = %v, want forbidden", err)
+	}
+	if err := c.DeleteCollection("u1", "u1"); !errors.Is(err, ErrValidation) {
+		t.Errorf("DeleteCollection() of default calendar error = %v, want validation", err)
+	}
+	if err := c.DeleteCollection("u1", team.ID); err != nil {
+		t.Fatalf("DeleteCollection() error = %v", err)
+	}
+	if _, err := c.Get("u1", standup); !errors.Is(err, ErrNotFound) {
+		t.Errorf("event of deleted calendar error = %v, want not found", err)
+	}
+	if _, err := c.Get("u1", personal); err != nil {
+		t.Errorf("event of default calendar error = %v", err)
+	}
+}
+
+func TestFreeBusy(t *testing.T) {
+	c := NewCalendar()
+	team, err := c.CreateCollection("u1", "team")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	for _, add := range []struct {
+		userID string
+		data   EventData
+	}{
+		{"u1", EventData{Date: "2024-01-10", Text: "offsite"}},
+		{"u1", EventData{Calendar: team.ID, Start: "2024-01-11T10:00:00Z", End: "2024-01-11T11:00:00Z", Text: "standup"}},
+		{"u2", EventData{Start: "2024-01-11T10:30:00Z", End: "2024-01-11T12:00:00Z", Text: "review"}},
+		{"u2", EventData{Start: "2024-01-12T09:00:00Z", End: "2024-01-12T09:15:00Z", Text: "sync"}},
+		{"u3", EventData{Date: "2024-01-13", Text: "not queried"}},
+	} {
+		if _, err := c.Add(add.userID, add.data); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	result, err := c.FreeBusy(FreeBusyQuery{
+		Users:    []string{"u1", "u2", "u1"},
+		From:     "2024-01-10",
+		To:       "2024-01-13",
+		Duration: "1h",
+		DayStart: "09:00",
+		DayEnd:   "17:00",
+	})
+	if err != nil {
+		t.Fatalf("FreeBusy() error = %v", err)
+	}
+
+	if got := strings.Join(result.FreeDays, ","); got != "2024-01-13" {
+		t.Errorf("free days = %s, want 2024-01-13", got)
+	}
+	slots := []string{}
+	for _, slot := range result.Slots {
+		slots = append(slots, slot.Start.Format("02 15:04")+"-"+slot.End.Format("15:04"))
+	}
+	want := "11 09:00-10:00,11 12:00-17:00,12 09:15-17:00,13 09:00-17:00"
+	if got := strings.Join(slots, ","); got != want {
+		t.Errorf("slots = %s, want %s", got, want)
+	}
+
+	if len(result.Users) != 2 {
+		t.Fatalf("busy summaries = %+v, want 2", result.Users)
+	}
+	if u1 := result.Users[0]; u1.UserID != "u1" || u1.Events != 2 || len(u1.Busy) != 2 || u1.BusyMinutes != 1500 {
+		t.Errorf("busy of u1 = %+v", u1)
+	}
+	if u2 := result.Users[1]; u2.UserID != "u2" || u2.Events != 2 || u2.BusyMinutes != 105 {
+		t.Errorf("busy of u2 = %+v", u2)
+	}
+
+	for _, query := range []FreeBusyQuery{
+		{From: "2024-01-10", To: "2024-01-13"},
+		{Users: []string{"u1"}, From: "2024-01-10", To: "2024-01-13", Duration: "soon"},
+		{Users: []string{"u1"}, From: "2024-01-10", To: "2024-01-13", DayStart: "17:00", DayEnd: "09:00"},
+		{Users: []string{"u1"}, From: "2024-01-01", To: "2024-12-31"},
+	} {
+		if _, err := c.FreeBusy(query); !errors.Is(err, ErrValidation) {
+			t.Errorf("FreeBusy(%+v) error = %v, want validation", query, err)
+		}
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	c := NewCalendar()
+	team, err := c.CreateCollection("u1", "team")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	meeting, err := c.Add("u1", EventData{Start: "2024-01-10T10:00:00Z", End: "2024-01-10T11:00:00Z", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	weekly, err := c.Add("u1", EventData{Calendar: team.ID, Start: "2024-01-08T09:00:00Z", End: "2024-01-08T09:30:00Z", Recurrence: "FREQ=WEEKLY", Text: "planning"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	_, err = c.Add("u1", EventData{Start: "2024-01-10T10:00:00Z", End: "2024-01-10T11:00:00Z", Text: "meeting", OnConflict: ConflictAllow})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !conflict.Duplicate || conflict.Events[0].ID() != meeting {
+		t.Errorf("Add() of duplicate error = %v, want duplicate of %s", err, meeting)
+	}
+
+	_, err = c.Add("u1", EventData{Start: "2024-01-15T09:15:00Z", End: "2024-01-15T10:00:00Z", Text: "review", OnConflict: ConflictReject})
+	if !errors.As(err, &conflict) || conflict.Duplicate || len(conflict.Events) != 1 || conflict.Events[0].ID() != weekly {
+		t.Errorf("Add() overlapping occurrence error = %v, want conflict with %s", err, weekly)
+	}
+	if _, err := c.Add("u1", EventData{Start: "2024-01-10T11:00:00Z", End: "2024-01-10T12:00:00Z", Text: "lunch", OnConflict: ConflictReject}); err != nil {
+		t.Errorf("Add() of adjacent event error = %v", err)
+	}
+	if _, err := c.Add("u2", EventData{Start: "2024-01-10T10:00:00Z", End: "2024-01-10T11:00:00Z", Text: "meeting", OnConflict: ConflictReject}); err != nil {
+		t.Errorf("Add() for other user error = %v", err)
+	}
+	if _, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "meeting", OnConflict: "maybe"}); !errors.Is(err, ErrValidation) {
+		t.Errorf("Add() with unknown mode error = %v, want validation", err)
+	}
+
+	flagged, err := c.Add("u1", EventData{Start: "2024-01-10T10:30:00Z", End: "2024-01-10T12:00:00Z", Text: "call"})
+	if err != nil {
+		t.Fatalf("Add() in allow mode error = %v", err)
+	}
+	conflicts, err := c.Conflicts("u1", flagged)
+	if err != nil || len(conflicts) != 2 {
+		t.Errorf("Conflicts() = %v, %v, want meeting and lunch", texts(conflicts), err)
+	}
+
+	if err := c.Update("u1", flagged, EventData{Start: "2024-01-22T09:00:00Z", End: "2024-01-22T10:00:00Z", OnConflict: ConflictReject}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Update() onto occurrence error = %v, want conflict", err)
+	}
+	if err := c.Update("u1", flagged, EventData{Text: "phone call", OnConflict: ConflictReject}); err != nil {
+		t.Errorf("Update() of text only error = %v", err)
+	}
+	if err := c.Update("u1", flagged, EventData{Start: "2024-01-10T10:00:00Z", End: "2024-01-10T11:00:00Z", Text: "meeting"}); !errors.Is(err, ErrConflict) {
+		t.Errorf("Update() into duplicate error = %v, want conflict", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	c := NewCalendar()
+	changes := []string{}
+	c.OnChange(func(change Change) {
+		changes = append(changes, fmt.Sprintf("%s:%s", change.Type, change.Event.Text()))
+	})
+	meeting, err := c.Add("u1", EventData{Date: "2024-01-10", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	_, err = c.Batch("u1", []BatchOperation{
+		{Action: BatchCreate, Data: EventData{Date: "2024-01-11", Text: "lunch"}},
+		{Action: BatchUpdate, ID: meeting, Data: EventData{Text: "standup"}},
+		{Action: BatchDelete, ID: "missing"},
+	}, true)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 2 || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Batch() atomic error = %v, want not found at #2", err)
+	}
+	events, _ := c.GetEventsByWeek("u1", "2024-01-08", "")
+	if got := fmt.Sprint(texts(events)); got != "[meeting]" {
+		t.Errorf("events after failed atomic batch = %s, want [meeting]", got)
+	}
+
+	results, err := c.Batch("u1", []BatchOperation{
+		{Action: BatchCreate, Data: EventData{Date: "2024-01-11", Text: "lunch"}},
+		{Action: BatchCreate, Data: EventData{Date: "2024-01-11", Text: "lunch"}},
+		{Action: BatchUpdate, ID: meeting, Data: EventData{Text: "standup"}},
+		{Action: "rename", ID: meeting},
+		{Action: BatchDelete, ID: meeting},
+	}, false)
+	if err != nil {
+		t.Fatalf("Batch() best-effort error = %v", err)
+	}
+	if results[0].Err != nil || results[0].ID == "" {
+		t.Errorf("result #0 = %+v, want created event", results[0])
+	}
+	if !errors.Is(results[1].Err, ErrConflict) {
+		t.Errorf("result #1 error = %v, want duplicate conflict", results[1].Err)
+	}
+	if !errors.Is(results[3].Err, ErrValidation) {
+		t.Errorf("result #3 error = %v, want validation", results[3].Err)
+	}
+	if results[2].Err != nil || results[4].Err != nil || results[4].ID != meeting {
+		t.Errorf("results #2, #4 = %+v, %+v, want success", results[2], results[4])
+	}
+	events, _ = c.GetEventsByWeek("u1", "2024-01-08", "")
+	if got := fmt.Sprint(texts(events)); got != "[lunch]" {
+		t.Errorf("events after best-effort batch = %s, want [lunch]", got)
+	}
+
+	want := "[created:meeting created:lunch updated:standup deleted:standup]"
+	if got := fmt.Sprint(changes); got != want {
+		t.Errorf("changes = %s, want %s", got, want)
+	}
+
+	if _, err := c.Batch("u1", nil, true); !errors.Is(err, ErrValidation) {
+		t.Errorf("Batch() of nothing error = %v, want validation", err)
+	}
+}
+
+func TestEventDetails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	storage, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	c := NewCalendarWithStorage(storage)
+
+	title, location, category, color := "Planning", "Room 4", "Work", "#1A73E8"
+	id, err := c.Add("u1", EventData{
+		Date:      "2024-01-10",
+		Text:      "quarter goals",
+		Title:     &title,
+		Location:  &location,
+		Category:  &category,
+		Color:     &color,
+		Tags:      []string{" Team ", "q1", "team"},
+		Attendees: []Attendee{{ID: "u2"}, {ID: "ann@example.com", Name: "Ann", Status: RSVPAccepted}},
+	})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u1", EventData{Date: "2024-01-11", Text: "lunch", Tags: []string{"team"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	blue := "blue"
+	invalid := []EventData{
+		{Color: &blue},
+		{Tags: []string{" "}},
+		{Attendees: []Attendee{{ID: "u2"}, {ID: "u2"}}},
+		{Attendees: []Attendee{{ID: "u3", Status: "maybe"}}},
+	}
+	for _, data := range invalid {
+		if err := c.Update("u1", id, data); !errors.Is(err, ErrValidation) {
+			t.Errorf("Update(%+v) error = %v, want validation", data, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query EventQuery
+		want  string
+	}{
+		{"tag", EventQuery{Tags: []string{"TEAM"}}, "[quarter goals lunch]"},
+		{"tags", EventQuery{Tags: []string{"team", "q1"}}, "[quarter goals]"},
+		{"category", EventQuery{Category: "work"}, "[quarter goals]"},
+		{"search location", EventQuery{Search: "room"}, "[quarter goals]"},
+	}
+	for _, tt := range tests {
+		tt.query.From, tt.query.To = "2024-01-01", "2024-01-31"
+		page, err := c.GetEventsInRange("u1", tt.query)
+		if err != nil || fmt.Sprint(texts(page.Events)) != tt.want {
+			t.Errorf("%s: events = %v, %v, want %s", tt.name, texts(page.Events), err, tt.want)
+		}
+	}
+
+	feed, err := c.ExportICS("u1", nil)
+	if err != nil {
+		t.Fatalf("ExportICS() error = %v", err)
+	}
+	for _, want := range []string{"SUMMARY:Planning", "DESCRIPTION:quarter goals", "LOCATION:Room 4", "CATEGORIES:team,q1", `ATTENDEE;PARTSTAT=ACCEPTED;CN="Ann":mailto:ann@example.com`} {
+		if !strings.Contains(string(feed), want) {
+			t.Errorf("ExportICS() has no %q:\n%s", want, feed)
+		}
+	}
+	imported := NewCalendar()
+	if _, err := imported.ImportICS("u1", "", bytes.NewReader(feed)); err != nil {
+		t.Fatalf("ImportICS() error = %v", err)
+	}
+	page, _ := imported.GetEventsInRange("u1", EventQuery{From: "2024-01-10", To: "2024-01-10"})
+	if len(page.Events) != 1 || page.Events[0].Title() != "Planning" || page.Events[0].Text() != "quarter goals" ||
+		len(page.Events[0].Attendees()) != 2 || page.Events[0].Attendees()[1].Status != RSVPAccepted {
+		t.Errorf("imported events = %+v", page.Events)
+	}
+
+	empty := ""
+	if err := c.Update("u1", id, EventData{Location: &empty, Tags: []string{}}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	storage, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	event, err := NewCalendarWithStorage(storage).Get("u1", id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if event.Title() != "Planning" || event.Location() != "" || event.Category() != "Work" ||
+		event.Color() != "#1a73e8" || event.Tags() != nil || len(event.Attendees()) != 2 {
+		t.Errorf("restored event = %+v", event)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	storage, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	c := NewCalendarWithStorage(storage)
+	title := "Team review"
+	for _, data := range []EventData{
+		{Date: "2024-01-10", Text: "planning the quarter with team"},
+		{Date: "2024-01-11", Text: "lunch", Title: &title},
+		{Date: "2024-01-12", Text: "review of team plans"},
+	} {
+		if _, err := c.Add("u1", data); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// events stored before restart are indexed on first search
+	storage, err = NewFileStorage(path)
+	if err != nil {
+		t.Fatalf("NewFileStorage() error = %v", err)
+	}
+	c = NewCalendarWithStorage(storage)
+	work, err := c.CreateCollection("u2", "work")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	if _, err := c.ShareCollection("u2", work.ID, "u1", AccessRead); err != nil {
+		t.Fatalf("ShareCollection() error = %v", err)
+	}
+	if _, err := c.Add("u2", EventData{Calendar: work.ID, Start: "2024-01-01T09:00:00Z", Recurrence: "FREQ=WEEKLY", Text: "weekly team sync"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u2", EventData{Date: "2024-01-10", Text: "private team offsite"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	search := func(query SearchQuery) string {
+		t.Helper()
+		results, err := c.Search("u1", query)
+		if err != nil {
+			t.Fatalf("Search(%q) error = %v", query.Query, err)
+		}
+		found := []string{}
+		for _, result := range results {
+			found = append(found, result.Event.Text()+"@"+result.Event.Start().Format(dateLayout))
+		}
+		return fmt.Sprint(found)
+	}
+
+	tests := []struct {
+		name  string
+		query SearchQuery
+		want  string
+	}{
+		{"title ranks first", SearchQuery{Query: "REVIEW"}, "[lunch@2024-01-11 review of team plans@2024-01-12]"},
+		{"prefix", SearchQuery{Query: "plan"}, "[planning the quarter with team@2024-01-10 review of team plans@2024-01-12]"},
+		{"all words", SearchQuery{Query: "team quart"}, "[planning the quarter with team@2024-01-10]"},
+		{"phrase", SearchQuery{Query: `"team plans"`}, "[review of team plans@2024-01-12]"},
+		{"phrase in order", SearchQuery{Query: `"plans team"`}, "[]"},
+		{"shared calendar", SearchQuery{Query: "sync"}, "[weekly team sync@2024-01-01]"},
+		{"occurrence in range", SearchQuery{Query: "sync", From: "2024-01-20", To: "2024-01-31"}, "[weekly team sync@2024-01-22]"},
+		{"range", SearchQuery{Query: "team", From: "2024-01-10", To: "2024-01-10", Calendars: []string{"u1"}}, "[planning the quarter with team@2024-01-10]"},
+		{"limit", SearchQuery{Query: "team", Limit: 1}, "[lunch@2024-01-11]"},
+	}
+	for _, tt := range tests {
+		if got := search(tt.query); got != tt.want {
+			t.Errorf("%s: Search() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	page, _ := c.GetEventsInRange("u1", EventQuery{From: "2024-01-01", To: "2024-01-31", Search: "quarter"})
+	id := page.Events[0].ID()
+	if err := c.Update("u1", id, EventData{Text: "budget"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got := search(SearchQuery{Query: "quarter"}); got != "[]" {
+		t.Errorf("Search() after update = %s, want none", got)
+	}
+	if got := search(SearchQuery{Query: "budget"}); got != "[budget@2024-01-10]" {
+		t.Errorf("Search() of new text = %s", got)
+	}
+	if err := c.Delete("u1", id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := search(SearchQuery{Query: "budget"}); got != "[]" {
+		t.Errorf("Search() after delete = %s, want none", got)
+	}
+
+	for _, query := range []SearchQuery{{Query: `" "`}, {Query: "team", From: "2024-01-01"}, {Query: "team", Limit: 1000}} {
+		if _, err := c.Search("u1", query); !errors.Is(err, ErrValidation) {
+			t.Errorf("Search(%+v) error = %v, want validation", query, err)
+		}
+	}
+	if _, err := c.Search("u1", SearchQuery{Query: "team", Calendars: []string{"u2"}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Search() of unshared calendar error = %v, want not found", err)
+	}
+}
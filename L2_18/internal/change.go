@@ -0,0 +1,53 @@
+package calendar
+
+import "time"
+
+// ChangeType is kind of change of event
+type ChangeType string
+
+// Kinds of event changes
+const (
+	EventCreated ChangeType = "created"
+	EventUpdated ChangeType = "updated"
+	EventDeleted ChangeType = "deleted"
+)
+
+// Change describes successful change of event
+type Change struct {
+	Type ChangeType
+	// Event is state after change, or last state for deleted event
+	Event Event
+	Time  time.Time
+	// Audience are users who can read calendar of event or calendar it was moved from
+	Audience []string
+}
+
+// OnChange registers listener called after every successful change of events in order of changes.
+// Listener is called with calendar locked, so it must not block or use calendar
+func (c *Calendar) OnChange(listener func(Change)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listeners = append(c.listeners, listener)
+}
+
+// changed updates search index, notifies listeners about change and publishes it to users
+// who can read calendar of event or any of other calendars, calendar must be locked for writing
+func (c *Calendar) changed(changeType ChangeType, event Event, calendarIDs ...string) {
+	change := Change{
+		Type:     changeType,
+		Event:    event,
+		Time:     time.Now(),
+		Audience: c.audience(append(calendarIDs, event.calendarID)...),
+	}
+	c.index.update(change)
+	for _, listener := range c.listeners {
+		listener(change)
+	}
+	c.hub.Publish(change, change.Audience...)
+}
+
+// Hub returns hub publishing changes of calendar events
+func (c *Calendar) Hub() *Hub {
+	return c.hub
+}
@@ -0,0 +1,330 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxCollectionName is max length of calendar name in characters
+const maxCollectionName = 100
+
+// defaultCollectionName is name of default calendar of user
+const defaultCollectionName = "Default"
+
+// Access is level of access of user to calendar
+type Access string
+
+// Access levels, each one includes previous ones
+const (
+	AccessNone  Access = ""
+	AccessRead  Access = "read"
+	AccessWrite Access = "write"
+	AccessOwner Access = "owner"
+)
+
+// rank orders access levels
+func (a Access) rank() int {
+	switch a {
+	case AccessRead:
+		return 1
+	case AccessWrite:
+		return 2
+	case AccessOwner:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// Allows reports whether access includes required level
+func (a Access) Allows(required Access) bool {
+	return a.rank() >= required.rank()
+}
+
+// Collection is named calendar of events owned by user, e.g. work or personal, which may be shared
+// with other users. Every user has default calendar with ID equal to user ID, it holds events
+// added without calendar and cant be deleted
+type Collection struct {
+	ID      string `json:"id"`
+	OwnerID string `json:"owner_id"`
+	Name    string `json:"name"`
+	// Shares are access levels of other users by their IDs
+	Shares map[string]Access `json:"shares"`
+}
+
+// defaultCollection returns default calendar of user
+func defaultCollection(userID string) Collection {
+	return Collection{
+		ID:      userID,
+		OwnerID: userID,
+		Name:    defaultCollectionName,
+		Shares:  map[string]Access{},
+	}
+}
+
+// IsDefault reports whether calendar is default calendar of its owner
+func (c Collection) IsDefault() bool {
+	return c.ID == c.OwnerID
+}
+
+// Access returns access level of user to calendar
+func (c Collection) Access(userID string) Access {
+	if userID == c.OwnerID {
+		return AccessOwner
+	}
+	return c.Shares[userID]
+}
+
+// members returns IDs of owner and all users calendar is shared with
+func (c Collection) members() []string {
+	members := make([]string, 0, len(c.Shares)+1)
+	members = append(members, c.OwnerID)
+	for userID := range c.Shares {
+		members = append(members, userID)
+	}
+	return members
+}
+
+// clone returns copy of calendar not sharing its shares
+func (c Collection) clone() Collection {
+	shares := make(map[string]Access, len(c.Shares))
+	for userID, access := range c.Shares {
+		shares[userID] = access
+	}
+	c.Shares = shares
+	return c
+}
+
+func validCollectionName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", invalidf("Calendar name cant be empty")
+	}
+	if utf8.RuneCountInString(name) > maxCollectionName {
+		return "", invalidf("Calendar name cant be longer than %d characters", maxCollectionName)
+	}
+	return name, nil
+}
+
+// collection returns calendar by ID, unknown IDs are default calendars of users with the same IDs.
+// Calendar must be locked
+func (c *Calendar) collection(id string) (Collection, error) {
+	collection, err := c.storage.GetCollection(id)
+	if err == nil {
+		return collection, nil
+	}
+	if !errors.Is(err, ErrCalendarNotFound) {
+		return Collection{}, err
+	}
+	return defaultCollection(id), nil
+}
+
+// accessible returns calendar by ID if user has required access to it.
+// Calendars user cant read are reported as missing. Calendar must be locked
+func (c *Calendar) accessible(userID string, id string, required Access) (Collection, error) {
+	if id == "" {
+		id = userID
+	}
+	collection, err := c.collection(id)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	access := collection.Access(userID)
+	if !access.Allows(AccessRead) {
+		return Collection{}, ErrCalendarNotFound
+	}
+	if !access.Allows(required) {
+		return Collection{}, fmt.Errorf("%w: %s access to calendar is required", ErrForbidden, required)
+	}
+	return collection, nil
+}
+
+// audience returns users who can read any of calendars. Calendar must be locked
+func (c *Calendar) audience(ids ...string) []string {
+	seen := map[string]struct{}{}
+	users := []string{}
+	for _, id := range ids {
+		collection, err := c.collection(id)
+		if err != nil {
+			continue
+		}
+		for _, userID := range collection.members() {
+			if _, ok := seen[userID]; !ok {
+				seen[userID] = struct{}{}
+				users = append(users, userID)
+			}
+		}
+	}
+	return users
+}
+
+// Collections returns calendars user owns or which are shared with user, default calendar first
+func (c *Calendar) Collections(userID string) ([]Collection, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.collections(userID)
+}
+
+// collections returns calendars user can read, default calendar first. Calendar must be locked
+func (c *Calendar) collections(userID string) ([]Collection, error) {
+	stored, err := c.storage.Collections()
+	if err != nil {
+		return nil, err
+	}
+
+	found := []Collection{}
+	hasDefault := false
+	for _, collection := range stored {
+		if collection.Access(userID) == AccessNone {
+			continue
+		}
+		if collection.ID == userID {
+			hasDefault = true
+		}
+		found = append(found, collection)
+	}
+	if !hasDefault {
+		found = append(found, defaultCollection(userID))
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if (found[i].ID == userID) != (found[j].ID == userID) {
+			return found[i].ID == userID
+		}
+		if found[i].Name != found[j].Name {
+			return found[i].Name < found[j].Name
+		}
+		return found[i].ID < found[j].ID
+	})
+	return found, nil
+}
+
+// Collection returns calendar by ID if user can read it
+func (c *Calendar) Collection(userID string, id string) (Collection, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.accessible(userID, id, AccessRead)
+}
+
+// CreateCollection creates new calendar owned by user
+func (c *Calendar) CreateCollection(userID string, name string) (Collection, error) {
+	if userID == "" {
+		return Collection{}, invalidf("UserID cant be empty")
+	}
+	name, err := validCollectionName(name)
+	if err != nil {
+		return Collection{}, err
+	}
+	id, err := newEventID()
+	if err != nil {
+		return Collection{}, err
+	}
+
+	collection := Collection{
+		ID:      id,
+		OwnerID: userID,
+		Name:    name,
+		Shares:  map[string]Access{},
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.storage.SaveCollection(collection); err != nil {
+		return Collection{}, fmt.Errorf("Error saving calendar: %w", err)
+	}
+	return collection, nil
+}
+
+// changeCollection applies change to calendar owned by user and saves it
+func (c *Calendar) changeCollection(userID string, id string, change func(*Collection) error) (Collection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	collection, err := c.accessible(userID, id, AccessOwner)
+	if err != nil {
+		return Collection{}, err
+	}
+	if err := change(&collection); err != nil {
+		return Collection{}, err
+	}
+	if err := c.storage.SaveCollection(collection); err != nil {
+		return Collection{}, fmt.Errorf("Error saving calendar: %w", err)
+	}
+	return collection, nil
+}
+
+// RenameCollection changes name of calendar owned by user
+func (c *Calendar) RenameCollection(userID string, id string, name string) (Collection, error) {
+	name, err := validCollectionName(name)
+	if err != nil {
+		return Collection{}, err
+	}
+	return c.changeCollection(userID, id, func(collection *Collection) error {
+		collection.Name = name
+		return nil
+	})
+}
+
+// ShareCollection grants other user read or write access to calendar owned by user
+func (c *Calendar) ShareCollection(userID string, id string, withUserID string, access Access) (Collection, error) {
+	if access != AccessRead && access != AccessWrite {
+		return Collection{}, invalidf("Invalid access %q: expected read or write", access)
+	}
+	if withUserID == "" {
+		return Collection{}, invalidf("User to share calendar with cant be empty")
+	}
+	return c.changeCollection(userID, id, func(collection *Collection) error {
+		if withUserID == collection.OwnerID {
+			return invalidf("Calendar cant be shared with its owner")
+		}
+		collection.Shares[withUserID] = access
+		return nil
+	})
+}
+
+// UnshareCollection revokes access of other user to calendar owned by user
+func (c *Calendar) UnshareCollection(userID string, id string, withUserID string) (Collection, error) {
+	return c.changeCollection(userID, id, func(collection *Collection) error {
+		if _, ok := collection.Shares[withUserID]; !ok {
+			return &notFoundError{msg: fmt.Sprintf("Calendar is not shared with user %s", withUserID)}
+		}
+		delete(collection.Shares, withUserID)
+		return nil
+	})
+}
+
+// DeleteCollection deletes calendar owned by user together with its events
+func (c *Calendar) DeleteCollection(userID string, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	collection, err := c.accessible(userID, id, AccessOwner)
+	if err != nil {
+		return fmt.Errorf("Error deleting calendar: %w", err)
+	}
+	if collection.IsDefault() {
+		return invalidf("Default calendar cant be deleted")
+	}
+
+	events, err := c.storage.Events(collection.ID)
+	if err != nil {
+		return fmt.Errorf("Error deleting calendar: %w", err)
+	}
+	for _, event := range events {
+		if err := c.storage.Delete(event.id); err != nil {
+			return fmt.Errorf("Error deleting calendar: %w", err)
+		}
+		c.changed(EventDeleted, event)
+	}
+	if err := c.storage.DeleteCollection(collection.ID); err != nil {
+		return fmt.Errorf("Error deleting calendar: %w", err)
+	}
+	return nil
+}
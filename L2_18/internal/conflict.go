@@ -0,0 +1,174 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// conflictHorizon bounds how far occurrences of recurring event are checked for conflicts
+const conflictHorizon = 366 * 24 * time.Hour
+
+// ConflictMode tells how event overlapping other events of the same owner is handled.
+// Exact duplicates of stored events are rejected in every mode
+type ConflictMode string
+
+// Conflict modes, empty mode is ConflictAllow
+const (
+	// ConflictAllow stores overlapping event, Calendar.Conflicts lists what it overlaps
+	ConflictAllow ConflictMode = "allow"
+	// ConflictReject rejects overlapping event with ConflictError
+	ConflictReject ConflictMode = "reject"
+)
+
+func (m ConflictMode) validate() error {
+	switch m {
+	case "", ConflictAllow, ConflictReject:
+		return nil
+	default:
+		return invalidf("Invalid conflict mode %q: expected allow or reject", string(m))
+	}
+}
+
+// ConflictError describes events change clashes with, it matches ErrConflict
+type ConflictError struct {
+	// Events are occurrences of overlapping events or single event duplicated by change
+	Events []Event
+	// Duplicate reports whether change repeats stored event exactly
+	Duplicate bool
+}
+
+func (e *ConflictError) Error() string {
+	if e.Duplicate {
+		return fmt.Sprintf("Event duplicates event %s", e.Events[0].id)
+	}
+	return fmt.Sprintf("Event overlaps %d other events", len(e.Events))
+}
+
+// Is makes every ConflictError match ErrConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// intersects reports whether intervals share time. Empty interval intersects interval it starts in
+func (i Interval) intersects(other Interval) bool {
+	if i.Start.Equal(other.Start) {
+		return true
+	}
+	return i.Start.Before(other.End) && other.Start.Before(i.End)
+}
+
+// interval returns time span of event as seen from given location
+func (e Event) interval(location *time.Location) Interval {
+	start, end := e.span(location)
+	return Interval{Start: start, End: end}
+}
+
+// duplicates reports whether events are the same apart from their IDs
+func (e Event) duplicates(other Event) bool {
+	if e.calendarID != other.calendarID || e.text != other.text || e.title != other.title || e.allDay != other.allDay ||
+		!e.start.Equal(other.start) || !e.end.Equal(other.end) {
+		return false
+	}
+	if (e.recurrence == nil) != (other.recurrence == nil) {
+		return false
+	}
+	if e.recurrence != nil && e.recurrence.String() != other.recurrence.String() {
+		return false
+	}
+	return slices.Equal(e.exceptions, other.exceptions)
+}
+
+// findConflicts returns stored event duplicated by event, if any, and occurrences of other events
+// overlapping event in calendars of its owner user can read. Occurrences of recurring event are
+// checked within conflictHorizon from its start. Calendar must be locked
+func (c *Calendar) findConflicts(userID string, event Event) (*Event, []Event, error) {
+	owned, err := c.ownedCalendars(event.userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	calendarIDs := make([]string, 0, len(owned))
+	for _, id := range owned {
+		if _, err := c.accessible(userID, id, AccessRead); err != nil {
+			if errors.Is(err, ErrCalendarNotFound) {
+				continue
+			}
+			return nil, nil, err
+		}
+		calendarIDs = append(calendarIDs, id)
+	}
+
+	location := event.start.Location()
+	occurrences := []Event{event}
+	if event.recurrence != nil {
+		occurrences = event.occurrences(event.start, event.start.Add(conflictHorizon))
+	}
+	from, to := event.span(location)
+	for _, occurrence := range occurrences {
+		if _, end := occurrence.span(location); end.After(to) {
+			to = end
+		}
+	}
+	if !to.After(from) {
+		to = from.Add(time.Nanosecond)
+	}
+
+	stored, err := c.storedBetween(calendarIDs, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var duplicate *Event
+	for _, other := range stored {
+		if other.id != event.id && other.duplicates(event) {
+			duplicate = &other
+			break
+		}
+	}
+
+	conflicts := []Event{}
+	for _, other := range expandEvents(stored, from, to) {
+		if other.id == event.id {
+			continue
+		}
+		span := other.interval(location)
+		for _, occurrence := range occurrences {
+			if occurrence.interval(location).intersects(span) {
+				conflicts = append(conflicts, other)
+				break
+			}
+		}
+	}
+	return duplicate, conflicts, nil
+}
+
+// checkConflicts rejects event duplicating stored one and, in reject mode, event overlapping others.
+// Calendar must be locked
+func (c *Calendar) checkConflicts(userID string, event Event, mode ConflictMode) error {
+	duplicate, conflicts, err := c.findConflicts(userID, event)
+	if err != nil {
+		return err
+	}
+	if duplicate != nil {
+		return &ConflictError{Events: []Event{*duplicate}, Duplicate: true}
+	}
+	if mode == ConflictReject && len(conflicts) != 0 {
+		return &ConflictError{Events: conflicts}
+	}
+	return nil
+}
+
+// Conflicts returns occurrences of other events overlapping event in calendars of its owner
+// user can read
+func (c *Calendar) Conflicts(userID string, id string) ([]Event, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	event, err := c.findEvent(userID, id, AccessRead)
+	if err != nil {
+		return nil, err
+	}
+	_, conflicts, err := c.findConflicts(userID, event)
+	return conflicts, err
+}
@@ -0,0 +1,209 @@
+package calendar
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of descriptive fields of event
+const (
+	maxTitle     = 200
+	maxLocation  = 500
+	maxCategory  = 50
+	maxTag       = 50
+	maxTags      = 20
+	maxAttendees = 100
+	maxAttendee  = 320
+)
+
+// RSVPStatus is answer of attendee to invitation
+type RSVPStatus string
+
+// RSVP statuses, empty status is RSVPNeedsAction
+const (
+	RSVPNeedsAction RSVPStatus = "needs_action"
+	RSVPAccepted    RSVPStatus = "accepted"
+	RSVPDeclined    RSVPStatus = "declined"
+	RSVPTentative   RSVPStatus = "tentative"
+)
+
+func (s RSVPStatus) validate() error {
+	switch s {
+	case RSVPNeedsAction, RSVPAccepted, RSVPDeclined, RSVPTentative:
+		return nil
+	default:
+		return fmt.Errorf("Invalid RSVP status %q: expected needs_action, accepted, declined or tentative", string(s))
+	}
+}
+
+// Attendee is participant of event
+type Attendee struct {
+	// ID is user ID or email of attendee
+	ID string `json:"id"`
+	// Name is display name of attendee
+	Name   string     `json:"name,omitempty"`
+	Status RSVPStatus `json:"status"`
+}
+
+// maxLength checks value has at most limit characters
+func maxLength(field string, value string, limit int) error {
+	if utf8.RuneCountInString(value) > limit {
+		return fmt.Errorf("%s cant be longer than %d characters", field, limit)
+	}
+	return nil
+}
+
+// validColor checks color is hex RGB like #1a2b3c
+func validColor(color string) error {
+	valid := len(color) == 7 && color[0] == '#'
+	for i := 1; valid && i < len(color); i++ {
+		valid = strings.ContainsRune("0123456789abcdefABCDEF", rune(color[i]))
+	}
+	if !valid {
+		return fmt.Errorf("Invalid color %q: expected hex RGB like #1a2b3c", color)
+	}
+	return nil
+}
+
+// normalizeTag trims tag and lowers its case, so tags match regardless of spelling
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// setDetails fills title, location, category, color, tags and attendees of event from data.
+// Nil fields keep current values, empty ones remove them
+func (e *Event) setDetails(data EventData) error {
+	if data.Title != nil {
+		title := strings.TrimSpace(*data.Title)
+		if err := maxLength("Title", title, maxTitle); err != nil {
+			return err
+		}
+		e.title = title
+	}
+
+	if data.Location != nil {
+		location := strings.TrimSpace(*data.Location)
+		if err := maxLength("Location", location, maxLocation); err != nil {
+			return err
+		}
+		e.location = location
+	}
+
+	if data.Category != nil {
+		category := strings.TrimSpace(*data.Category)
+		if err := maxLength("Category", category, maxCategory); err != nil {
+			return err
+		}
+		e.category = category
+	}
+
+	if data.Color != nil {
+		if *data.Color != "" {
+			if err := validColor(*data.Color); err != nil {
+				return err
+			}
+		}
+		e.color = strings.ToLower(*data.Color)
+	}
+
+	if data.Tags != nil {
+		tags := make([]string, 0, len(data.Tags))
+		for _, value := range data.Tags {
+			tag := normalizeTag(value)
+			if tag == "" {
+				return fmt.Errorf("Tag cant be empty")
+			}
+			if err := maxLength("Tag", tag, maxTag); err != nil {
+				return err
+			}
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) > maxTags {
+			return fmt.Errorf("Event cant have more than %d tags", maxTags)
+		}
+		if len(tags) == 0 {
+			tags = nil
+		}
+		e.tags = tags
+	}
+
+	if data.Attendees != nil {
+		if len(data.Attendees) > maxAttendees {
+			return fmt.Errorf("Event cant have more than %d attendees", maxAttendees)
+		}
+		attendees := make([]Attendee, 0, len(data.Attendees))
+		seen := map[string]bool{}
+		for _, attendee := range data.Attendees {
+			attendee.ID = strings.TrimSpace(attendee.ID)
+			if attendee.ID == "" {
+				return fmt.Errorf("Attendee ID cant be empty")
+			}
+			if err := maxLength("Attendee ID", attendee.ID, maxAttendee); err != nil {
+				return err
+			}
+			if err := maxLength("Attendee name", attendee.Name, maxTitle); err != nil {
+				return err
+			}
+			if attendee.Status == "" {
+				attendee.Status = RSVPNeedsAction
+			}
+			if err := attendee.Status.validate(); err != nil {
+				return err
+			}
+			if seen[attendee.ID] {
+				return fmt.Errorf("Attendee %s is listed twice", attendee.ID)
+			}
+			seen[attendee.ID] = true
+			attendees = append(attendees, attendee)
+		}
+		if len(attendees) == 0 {
+			attendees = nil
+		}
+		e.attendees = attendees
+	}
+	return nil
+}
+
+// hasTags reports whether event has all given tags, compared regardless of case
+func (e Event) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(e.tags, normalizeTag(tag)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Title returns short title of event, empty if text alone describes event
+func (e Event) Title() string {
+	return e.title
+}
+
+// Location returns place of event
+func (e Event) Location() string {
+	return e.location
+}
+
+// Category returns category of event
+func (e Event) Category() string {
+	return e.category
+}
+
+// Color returns hex RGB color of event like #1a2b3c
+func (e Event) Color() string {
+	return e.color
+}
+
+// Tags returns lowercase tags of event
+func (e Event) Tags() []string {
+	return slices.Clone(e.tags)
+}
+
+// Attendees returns participants of event with their RSVP statuses
+func (e Event) Attendees() []Attendee {
+	return slices.Clone(e.attendees)
+}
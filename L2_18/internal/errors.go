@@ -0,0 +1,66 @@
+package calendar
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of calendar errors, returned errors match them with errors.Is
+var (
+	// ErrValidation is matched by errors caused by invalid input
+	ErrValidation = errors.New("Invalid input")
+	// ErrNotFound is returned when event does not exist or belongs to other user
+	ErrNotFound = errors.New("Event not found")
+	// ErrConflict is returned when change clashes with stored events
+	ErrConflict = errors.New("Event conflict")
+	// ErrForbidden is returned when user may read calendar but lacks access required for operation
+	ErrForbidden = errors.New("Access denied")
+	// ErrCalendarNotFound is returned when calendar does not exist or is not shared with user,
+	// it matches ErrNotFound
+	ErrCalendarNotFound error = &notFoundError{msg: "Calendar not found"}
+)
+
+// notFoundError describes missing object other than event
+type notFoundError struct {
+	msg string
+}
+
+func (e *notFoundError) Error() string {
+	return e.msg
+}
+
+// Is makes every notFoundError match ErrNotFound
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ValidationError describes invalid input of calendar operation
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes every ValidationError match ErrValidation
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// invalid marks error as caused by invalid input
+func invalid(err error) error {
+	if err == nil || errors.Is(err, ErrValidation) {
+		return err
+	}
+	return &ValidationError{Err: err}
+}
+
+// invalidf formats validation error
+func invalidf(format string, args ...any) error {
+	return &ValidationError{Err: fmt.Errorf(format, args...)}
+}
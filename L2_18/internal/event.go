@@ -0,0 +1,435 @@
+package calendar
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+const dateLayout = "2006-01-02"
+
+// maxEventText is max length of event text in characters
+const maxEventText = 10000
+
+// Event represents a calendar event with owner, calendar, time span and description.
+// All-day events cover whole days from start up to end exclusively
+type Event struct {
+	id         string
+	userID     string
+	calendarID string
+	start      time.Time
+	end        time.Time
+	allDay     bool
+	timeZone   string
+	text       string
+
+	title     string
+	location  string
+	category  string
+	color     string
+	tags      []string
+	attendees []Attendee
+
+	recurrence *recurrence
+	exceptions []string
+	reminders  []time.Duration
+}
+
+// EventData describes event fields provided by user.
+// Either Date for all-day event or Start in RFC 3339 must be set
+type EventData struct {
+	// Calendar is ID of calendar of event, default calendar of user if empty.
+	// On update event is moved to given calendar
+	Calendar string
+	// Date is day of all-day event in 2006-01-02 format
+	Date string
+	// Start is start of event in RFC 3339 format
+	Start string
+	// End is end of event in RFC 3339 format, equals Start if empty
+	End string
+	// AllDay marks event as covering whole days from Start to End,
+	// which are widened to midnights. Nil keeps it on update
+	AllDay *bool
+	// TimeZone is IANA name of event time zone, UTC if empty
+	TimeZone string
+	// Text is description of event
+	Text string
+	// Title is optional short title shown instead of text. Like other pointer and slice fields
+	// below, nil keeps it on update and empty value removes it
+	Title *string
+	// Location is place of event
+	Location *string
+	// Category is single free-form category of event
+	Category *string
+	// Color is hex RGB color of event like #1a2b3c
+	Color *string
+	// Tags are free-form labels, stored trimmed in lower case
+	Tags []string
+	// Attendees are participants of event, empty status means needs_action
+	Attendees []Attendee
+	// Recurrence is RRULE-style rule of recurring event, e.g. FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10.
+	// NoRecurrence removes rule on update
+	Recurrence string
+	// Exceptions are days in 2006-01-02 format when recurring event is skipped
+	Exceptions []string
+	// Reminders are offsets before start like "15m" or "1d" when reminders fire.
+	// Nil keeps reminders on update, empty removes them
+	Reminders []string
+	// OnConflict tells how overlap with other events of owner is handled, it is not stored
+	OnConflict ConflictMode
+}
+
+func newEventID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Failed to generate event ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func loadLocation(timeZone string) (*time.Location, error) {
+	if timeZone == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid time zone: %v", err)
+	}
+	return location, nil
+}
+
+// parseDay parses day in 2006-01-02 format as midnight in given time zone
+func parseDay(day string, timeZone string) (time.Time, error) {
+	location, err := loadLocation(timeZone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	date, err := time.ParseInLocation(dateLayout, day, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid date: %v", err)
+	}
+	return date, nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// setTime fills time span of event from data
+func (e *Event) setTime(data EventData) error {
+	location, err := loadLocation(data.TimeZone)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case data.Start != "":
+		start, err := time.Parse(time.RFC3339, data.Start)
+		if err != nil {
+			return fmt.Errorf("Invalid start: %v", err)
+		}
+		end := start
+		if data.End != "" {
+			end, err = time.Parse(time.RFC3339, data.End)
+			if err != nil {
+				return fmt.Errorf("Invalid end: %v", err)
+			}
+		}
+		if end.Before(start) {
+			return fmt.Errorf("End cant be before start")
+		}
+
+		e.start = start.In(location)
+		e.end = end.In(location)
+		e.allDay = data.AllDay != nil && *data.AllDay
+		if e.allDay {
+			e.start = startOfDay(e.start)
+			if day := startOfDay(e.end); day.Equal(e.end) && day.After(e.start) {
+				e.end = day
+			} else {
+				e.end = day.AddDate(0, 0, 1)
+			}
+		}
+	case data.Date != "":
+		date, err := time.ParseInLocation(dateLayout, data.Date, location)
+		if err != nil {
+			return fmt.Errorf("Invalid date: %v", err)
+		}
+		e.start = date
+		e.end = date.AddDate(0, 0, 1)
+		e.allDay = true
+	default:
+		return fmt.Errorf("Date or start is required")
+	}
+
+	e.timeZone = location.String()
+	return nil
+}
+
+// changesTime reports whether data changes time of event
+func (d EventData) changesTime() bool {
+	return d.Date != "" || d.Start != "" || d.End != "" || d.TimeZone != "" || d.AllDay != nil
+}
+
+// empty reports whether data has nothing to change in event
+func (d EventData) empty() bool {
+	return !d.changesTime() && d.Calendar == "" && d.Text == "" &&
+		d.Title == nil && d.Location == nil && d.Category == nil && d.Color == nil &&
+		d.Tags == nil && d.Attendees == nil &&
+		d.Recurrence == "" && d.Exceptions == nil && d.Reminders == nil
+}
+
+// Replacing returns data replacing all fields of event on update, missing fields are removed
+func (d EventData) Replacing() EventData {
+	if d.AllDay == nil {
+		d.AllDay = new(bool)
+	}
+	if d.Recurrence == "" {
+		d.Recurrence = NoRecurrence
+	} else if d.Exceptions == nil {
+		d.Exceptions = []string{}
+	}
+	if d.Reminders == nil {
+		d.Reminders = []string{}
+	}
+	for _, field := range []**string{&d.Title, &d.Location, &d.Category, &d.Color} {
+		if *field == nil {
+			*field = new(string)
+		}
+	}
+	if d.Tags == nil {
+		d.Tags = []string{}
+	}
+	if d.Attendees == nil {
+		d.Attendees = []Attendee{}
+	}
+	return d
+}
+
+// changeTime applies time fields of data to event. Missing start and end are taken from event,
+// all-day events keep their days when time zone changes
+func (e *Event) changeTime(data EventData) error {
+	if data.TimeZone == "" {
+		data.TimeZone = e.timeZone
+	}
+	if data.AllDay == nil {
+		allDay := e.allDay
+		data.AllDay = &allDay
+	}
+
+	if data.Date == "" && data.Start == "" {
+		location, err := loadLocation(data.TimeZone)
+		if err != nil {
+			return err
+		}
+		start, end := e.start, e.end
+		if e.allDay {
+			start, end = sameDay(start, location), sameDay(end, location)
+		}
+		data.Start = start.Format(time.RFC3339)
+		if data.End == "" {
+			data.End = end.Format(time.RFC3339)
+		}
+	}
+	return e.setTime(data)
+}
+
+// sameDay returns midnight of day of t in location
+func sameDay(t time.Time, location *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+}
+
+// setRecurrence fills recurrence rule and exceptions of event from data.
+// Empty rule and nil exceptions keep current values
+func (e *Event) setRecurrence(data EventData) error {
+	switch data.Recurrence {
+	case "":
+	case NoRecurrence:
+		e.recurrence = nil
+		e.exceptions = nil
+	default:
+		rule, err := parseRecurrence(data.Recurrence)
+		if err != nil {
+			return err
+		}
+		e.recurrence = rule
+	}
+
+	if data.Exceptions != nil {
+		exceptions := make([]string, 0, len(data.Exceptions))
+		for _, day := range data.Exceptions {
+			if _, err := time.Parse(dateLayout, day); err != nil {
+				return fmt.Errorf("Invalid exception date: %v", err)
+			}
+			exceptions = append(exceptions, day)
+		}
+		e.exceptions = exceptions
+	}
+
+	if e.recurrence == nil && len(e.exceptions) != 0 {
+		return fmt.Errorf("Exceptions are supported only for recurring events")
+	}
+	return nil
+}
+
+func validText(text string) error {
+	if utf8.RuneCountInString(text) > maxEventText {
+		return invalidf("Event text cant be longer than %d characters", maxEventText)
+	}
+	return nil
+}
+
+func newEvent(userID string, data EventData) (*Event, error) {
+	if userID == "" {
+		return nil, invalidf("UserID cant be empty")
+	}
+
+	if data.Text == "" {
+		return nil, invalidf("Event text cant be empty")
+	}
+	if err := validText(data.Text); err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		userID:     userID,
+		calendarID: userID,
+		text:       data.Text,
+	}
+	if err := event.setTime(data); err != nil {
+		return nil, invalid(err)
+	}
+	if err := event.setRecurrence(data); err != nil {
+		return nil, invalid(err)
+	}
+	if err := event.setReminders(data); err != nil {
+		return nil, invalid(err)
+	}
+	if err := event.setDetails(data); err != nil {
+		return nil, invalid(err)
+	}
+
+	id, err := newEventID()
+	if err != nil {
+		return nil, err
+	}
+	event.id = id
+
+	return event, nil
+}
+
+// span returns time span of event as seen from given location.
+// All-day events are floating, so their days are moved into the location as is
+func (e Event) span(location *time.Location) (time.Time, time.Time) {
+	if !e.allDay {
+		return e.start, e.end
+	}
+	year, month, day := e.start.Date()
+	start := time.Date(year, month, day, 0, 0, 0, 0, location)
+	days := int(e.end.Sub(e.start).Hours()+12) / 24
+	return start, start.AddDate(0, 0, days)
+}
+
+// overlaps reports whether event happens within [from, to)
+func (e Event) overlaps(from time.Time, to time.Time) bool {
+	start, end := e.span(from.Location())
+	if !start.Before(to) {
+		return false
+	}
+	return !start.Before(from) || end.After(from)
+}
+
+// ID returns unique identifier of event
+func (e Event) ID() string {
+	return e.id
+}
+
+// UserID returns owner of event, who is owner of its calendar
+func (e Event) UserID() string {
+	return e.userID
+}
+
+// CalendarID returns ID of calendar event belongs to
+func (e Event) CalendarID() string {
+	return e.calendarID
+}
+
+// Start returns start of event in its time zone
+func (e Event) Start() time.Time {
+	return e.start
+}
+
+// End returns end of event in its time zone
+func (e Event) End() time.Time {
+	return e.end
+}
+
+// AllDay reports whether event covers whole days
+func (e Event) AllDay() bool {
+	return e.allDay
+}
+
+// TimeZone returns IANA name of event time zone
+func (e Event) TimeZone() string {
+	return e.timeZone
+}
+
+// Text returns description of event
+func (e Event) Text() string {
+	return e.text
+}
+
+// eventJSON is representation of event in API responses
+type eventJSON struct {
+	ID         string   `json:"id"`
+	UserID     string   `json:"user_id"`
+	CalendarID string   `json:"calendar_id"`
+	Date       string   `json:"date"`
+	Start      string   `json:"start"`
+	End        string   `json:"end"`
+	AllDay     bool     `json:"all_day"`
+	TimeZone   string   `json:"time_zone"`
+	Text       string   `json:"text"`
+	Recurrence string   `json:"recurrence,omitempty"`
+	Exceptions []string `json:"exceptions,omitempty"`
+	Reminders  []string `json:"reminders,omitempty"`
+
+	Title     string     `json:"title,omitempty"`
+	Location  string     `json:"location,omitempty"`
+	Category  string     `json:"category,omitempty"`
+	Color     string     `json:"color,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Attendees []Attendee `json:"attendees,omitempty"`
+}
+
+// MarshalJSON encodes event with its times in event time zone.
+// For occurrences of recurring event start and end belong to occurrence
+func (e Event) MarshalJSON() ([]byte, error) {
+	view := eventJSON{
+		ID:         e.id,
+		UserID:     e.userID,
+		CalendarID: e.calendarID,
+		Date:       e.start.Format(dateLayout),
+		Start:      e.start.Format(time.RFC3339),
+		End:        e.end.Format(time.RFC3339),
+		AllDay:     e.allDay,
+		TimeZone:   e.timeZone,
+		Text:       e.text,
+		Exceptions: e.exceptions,
+		Reminders:  e.reminderStrings(),
+		Title:      e.title,
+		Location:   e.location,
+		Category:   e.category,
+		Color:      e.color,
+		Tags:       e.tags,
+		Attendees:  e.attendees,
+	}
+	if e.recurrence != nil {
+		view.Recurrence = e.recurrence.String()
+	}
+	return json.Marshal(view)
+}
@@ -0,0 +1,516 @@
+package calendar
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	opInsert = "insert"
+	opUpdate = "update"
+	opDelete = "delete"
+
+	opSaveCalendar   = "save_calendar"
+	opDeleteCalendar = "delete_calendar"
+
+	// opBatch groups records of transaction, they are applied all or none
+	opBatch = "batch"
+)
+
+const (
+	// compactMinRecords is number of records log may hold before it is ever compacted
+	compactMinRecords = 1000
+	// compactRatio is how many times number of records may exceed number of stored objects
+	compactRatio = 4
+)
+
+// eventRecord is representation of event in storage file.
+// Date is set only by records written before events got time spans,
+// CalendarID is empty in records written before calendars were added
+type eventRecord struct {
+	ID         string `json:"id"`
+	UserID     string `json:"user_id"`
+	CalendarID string `json:"calendar_id,omitempty"`
+	Date       string `json:"date,omitempty"`
+	Start      string `json:"start,omitempty"`
+	End        string `json:"end,omitempty"`
+	AllDay     bool   `json:"all_day,omitempty"`
+	TimeZone   string `json:"time_zone,omitempty"`
+	Text       string `json:"text"`
+
+	Recurrence string   `json:"recurrence,omitempty"`
+	Exceptions []string `json:"exceptions,omitempty"`
+	Reminders  []string `json:"reminders,omitempty"`
+
+	Title     string     `json:"title,omitempty"`
+	Location  string     `json:"location,omitempty"`
+	Category  string     `json:"category,omitempty"`
+	Color     string     `json:"color,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Attendees []Attendee `json:"attendees,omitempty"`
+}
+
+// logRecord is single operation in storage file
+type logRecord struct {
+	Op       string       `json:"op"`
+	ID       string       `json:"id,omitempty"`
+	Event    *eventRecord `json:"event,omitempty"`
+	Calendar *Collection  `json:"calendar,omitempty"`
+	// Records are operations of batch record
+	Records []logRecord `json:"records,omitempty"`
+}
+
+func toRecord(event Event) *eventRecord {
+	record := &eventRecord{
+		ID:         event.id,
+		UserID:     event.userID,
+		CalendarID: event.calendarID,
+		Start:      event.start.Format(time.RFC3339Nano),
+		End:        event.end.Format(time.RFC3339Nano),
+		AllDay:     event.allDay,
+		TimeZone:   event.timeZone,
+		Text:       event.text,
+		Exceptions: event.exceptions,
+		Reminders:  event.reminderStrings(),
+		Title:      event.title,
+		Location:   event.location,
+		Category:   event.category,
+		Color:      event.color,
+		Tags:       event.tags,
+		Attendees:  event.attendees,
+	}
+	if event.recurrence != nil {
+		record.Recurrence = event.recurrence.String()
+	}
+	return record
+}
+
+func fromRecord(record eventRecord) (Event, error) {
+	event := Event{
+		id:         record.ID,
+		userID:     record.UserID,
+		calendarID: record.CalendarID,
+		text:       record.Text,
+		title:      record.Title,
+		location:   record.Location,
+		category:   record.Category,
+		color:      record.Color,
+		tags:       record.Tags,
+		attendees:  record.Attendees,
+	}
+	if event.calendarID == "" {
+		event.calendarID = record.UserID
+	}
+	data := EventData{
+		Date:       record.Date,
+		Start:      record.Start,
+		End:        record.End,
+		AllDay:     &record.AllDay,
+		TimeZone:   record.TimeZone,
+		Recurrence: record.Recurrence,
+		Exceptions: record.Exceptions,
+		Reminders:  record.Reminders,
+	}
+	if err := event.setTime(data); err != nil {
+		return Event{}, fmt.Errorf("Invalid time in record: %w", err)
+	}
+	if err := event.setRecurrence(data); err != nil {
+		return Event{}, fmt.Errorf("Invalid recurrence in record: %w", err)
+	}
+	if err := event.setReminders(data); err != nil {
+		return Event{}, fmt.Errorf("Invalid reminders in record: %w", err)
+	}
+	return event, nil
+}
+
+// FileStorage keeps events in memory and writes every change to append-only log file,
+// so events survive restarts of the service. Log is compacted to snapshot of stored calendars
+// and events once it holds compactRatio times more records than them, so it stays
+// proportional to stored data rather than to number of changes
+type FileStorage struct {
+	mu     sync.Mutex
+	memory *MemoryStorage
+	path   string
+	file   *os.File
+	// records is number of operations in log file, operations of batch are counted one by one
+	records int
+	// pending are records of open transaction, nil if there is none
+	pending []logRecord
+}
+
+// NewFileStorage opens log file by path, creating it if needed, and restores events from it
+func NewFileStorage(path string) (*FileStorage, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open storage file %s: %w", path, err)
+	}
+
+	s := &FileStorage{
+		memory: NewMemoryStorage(),
+		path:   path,
+		file:   file,
+	}
+
+	if err := s.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed to restore events from %s: %w", path, err)
+	}
+	if s.shouldCompact() {
+		if err := s.compact(); err != nil {
+			s.file.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// replay applies all records of log file to memory and positions file for appending.
+// Incomplete last record left after crash is cut off
+func (s *FileStorage) replay() error {
+	reader := bufio.NewReader(s.file)
+	var offset int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) != 0 {
+				if err := s.file.Truncate(offset); err != nil {
+					return fmt.Errorf("Failed to cut incomplete record: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read record: %w", err)
+		}
+
+		var record logRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("Broken record at offset %d: %w", offset, err)
+		}
+		if err := s.apply(record); err != nil {
+			return fmt.Errorf("Failed to apply record at offset %d: %w", offset, err)
+		}
+		s.records += record.size()
+		offset += int64(len(line))
+	}
+
+	if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("Failed to seek storage file: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStorage) apply(record logRecord) error {
+	switch record.Op {
+	case opInsert, opUpdate:
+		if record.Event == nil {
+			return fmt.Errorf("Missing event in %s record", record.Op)
+		}
+		event, err := fromRecord(*record.Event)
+		if err != nil {
+			return err
+		}
+		if record.Op == opInsert {
+			return s.memory.Insert(event)
+		}
+		return s.memory.Update(event)
+	case opDelete:
+		return s.memory.Delete(record.ID)
+	case opSaveCalendar:
+		if record.Calendar == nil {
+			return fmt.Errorf("Missing calendar in %s record", record.Op)
+		}
+		if record.Calendar.Shares == nil {
+			record.Calendar.Shares = map[string]Access{}
+		}
+		return s.memory.SaveCollection(*record.Calendar)
+	case opDeleteCalendar:
+		return s.memory.DeleteCollection(record.ID)
+	case opBatch:
+		for _, nested := range record.Records {
+			if nested.Op == opBatch {
+				return fmt.Errorf("Nested %s record", opBatch)
+			}
+			if err := s.apply(nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unknown operation %q", record.Op)
+	}
+}
+
+// size returns number of operations in record
+func (r logRecord) size() int {
+	if r.Op == opBatch {
+		return len(r.Records)
+	}
+	return 1
+}
+
+// write appends record to log file and flushes it to disk, records of open transaction
+// are kept until it is committed. Log is compacted before record if it grew too large,
+// memory then holds exactly what log does
+func (s *FileStorage) write(record logRecord) error {
+	if s.pending != nil {
+		s.pending = append(s.pending, record)
+		return nil
+	}
+	if s.shouldCompact() {
+		// Log stays valid if compaction fails, it is tried again with next change
+		s.compact()
+	}
+	return s.append(record)
+}
+
+// shouldCompact reports whether log holds much more records than stored objects
+func (s *FileStorage) shouldCompact() bool {
+	stats, err := s.memory.Stats()
+	if err != nil {
+		return false
+	}
+	return s.records >= compactMinRecords && s.records > compactRatio*(stats.Events+stats.Calendars)
+}
+
+// compact replaces log file with snapshot of stored calendars and events. Snapshot is written
+// to temporary file renamed over log, so crash leaves either old or new log
+func (s *FileStorage) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("Failed to compact storage file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	records := 0
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	collections, err := s.memory.Collections()
+	if err == nil {
+		for _, collection := range collections {
+			if err = encoder.Encode(logRecord{Op: opSaveCalendar, Calendar: &collection}); err != nil {
+				break
+			}
+			records++
+		}
+	}
+	if err == nil {
+		for _, event := range s.memory.all() {
+			if err = encoder.Encode(logRecord{Op: opInsert, Event: toRecord(event)}); err != nil {
+				break
+			}
+			records++
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to compact storage file: %w", err)
+	}
+
+	// Temporary file is log now and its offset is at the end, ready for appending
+	s.file.Close()
+	s.file = tmp
+	s.records = records
+	// Rename is durable only once directory entry is flushed
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return fmt.Errorf("Failed to sync storage directory: %w", err)
+	}
+	return nil
+}
+
+// syncDir flushes directory entries to disk
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// append writes record at the end of log file and flushes it to disk.
+// Partly written record is cut off, so log stays readable
+func (s *FileStorage) append(record logRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Failed to encode record: %w", err)
+	}
+	data = append(data, '\n')
+
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("Failed to seek storage file: %w", err)
+	}
+	if _, err := s.file.Write(data); err != nil {
+		s.file.Truncate(offset)
+		s.file.Seek(offset, io.SeekStart)
+		return fmt.Errorf("Failed to write record: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("Failed to sync storage file: %w", err)
+	}
+	s.records += record.size()
+	return nil
+}
+
+// Begin opens transaction, changes are applied at once but written to log only by Commit
+func (s *FileStorage) Begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = []logRecord{}
+}
+
+// Commit writes changes of transaction to log as single record, so after crash either all
+// of them are restored or none. Transaction stays open if writing fails
+func (s *FileStorage) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) != 0 {
+		if err := s.append(logRecord{Op: opBatch, Records: s.pending}); err != nil {
+			return err
+		}
+	}
+	s.pending = nil
+	return nil
+}
+
+// Discard closes transaction dropping its changes from log, caller reverts them in memory
+func (s *FileStorage) Discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = nil
+}
+
+// Insert saves new event
+func (s *FileStorage) Insert(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.memory.Get(event.id); err == nil {
+		return fmt.Errorf("%w: ID %s already exists", ErrConflict, event.id)
+	}
+	if err := s.write(logRecord{Op: opInsert, Event: toRecord(event)}); err != nil {
+		return err
+	}
+	return s.memory.Insert(event)
+}
+
+// Update replaces stored event with the same ID
+func (s *FileStorage) Update(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.memory.Get(event.id); err != nil {
+		return err
+	}
+	if err := s.write(logRecord{Op: opUpdate, Event: toRecord(event)}); err != nil {
+		return err
+	}
+	return s.memory.Update(event)
+}
+
+// Delete removes event by ID
+func (s *FileStorage) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.memory.Get(id); err != nil {
+		return err
+	}
+	if err := s.write(logRecord{Op: opDelete, ID: id}); err != nil {
+		return err
+	}
+	return s.memory.Delete(id)
+}
+
+// Get returns event by ID
+func (s *FileStorage) Get(id string) (Event, error) {
+	return s.memory.Get(id)
+}
+
+// Events returns all events of calendar
+func (s *FileStorage) Events(calendarID string) ([]Event, error) {
+	return s.memory.Events(calendarID)
+}
+
+// Range returns events of calendar that may happen within [from, to)
+func (s *FileStorage) Range(calendarID string, from time.Time, to time.Time) ([]Event, error) {
+	return s.memory.Range(calendarID, from, to)
+}
+
+// Reminded returns events of all users having reminders
+func (s *FileStorage) Reminded() ([]Event, error) {
+	return s.memory.Reminded()
+}
+
+// SaveCollection saves new calendar or replaces stored one with the same ID
+func (s *FileStorage) SaveCollection(collection Collection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(logRecord{Op: opSaveCalendar, Calendar: &collection}); err != nil {
+		return err
+	}
+	return s.memory.SaveCollection(collection)
+}
+
+// DeleteCollection removes calendar by ID
+func (s *FileStorage) DeleteCollection(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.memory.GetCollection(id); err != nil {
+		return err
+	}
+	if err := s.write(logRecord{Op: opDeleteCalendar, ID: id}); err != nil {
+		return err
+	}
+	return s.memory.DeleteCollection(id)
+}
+
+// GetCollection returns calendar by ID
+func (s *FileStorage) GetCollection(id string) (Collection, error) {
+	return s.memory.GetCollection(id)
+}
+
+// Collections returns all saved calendars
+func (s *FileStorage) Collections() ([]Collection, error) {
+	return s.memory.Collections()
+}
+
+// Stats returns numbers of stored objects
+func (s *FileStorage) Stats() (StorageStats, error) {
+	return s.memory.Stats()
+}
+
+// Close closes log file
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
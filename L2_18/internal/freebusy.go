@@ -0,0 +1,303 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// maxFreeBusyUsers bounds number of users in free/busy query
+	maxFreeBusyUsers = 50
+	// maxFreeBusyDays bounds length of free/busy query range
+	maxFreeBusyDays = 92
+	// defaultSlotDuration is length of free window when query does not set it
+	defaultSlotDuration = 30 * time.Minute
+)
+
+const clockLayout = "15:04"
+
+// FreeBusyQuery describes search of time when all users are free.
+// Only busy times are revealed, so users need not share calendars with requester
+type FreeBusyQuery struct {
+	// Users are IDs of users who must all be free, events of all calendars they own are considered
+	Users []string
+	// From is start of range as day in 2006-01-02 format or RFC 3339 time
+	From string
+	// To is end of range, the day itself is included when given as day, RFC 3339 time is excluded
+	To string
+	// TimeZone is IANA name of zone days of range belong to, UTC if empty
+	TimeZone string
+	// Duration is min length of free window like "30m" or "1h30m", 30 minutes by default
+	Duration string
+	// DayStart and DayEnd limit free windows to working hours of every day in 15:04 format,
+	// windows span whole days if both are empty
+	DayStart string
+	DayEnd   string
+}
+
+// Interval is span of time from Start up to End exclusively
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// overlaps reports whether interval happens within [from, to), empty intervals included
+func (i Interval) overlaps(from time.Time, to time.Time) bool {
+	if !i.Start.Before(to) {
+		return false
+	}
+	return !i.Start.Before(from) || i.End.After(from)
+}
+
+// UserBusy is busy summary of single user within query range
+type UserBusy struct {
+	UserID string `json:"user_id"`
+	// Busy are merged intervals user has events in, clipped to range
+	Busy []Interval `json:"busy"`
+	// Events is number of events and occurrences of recurring events within range
+	Events int `json:"events"`
+	// BusyMinutes is total length of busy intervals
+	BusyMinutes int `json:"busy_minutes"`
+}
+
+// FreeBusy is result of free/busy query
+type FreeBusy struct {
+	// FreeDays are days in 2006-01-02 format none of users has events on
+	FreeDays []string `json:"free_days"`
+	// Slots are windows of at least query duration when all users are free
+	Slots []Interval `json:"slots"`
+	// Users are busy summaries in order of query
+	Users []UserBusy `json:"users"`
+}
+
+// workingHours is part of day free windows are searched in
+type workingHours struct {
+	start time.Duration
+	end   time.Duration
+	set   bool
+}
+
+func parseClock(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	clock, err := time.Parse(clockLayout, value)
+	if err != nil {
+		return 0, invalidf("Invalid time of day %q: expected 15:04", value)
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+func parseWorkingHours(start string, end string) (workingHours, error) {
+	hours := workingHours{set: start != "" || end != ""}
+	var err error
+	if hours.start, err = parseClock(start, 0); err != nil {
+		return workingHours{}, err
+	}
+	if hours.end, err = parseClock(end, 24*time.Hour); err != nil {
+		return workingHours{}, err
+	}
+	if hours.end <= hours.start {
+		return workingHours{}, invalidf("Day end must be after day start")
+	}
+	return hours, nil
+}
+
+// windows returns parts of [from, to) within working hours of days in location.
+// Hours are wall clock times, so they stay in place on days of DST changes
+func (h workingHours) windows(from time.Time, to time.Time, location *time.Location) []Interval {
+	if !h.set {
+		return []Interval{{Start: from, End: to}}
+	}
+
+	windows := []Interval{}
+	for day := startOfDay(from.In(location)); day.Before(to); day = day.AddDate(0, 0, 1) {
+		year, month, date := day.Date()
+		start := time.Date(year, month, date, 0, int(h.start/time.Minute), 0, 0, location)
+		end := time.Date(year, month, date, 0, int(h.end/time.Minute), 0, 0, location)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if start.Before(end) {
+			windows = append(windows, Interval{Start: start, End: end})
+		}
+	}
+	return windows
+}
+
+// mergeIntervals sorts intervals and joins overlapping or touching ones
+func mergeIntervals(intervals []Interval) []Interval {
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].Start.Before(intervals[j].Start)
+	})
+
+	merged := []Interval{}
+	for _, interval := range intervals {
+		if n := len(merged); n > 0 && !interval.Start.After(merged[n-1].End) {
+			if interval.End.After(merged[n-1].End) {
+				merged[n-1].End = interval.End
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// ownedCalendars returns IDs of calendars owned by user, default calendar first.
+// Calendar must be locked
+func (c *Calendar) ownedCalendars(userID string) ([]string, error) {
+	stored, err := c.storage.Collections()
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []string{userID}
+	for _, collection := range stored {
+		if collection.OwnerID == userID && !collection.IsDefault() {
+			owned = append(owned, collection.ID)
+		}
+	}
+	return owned, nil
+}
+
+// busy returns busy summary of user within [from, to)
+func (c *Calendar) busy(userID string, from time.Time, to time.Time, location *time.Location) (UserBusy, error) {
+	c.mu.RLock()
+	calendarIDs, err := c.ownedCalendars(userID)
+	c.mu.RUnlock()
+	if err != nil {
+		return UserBusy{}, err
+	}
+	events, err := c.eventsBetween(calendarIDs, from, to)
+	if err != nil {
+		return UserBusy{}, err
+	}
+
+	intervals := make([]Interval, 0, len(events))
+	for _, event := range events {
+		start, end := event.span(location)
+		start, end = start.In(location), end.In(location)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.Before(start) {
+			end = start
+		}
+		intervals = append(intervals, Interval{Start: start, End: end})
+	}
+
+	summary := UserBusy{
+		UserID: userID,
+		Busy:   mergeIntervals(intervals),
+		Events: len(events),
+	}
+	for _, interval := range summary.Busy {
+		summary.BusyMinutes += int(interval.End.Sub(interval.Start) / time.Minute)
+	}
+	return summary, nil
+}
+
+// FreeBusy returns busy times of users within query range together with days and windows
+// when all of them are free
+func (c *Calendar) FreeBusy(query FreeBusyQuery) (FreeBusy, error) {
+	if len(query.Users) == 0 {
+		return FreeBusy{}, invalidf("At least one user is required")
+	}
+	if len(query.Users) > maxFreeBusyUsers {
+		return FreeBusy{}, invalidf("At most %d users are allowed", maxFreeBusyUsers)
+	}
+
+	from, to, location, err := parseRange(query.From, query.To, query.TimeZone)
+	if err != nil {
+		return FreeBusy{}, err
+	}
+	if to.Sub(from) > maxFreeBusyDays*24*time.Hour {
+		return FreeBusy{}, invalidf("Range cant be longer than %d days", maxFreeBusyDays)
+	}
+
+	duration := defaultSlotDuration
+	if query.Duration != "" {
+		duration, err = time.ParseDuration(query.Duration)
+		if err != nil || duration <= 0 {
+			return FreeBusy{}, invalidf("Invalid duration %q: expected positive length like 30m or 1h30m", query.Duration)
+		}
+	}
+
+	hours, err := parseWorkingHours(query.DayStart, query.DayEnd)
+	if err != nil {
+		return FreeBusy{}, err
+	}
+
+	result := FreeBusy{
+		FreeDays: []string{},
+		Slots:    []Interval{},
+		Users:    make([]UserBusy, 0, len(query.Users)),
+	}
+	seen := map[string]bool{}
+	all := []Interval{}
+	for _, userID := range query.Users {
+		if userID == "" {
+			return FreeBusy{}, invalidf("UserID cant be empty")
+		}
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		summary, err := c.busy(userID, from, to, location)
+		if err != nil {
+			return FreeBusy{}, fmt.Errorf("Error getting busy times of user %s: %w", userID, err)
+		}
+		result.Users = append(result.Users, summary)
+		all = append(all, summary.Busy...)
+	}
+	busy := mergeIntervals(all)
+
+	for day := startOfDay(from.In(location)); day.Before(to); day = day.AddDate(0, 0, 1) {
+		start, end := day, day.AddDate(0, 0, 1)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		free := true
+		for _, interval := range busy {
+			if interval.overlaps(start, end) {
+				free = false
+				break
+			}
+		}
+		if free {
+			result.FreeDays = append(result.FreeDays, day.Format(dateLayout))
+		}
+	}
+
+	for _, window := range hours.windows(from, to, location) {
+		cursor := window.Start
+		for _, interval := range busy {
+			if !interval.End.After(cursor) {
+				continue
+			}
+			if !interval.Start.Before(window.End) {
+				break
+			}
+			if interval.Start.Sub(cursor) >= duration {
+				result.Slots = append(result.Slots, Interval{Start: cursor, End: interval.Start})
+			}
+			cursor = interval.End
+		}
+		if window.End.Sub(cursor) >= duration {
+			result.Slots = append(result.Slots, Interval{Start: cursor, End: window.End})
+		}
+	}
+	return result, nil
+}
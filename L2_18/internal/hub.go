@@ -0,0 +1,105 @@
+package calendar
+
+import "sync"
+
+// subscriptionBuffer is number of changes queued for subscriber before it is dropped as too slow
+const subscriptionBuffer = 64
+
+// Hub fans out changes of events to subscribers of users who can see them, safe for concurrent use
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[*Subscription]struct{}
+	closed      bool
+}
+
+// Subscription receives changes of events of single user
+type Subscription struct {
+	// C delivers changes in order, it is closed when subscription ends
+	C <-chan Change
+
+	changes chan Change
+	userID  string
+	hub     *Hub
+}
+
+// NewHub creates hub without subscribers
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: map[string]map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe starts receiving changes of user events.
+// Subscription is closed if subscriber falls behind, so it should resubscribe and reload events
+func (h *Hub) Subscribe(userID string) *Subscription {
+	changes := make(chan Change, subscriptionBuffer)
+	s := &Subscription{
+		C:       changes,
+		changes: changes,
+		userID:  userID,
+		hub:     h,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		close(changes)
+		return s
+	}
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = map[*Subscription]struct{}{}
+	}
+	h.subscribers[userID][s] = struct{}{}
+	return s
+}
+
+// Publish sends change to subscribers of given users without blocking
+func (h *Hub) Publish(change Change, userIDs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, userID := range userIDs {
+		for s := range h.subscribers[userID] {
+			select {
+			case s.changes <- change:
+			default:
+				h.remove(s)
+			}
+		}
+	}
+}
+
+// Close ends all subscriptions, later ones are closed at once
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subscribers := range h.subscribers {
+		for s := range subscribers {
+			h.remove(s)
+		}
+	}
+}
+
+// remove closes subscription, hub must be locked
+func (h *Hub) remove(s *Subscription) {
+	subscribers, ok := h.subscribers[s.userID]
+	if _, subscribed := subscribers[s]; !ok || !subscribed {
+		return
+	}
+	delete(subscribers, s)
+	if len(subscribers) == 0 {
+		delete(h.subscribers, s.userID)
+	}
+	close(s.changes)
+}
+
+// Close stops receiving changes
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
@@ -0,0 +1,501 @@
+package calendar
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	icalDate     = "20060102"
+	icalDateTime = "20060102T150405"
+	icalUTC      = "20060102T150405Z"
+	icalLineLen  = 75
+)
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+var icalUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// icalWriter writes content lines folded to 75 octets as RFC 5545 requires
+type icalWriter struct {
+	buf bytes.Buffer
+}
+
+// line writes content line. Continuation lines start with space taking one of their octets
+func (w *icalWriter) line(name string, value string) {
+	line := name + ":" + value
+	limit := icalLineLen
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		w.buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = icalLineLen - 1
+	}
+	w.buf.WriteString(line + "\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// icalTime formats time property name and value for event time
+func icalTime(name string, t time.Time, allDay bool, timeZone string) (string, string) {
+	switch {
+	case allDay:
+		return name + ";VALUE=DATE", t.Format(icalDate)
+	case timeZone == "" || timeZone == "UTC":
+		return name, t.UTC().Format(icalUTC)
+	default:
+		return name + ";TZID=" + timeZone, t.Format(icalDateTime)
+	}
+}
+
+// icalAttendee formats ATTENDEE property name and value, emails become mailto URIs
+func icalAttendee(attendee Attendee) (string, string) {
+	name := "ATTENDEE;PARTSTAT=" + strings.ToUpper(strings.ReplaceAll(string(attendee.Status), "_", "-"))
+	if attendee.Name != "" {
+		name += `;CN="` + strings.ReplaceAll(attendee.Name, `"`, "'") + `"`
+	}
+	value := attendee.ID
+	if strings.Contains(value, "@") {
+		value = "mailto:" + value
+	}
+	return name, value
+}
+
+// ExportICS returns all events of calendars user can read as RFC 5545 iCalendar feed.
+// No calendars mean default calendar of user. Time zones of events are described by VTIMEZONE
+// components, so clients need not know IANA names
+func (c *Calendar) ExportICS(userID string, calendarIDs []string) ([]byte, error) {
+	calendarIDs, err := c.readable(userID, calendarIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	c.mu.RLock()
+	for _, calendarID := range calendarIDs {
+		calendarEvents, err := c.storage.Events(calendarID)
+		if err != nil {
+			c.mu.RUnlock()
+			return nil, err
+		}
+		events = append(events, calendarEvents...)
+	}
+	c.mu.RUnlock()
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].start.Before(events[j].start)
+	})
+
+	stamp := time.Now().UTC().Format(icalUTC)
+	w := &icalWriter{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//venexene//calendar//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	writeVTimezones(w, events)
+
+	for _, event := range events {
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", event.id)
+		w.line("DTSTAMP", stamp)
+		w.line(icalTime("DTSTART", event.start, event.allDay, event.timeZone))
+		w.line(icalTime("DTEND", event.end, event.allDay, event.timeZone))
+		if event.title != "" {
+			w.line("SUMMARY", icalEscaper.Replace(event.title))
+			w.line("DESCRIPTION", icalEscaper.Replace(event.text))
+		} else {
+			w.line("SUMMARY", icalEscaper.Replace(event.text))
+		}
+		if event.location != "" {
+			w.line("LOCATION", icalEscaper.Replace(event.location))
+		}
+		if len(event.tags) != 0 {
+			tags := make([]string, 0, len(event.tags))
+			for _, tag := range event.tags {
+				tags = append(tags, icalEscaper.Replace(tag))
+			}
+			w.line("CATEGORIES", strings.Join(tags, ","))
+		}
+		for _, attendee := range event.attendees {
+			w.line(icalAttendee(attendee))
+		}
+		if event.recurrence != nil {
+			w.line("RRULE", event.recurrence.String())
+			for _, day := range event.exceptions {
+				date, err := time.Parse(dateLayout, day)
+				if err != nil {
+					continue
+				}
+				hour, minute, second := event.start.Clock()
+				exception := time.Date(date.Year(), date.Month(), date.Day(), hour, minute, second, 0, event.start.Location())
+				w.line(icalTime("EXDATE", exception, event.allDay, event.timeZone))
+			}
+		}
+		w.line("END", "VEVENT")
+	}
+
+	w.line("END", "VCALENDAR")
+	return w.buf.Bytes(), nil
+}
+
+// icalProperty is single parsed content line
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// readICalLines unfolds content lines of iCalendar stream
+func readICalLines(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, invalid(fmt.Errorf("Failed to read iCalendar data: %w", err))
+	}
+	return lines, nil
+}
+
+func parseICalProperty(line string) (icalProperty, error) {
+	head, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return icalProperty{}, fmt.Errorf("Invalid iCalendar line %q", line)
+	}
+	parts := strings.Split(head, ";")
+	property := icalProperty{
+		name:   strings.ToUpper(parts[0]),
+		params: map[string]string{},
+		value:  value,
+	}
+	for _, param := range parts[1:] {
+		key, paramValue, _ := strings.Cut(param, "=")
+		property.params[strings.ToUpper(key)] = strings.Trim(paramValue, `"`)
+	}
+	return property, nil
+}
+
+// parseICalTime parses DATE or DATE-TIME value of property. TZID is IANA name or zone
+// defined by VTIMEZONE of feed, times in the latter get fixed offset of observance in effect
+func parseICalTime(property icalProperty, zones map[string]icalZone) (time.Time, bool, error) {
+	if property.params["VALUE"] == "DATE" || len(property.value) == len(icalDate) {
+		date, err := time.Parse(icalDate, property.value)
+		return date, true, err
+	}
+	if strings.HasSuffix(property.value, "Z") {
+		t, err := time.Parse(icalUTC, property.value)
+		return t, false, err
+	}
+	location, err := loadLocation(property.params["TZID"])
+	if err != nil {
+		zone, ok := zones[property.params["TZID"]]
+		if !ok {
+			return time.Time{}, false, err
+		}
+		wall, err := time.Parse(icalDateTime, property.value)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		location = zone.location(wall)
+	}
+	t, err := time.ParseInLocation(icalDateTime, property.value, location)
+	return t, false, err
+}
+
+// splitICalList splits list value at commas not escaped by backslash
+func splitICalList(value string) []string {
+	items := []string{}
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case ',':
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, value[start:])
+}
+
+// icalEventData converts properties of VEVENT into event data, zones are VTIMEZONEs of feed.
+// Times in zones unknown by IANA name are kept in UTC
+func icalEventData(properties []icalProperty, zones map[string]icalZone) (EventData, error) {
+	var data EventData
+	var summary, description string
+	var start, end time.Time
+	var allDay, hasStart, hasEnd bool
+	var location *time.Location
+
+	for _, property := range properties {
+		var err error
+		switch property.name {
+		case "DTSTART":
+			start, allDay, err = parseICalTime(property, zones)
+			hasStart = true
+			location = start.Location()
+			if tzid := property.params["TZID"]; location.String() == tzid {
+				data.TimeZone = tzid
+			}
+		case "DTEND":
+			end, _, err = parseICalTime(property, zones)
+			hasEnd = true
+		case "SUMMARY":
+			summary = icalUnescaper.Replace(property.value)
+		case "DESCRIPTION":
+			description = icalUnescaper.Replace(property.value)
+		case "LOCATION":
+			location := icalUnescaper.Replace(property.value)
+			data.Location = &location
+		case "CATEGORIES":
+			for _, tag := range splitICalList(property.value) {
+				if tag = icalUnescaper.Replace(tag); strings.TrimSpace(tag) != "" {
+					data.Tags = append(data.Tags, tag)
+				}
+			}
+		case "ATTENDEE":
+			status := RSVPStatus(strings.ToLower(strings.ReplaceAll(property.params["PARTSTAT"], "-", "_")))
+			if status.validate() != nil {
+				status = RSVPNeedsAction
+			}
+			id := property.value
+			if len(id) > len("mailto:") && strings.EqualFold(id[:len("mailto:")], "mailto:") {
+				id = id[len("mailto:"):]
+			}
+			data.Attendees = append(data.Attendees, Attendee{ID: id, Name: property.params["CN"], Status: status})
+		case "RRULE":
+			data.Recurrence = property.value
+		case "EXDATE":
+			for _, value := range strings.Split(property.value, ",") {
+				var exception time.Time
+				exception, _, err = parseICalTime(icalProperty{name: property.name, params: property.params, value: value}, zones)
+				if err != nil {
+					break
+				}
+				if location != nil {
+					exception = exception.In(location)
+				}
+				data.Exceptions = append(data.Exceptions, exception.Format(dateLayout))
+			}
+		}
+		if err != nil {
+			return EventData{}, fmt.Errorf("Invalid %s: %v", property.name, err)
+		}
+	}
+
+	if !hasStart {
+		return EventData{}, fmt.Errorf("DTSTART is required")
+	}
+	data.Text = summary
+	if description != "" {
+		if summary != "" {
+			data.Title = &summary
+		}
+		data.Text = description
+	}
+	if data.Text == "" {
+		data.Text = "(no title)"
+	}
+
+	switch {
+	case allDay && (!hasEnd || end.Sub(start) <= 24*time.Hour):
+		data.Date = start.Format(dateLayout)
+	default:
+		if !hasEnd {
+			end = start
+		}
+		data.Start = start.Format(time.RFC3339)
+		data.End = end.Format(time.RFC3339)
+		data.AllDay = &allDay
+	}
+	return data, nil
+}
+
+// parseICalComponents returns properties of every VEVENT and time zones defined by VTIMEZONEs.
+// Components are tracked by nesting, so properties of VALARM and other nested components
+// dont leak into their event
+func parseICalComponents(lines []string) ([][]icalProperty, map[string]icalZone, error) {
+	events := [][]icalProperty{}
+	zones := map[string]icalZone{}
+	var stack []string
+	var properties []icalProperty
+	var zone []icalProperty
+	var observances [][]icalProperty
+
+	for _, line := range lines {
+		property, err := parseICalProperty(line)
+		if err != nil {
+			return nil, nil, invalid(err)
+		}
+		component := strings.ToUpper(property.value)
+		parent := ""
+		if len(stack) != 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		switch property.name {
+		case "BEGIN":
+			stack = append(stack, component)
+			switch {
+			case component == "VEVENT":
+				properties = nil
+			case component == "VTIMEZONE":
+				zone, observances = nil, nil
+			case parent == "VTIMEZONE":
+				observances = append(observances, nil)
+			}
+			continue
+		case "END":
+			if parent != component {
+				return nil, nil, invalidf("Unexpected END:%s inside %s", property.value, parent)
+			}
+			stack = stack[:len(stack)-1]
+			switch component {
+			case "VEVENT":
+				events = append(events, properties)
+			case "VTIMEZONE":
+				tzid, defined := parseICalZone(zone, observances)
+				if tzid != "" {
+					zones[tzid] = defined
+				}
+			}
+			continue
+		}
+
+		switch {
+		case parent == "VEVENT":
+			properties = append(properties, property)
+		case parent == "VTIMEZONE":
+			zone = append(zone, property)
+		case len(stack) >= 2 && stack[len(stack)-2] == "VTIMEZONE" && len(observances) != 0:
+			observances[len(observances)-1] = append(observances[len(observances)-1], property)
+		}
+	}
+	if len(stack) != 0 {
+		return nil, nil, invalidf("Component %s is not closed", stack[len(stack)-1])
+	}
+	return events, zones, nil
+}
+
+// icalEvent is data of VEVENT with its UID
+type icalEvent struct {
+	uid  string
+	data EventData
+}
+
+// maxImportID is max length of UID used as event ID as is
+const maxImportID = 128
+
+// importID returns ID of event imported with UID. UIDs safe to use in URLs are kept,
+// so exported events keep their IDs, other ones are hashed
+func importID(uid string) string {
+	valid := len(uid) <= maxImportID
+	for i := 0; valid && i < len(uid); i++ {
+		valid = strings.ContainsRune("0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_.@", rune(uid[i]))
+	}
+	if valid {
+		return uid
+	}
+	sum := sha256.Sum256([]byte(uid))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ImportICS adds events of iCalendar stream to calendar and returns their number, user needs
+// write access to calendar. Empty calendar ID means default calendar of user.
+// Events are identified by UID: event with ID of UID is replaced if user can change it,
+// so importing same feed again updates events instead of duplicating them.
+// Like Add, exact duplicates are rejected. Nothing is saved if any event fails
+func (c *Calendar) ImportICS(userID string, calendarID string, r io.Reader) (int, error) {
+	lines, err := readICalLines(r)
+	if err != nil {
+		return 0, err
+	}
+
+	components, zones, err := parseICalComponents(lines)
+	if err != nil {
+		return 0, err
+	}
+	events := make([]icalEvent, 0, len(components))
+	for i, properties := range components {
+		data, err := icalEventData(properties, zones)
+		if err != nil {
+			return 0, invalidf("Invalid event #%d: %v", i+1, err)
+		}
+		data.Calendar = calendarID
+		event := icalEvent{data: data}
+		for _, property := range properties {
+			if property.name == "UID" {
+				event.uid = property.value
+			}
+		}
+		events = append(events, event)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx := c.begin()
+	done := make([]applied, 0, len(events))
+	for i, event := range events {
+		change, err := c.importEvent(userID, event)
+		if err != nil {
+			return 0, c.rollback(tx, done, fmt.Errorf("Invalid event #%d: %w", i+1, err))
+		}
+		done = append(done, change)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, c.rollback(tx, done, err)
+	}
+	c.notifyApplied(done)
+	return len(done), nil
+}
+
+// importEvent saves imported event without notifying about it. Event with ID of UID is
+// replaced if user can change it, otherwise ID of UID within target calendar is tried,
+// so feeds of other users dont clash. Calendar must be locked for writing
+func (c *Calendar) importEvent(userID string, event icalEvent) (applied, error) {
+	if event.uid != "" {
+		collection, err := c.accessible(userID, event.data.Calendar, AccessWrite)
+		if err != nil {
+			return applied{}, err
+		}
+		for _, id := range []string{importID(event.uid), importID(collection.ID + "/" + event.uid)} {
+			existing, err := c.storage.Get(id)
+			if errors.Is(err, ErrNotFound) {
+				created, err := c.add(userID, id, event.data)
+				return applied{action: BatchCreate, after: created}, err
+			}
+			if err != nil {
+				return applied{}, err
+			}
+			if _, err := c.accessible(userID, existing.calendarID, AccessWrite); err == nil {
+				before, after, err := c.update(userID, id, event.data.Replacing())
+				return applied{action: BatchUpdate, before: before, after: after}, err
+			}
+		}
+	}
+	created, err := c.add(userID, "", event.data)
+	return applied{action: BatchCreate, after: created}, err
+}
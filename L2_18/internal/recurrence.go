@@ -0,0 +1,320 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NoRecurrence passed as recurrence rule turns recurring event into single one
+const NoRecurrence = "NONE"
+
+// maxOccurrences limits number of periods walked while expanding recurring event
+const maxOccurrences = 100000
+
+const (
+	freqDaily   = "DAILY"
+	freqWeekly  = "WEEKLY"
+	freqMonthly = "MONTHLY"
+	freqYearly  = "YEARLY"
+)
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// recurrence is parsed RRULE-style rule, e.g. FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10
+type recurrence struct {
+	freq     string
+	interval int
+	weekdays []time.Weekday
+	monthDay int
+	until    string
+	count    int
+}
+
+// parseRecurrence parses subset of RFC 5545 RRULE:
+// FREQ (DAILY, WEEKLY, MONTHLY, YEARLY), INTERVAL, BYDAY for weekly,
+// BYMONTHDAY for monthly and either UNTIL or COUNT
+func parseRecurrence(rule string) (*recurrence, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	r := &recurrence{interval: 1}
+
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid recurrence part %q", part)
+		}
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(value)
+		case "INTERVAL":
+			r.interval, err = strconv.Atoi(value)
+			if err == nil && r.interval < 1 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "BYDAY":
+			for _, code := range strings.Split(strings.ToUpper(value), ",") {
+				weekday, ok := weekdayCodes[code]
+				if !ok {
+					return nil, fmt.Errorf("Invalid recurrence weekday %q", code)
+				}
+				r.weekdays = append(r.weekdays, weekday)
+			}
+		case "BYMONTHDAY":
+			r.monthDay, err = strconv.Atoi(value)
+			if err == nil && (r.monthDay < 1 || r.monthDay > 31) {
+				err = fmt.Errorf("must be between 1 and 31")
+			}
+		case "UNTIL":
+			r.until, err = parseUntil(value)
+		case "COUNT":
+			r.count, err = strconv.Atoi(value)
+			if err == nil && r.count < 1 {
+				err = fmt.Errorf("must be positive")
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported recurrence part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid recurrence %s: %v", key, err)
+		}
+	}
+
+	switch r.freq {
+	case freqDaily, freqWeekly, freqMonthly, freqYearly:
+	case "":
+		return nil, fmt.Errorf("Recurrence frequency is required")
+	default:
+		return nil, fmt.Errorf("Unsupported recurrence frequency %s", r.freq)
+	}
+	if len(r.weekdays) != 0 && r.freq != freqWeekly {
+		return nil, fmt.Errorf("BYDAY is supported only for weekly recurrence")
+	}
+	if r.monthDay != 0 && r.freq != freqMonthly {
+		return nil, fmt.Errorf("BYMONTHDAY is supported only for monthly recurrence")
+	}
+	if r.until != "" && r.count != 0 {
+		return nil, fmt.Errorf("UNTIL and COUNT cant be used together")
+	}
+
+	sort.Slice(r.weekdays, func(i, j int) bool {
+		return mondayOffset(r.weekdays[i]) < mondayOffset(r.weekdays[j])
+	})
+	return r, nil
+}
+
+// parseUntil accepts UNTIL as date or date-time in basic or extended format
+// and returns its day in 2006-01-02 format
+func parseUntil(value string) (string, error) {
+	for _, layout := range []string{"20060102", "20060102T150405Z", "20060102T150405", dateLayout} {
+		if until, err := time.Parse(layout, value); err == nil {
+			return until.Format(dateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", value)
+}
+
+func mondayOffset(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
+
+// String returns rule in RRULE format
+func (r *recurrence) String() string {
+	parts := []string{"FREQ=" + r.freq}
+	if r.interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.interval))
+	}
+	if len(r.weekdays) != 0 {
+		codes := make([]string, 0, len(r.weekdays))
+		for _, weekday := range r.weekdays {
+			codes = append(codes, strings.ToUpper(weekday.String()[:2]))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.monthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(r.monthDay))
+	}
+	if r.until != "" {
+		parts = append(parts, "UNTIL="+strings.ReplaceAll(r.until, "-", ""))
+	}
+	if r.count != 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.count))
+	}
+	return strings.Join(parts, ";")
+}
+
+// skip returns number of whole periods of rule beginning at first that pass before from
+// and number of occurrences in them. One period before from is kept for shifts of wall clock.
+// Monthly and yearly periods without some day hold no occurrence, so they are not skipped with COUNT
+func (r *recurrence) skip(first time.Time, from time.Time) (periods int, emitted int) {
+	if !from.After(first) {
+		return 0, 0
+	}
+	var elapsed int
+	switch r.freq {
+	case freqDaily:
+		elapsed = int(from.Sub(first) / (24 * time.Hour))
+	case freqWeekly:
+		elapsed = int(from.Sub(first) / (7 * 24 * time.Hour))
+	case freqMonthly:
+		elapsed = (from.Year()-first.Year())*12 + int(from.Month()) - int(first.Month())
+	case freqYearly:
+		elapsed = from.Year() - first.Year()
+	}
+	periods = min(max(elapsed/r.interval-1, 0), maxOccurrences)
+	if periods == 0 {
+		return 0, 0
+	}
+
+	switch r.freq {
+	case freqDaily:
+		return periods, periods
+	case freqWeekly:
+		if len(r.weekdays) == 0 {
+			return periods, periods
+		}
+		for _, weekday := range r.weekdays {
+			if mondayOffset(weekday) >= mondayOffset(first.Weekday()) {
+				emitted++
+			}
+		}
+		return periods, emitted + (periods-1)*len(r.weekdays)
+	}
+	if r.count != 0 {
+		return 0, 0
+	}
+	return periods, 0
+}
+
+// starts calls yield for every occurrence start of rule beginning at first,
+// in order, until yield returns false or rule ends. Occurrences of periods wholly
+// before from may be skipped
+func (r *recurrence) starts(first time.Time, from time.Time, yield func(time.Time) bool) {
+	year, month, day := first.Date()
+	hour, minute, second := first.Clock()
+	location := first.Location()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, first.Nanosecond(), location)
+	}
+
+	skipped, emitted := r.skip(first, from)
+	emit := func(start time.Time) bool {
+		if r.until != "" && start.Format(dateLayout) > r.until {
+			return false
+		}
+		if r.count != 0 && emitted >= r.count {
+			return false
+		}
+		emitted++
+		return yield(start)
+	}
+
+	for period := skipped; period < maxOccurrences; period++ {
+		step := period * r.interval
+		switch r.freq {
+		case freqDaily:
+			if !emit(at(year, month, day+step)) {
+				return
+			}
+		case freqWeekly:
+			weekdays := r.weekdays
+			if len(weekdays) == 0 {
+				weekdays = []time.Weekday{first.Weekday()}
+			}
+			monday := day - mondayOffset(first.Weekday()) + 7*step
+			for _, weekday := range weekdays {
+				start := at(year, month, monday+mondayOffset(weekday))
+				if start.Before(first) {
+					continue
+				}
+				if !emit(start) {
+					return
+				}
+			}
+		case freqMonthly:
+			monthDay := r.monthDay
+			if monthDay == 0 {
+				monthDay = day
+			}
+			start := at(year, month+time.Month(step), monthDay)
+			if start.Day() != monthDay || start.Before(first) {
+				continue
+			}
+			if !emit(start) {
+				return
+			}
+		case freqYearly:
+			start := at(year+step, month, day)
+			if start.Day() != day {
+				continue
+			}
+			if !emit(start) {
+				return
+			}
+		}
+	}
+}
+
+// endedBefore reports whether every occurrence of recurring event ends before t by UNTIL of its rule
+func (e Event) endedBefore(t time.Time) bool {
+	if e.recurrence.until == "" {
+		return false
+	}
+	until, err := time.ParseInLocation(dateLayout, e.recurrence.until, e.start.Location())
+	if err != nil {
+		return false
+	}
+	// last occurrence starts within UNTIL day
+	return until.AddDate(0, 0, 1).Add(e.end.Sub(e.start)).Before(t)
+}
+
+// occurrences returns copies of recurring event for each its occurrence within [from, to)
+func (e Event) occurrences(from time.Time, to time.Time) []Event {
+	found := []Event{}
+	skipped := make(map[string]bool, len(e.exceptions))
+	for _, day := range e.exceptions {
+		skipped[day] = true
+	}
+
+	// all-day events are floating and may shift by a day relative to the query zone
+	limit := to.AddDate(0, 0, 2)
+	days := int(e.end.Sub(e.start).Hours()+12) / 24
+	duration := e.end.Sub(e.start)
+
+	// occurrences starting before from overlap it only by their duration
+	e.recurrence.starts(e.start, from.Add(-duration-floatingSlack), func(start time.Time) bool {
+		if start.After(limit) {
+			return false
+		}
+		if skipped[start.Format(dateLayout)] {
+			return true
+		}
+
+		occurrence := e
+		occurrence.start = start
+		if e.allDay {
+			occurrence.end = start.AddDate(0, 0, days)
+		} else {
+			occurrence.end = start.Add(duration)
+		}
+		if occurrence.overlaps(from, to) {
+			found = append(found, occurrence)
+		}
+		return true
+	})
+	return found
+}
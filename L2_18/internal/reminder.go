@@ -0,0 +1,160 @@
+package calendar
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxReminder bounds how long before event reminder may fire
+const maxReminder = 366 * 24 * time.Hour
+
+// Reminder is notification due before start of event occurrence
+type Reminder struct {
+	// Event is event or occurrence of recurring event the reminder belongs to
+	Event Event
+	// Before is offset of reminder from start of event
+	Before time.Duration
+	// At is time when reminder fires
+	At time.Time
+}
+
+// parseReminder parses offset before event like "15m", "2h", "1d" or "1h30m"
+func parseReminder(value string) (time.Duration, error) {
+	var offset time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var count int
+		count, err = strconv.Atoi(days)
+		offset = time.Duration(count) * 24 * time.Hour
+	} else {
+		offset, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Invalid reminder %q: expected offset like 15m, 2h or 1d", value)
+	}
+	if offset < 0 || offset > maxReminder {
+		return 0, fmt.Errorf("Reminder %q must be between 0 and 366d before event", value)
+	}
+	return offset, nil
+}
+
+// formatReminder formats offset the way parseReminder accepts it
+func formatReminder(offset time.Duration) string {
+	switch {
+	case offset != 0 && offset%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", offset/(24*time.Hour))
+	case offset%time.Hour == 0:
+		return fmt.Sprintf("%dh", offset/time.Hour)
+	case offset%time.Minute == 0 && offset < time.Hour:
+		return fmt.Sprintf("%dm", offset/time.Minute)
+	default:
+		return offset.String()
+	}
+}
+
+// setReminders fills reminders of event from data, nil reminders keep current ones
+func (e *Event) setReminders(data EventData) error {
+	if data.Reminders == nil {
+		return nil
+	}
+
+	seen := map[time.Duration]bool{}
+	reminders := make([]time.Duration, 0, len(data.Reminders))
+	for _, value := range data.Reminders {
+		offset, err := parseReminder(value)
+		if err != nil {
+			return err
+		}
+		if !seen[offset] {
+			seen[offset] = true
+			reminders = append(reminders, offset)
+		}
+	}
+	sort.Slice(reminders, func(i, j int) bool {
+		return reminders[i] < reminders[j]
+	})
+	if len(reminders) == 0 {
+		reminders = nil
+	}
+	e.reminders = reminders
+	return nil
+}
+
+func (e Event) reminderStrings() []string {
+	if len(e.reminders) == 0 {
+		return nil
+	}
+	values := make([]string, 0, len(e.reminders))
+	for _, offset := range e.reminders {
+		values = append(values, formatReminder(offset))
+	}
+	return values
+}
+
+// Reminders returns offsets before start of event when reminders fire, shortest first
+func (e Event) Reminders() []time.Duration {
+	return append([]time.Duration(nil), e.reminders...)
+}
+
+// DueReminders returns reminders of all users firing within [from, to) ordered by fire time.
+// Every occurrence of recurring event gets its own reminders
+func (c *Calendar) DueReminders(from time.Time, to time.Time) ([]Reminder, error) {
+	c.mu.RLock()
+	events, err := c.storage.Reminded()
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	due := []Reminder{}
+	for _, event := range events {
+		location := event.start.Location()
+		for _, offset := range event.reminders {
+			windowFrom := from.Add(offset).In(location)
+			windowTo := to.Add(offset).In(location)
+
+			occurrences := []Event{event}
+			if event.recurrence != nil {
+				occurrences = event.occurrences(windowFrom, windowTo)
+			}
+			for _, occurrence := range occurrences {
+				if occurrence.start.Before(windowFrom) || !occurrence.start.Before(windowTo) {
+					continue
+				}
+				due = append(due, Reminder{
+					Event:  occurrence,
+					Before: offset,
+					At:     occurrence.start.Add(-offset),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		if !due[i].At.Equal(due[j].At) {
+			return due[i].At.Before(due[j].At)
+		}
+		return due[i].Event.id < due[j].Event.id
+	})
+	return due, nil
+}
+
+// reminderJSON is representation of reminder in notifications
+type reminderJSON struct {
+	Event  Event  `json:"event"`
+	Before string `json:"before"`
+	At     string `json:"at"`
+}
+
+// MarshalJSON encodes reminder with its offset in format accepted by EventData
+func (r Reminder) MarshalJSON() ([]byte, error) {
+	return json.Marshal(reminderJSON{
+		Event:  r.Event,
+		Before: formatReminder(r.Before),
+		At:     r.At.Format(time.RFC3339),
+	})
+}
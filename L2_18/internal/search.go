@@ -0,0 +1,408 @@
+package calendar
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 200
+	// maxSearchTerms bounds number of words and phrases in search query
+	maxSearchTerms = 20
+	// prefixWeight scales score of words matched only by prefix
+	prefixWeight = 0.5
+	// phraseWeight scales score of matched phrases
+	phraseWeight = 2
+)
+
+// Weights of matches in fields of event, title and labels describe event better than text
+const (
+	weightTitle    = 3
+	weightLabel    = 2
+	weightText     = 1
+	weightAttendee = 1
+)
+
+// posting is occurrence of term in event
+type posting struct {
+	position int
+	weight   float64
+}
+
+// indexedEvent is event known to search index
+type indexedEvent struct {
+	calendarID string
+	// terms are distinct terms of event, kept to remove its postings
+	terms []string
+}
+
+// searchIndex is inverted index of words of events. Calendars are indexed on first search
+// of their events and then kept up to date with every change, safe for concurrent use
+type searchIndex struct {
+	mu       sync.Mutex
+	loaded   map[string]bool
+	events   map[string]indexedEvent
+	postings map[string]map[string][]posting
+	// vocabulary is sorted list of terms, words match its terms by prefix
+	vocabulary []string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		loaded:   map[string]bool{},
+		events:   map[string]indexedEvent{},
+		postings: map[string]map[string][]posting{},
+	}
+}
+
+// tokenize splits text into lowercase words of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchField is text of event field with weight of its matches
+type searchField struct {
+	text   string
+	weight float64
+}
+
+// eventPostings returns postings of terms of event. Fields are separated by position gap,
+// so phrases dont match across them
+func eventPostings(event Event) map[string][]posting {
+	fields := []searchField{
+		{event.title, weightTitle},
+		{event.text, weightText},
+		{event.location, weightLabel},
+		{event.category, weightLabel},
+	}
+	for _, tag := range event.tags {
+		fields = append(fields, searchField{tag, weightLabel})
+	}
+	for _, attendee := range event.attendees {
+		fields = append(fields, searchField{attendee.Name, weightAttendee})
+	}
+
+	postings := map[string][]posting{}
+	position := 0
+	for _, field := range fields {
+		for _, term := range tokenize(field.text) {
+			postings[term] = append(postings[term], posting{position: position, weight: field.weight})
+			position++
+		}
+		position++
+	}
+	return postings
+}
+
+// load indexes events of calendar unless it is indexed already
+func (x *searchIndex) load(calendarID string, events func() ([]Event, error)) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.loaded[calendarID] {
+		return nil
+	}
+	stored, err := events()
+	if err != nil {
+		return err
+	}
+	for _, event := range stored {
+		x.add(event)
+	}
+	x.loaded[calendarID] = true
+	return nil
+}
+
+// update applies change of event to index. Events of calendars not indexed yet are skipped,
+// they are indexed with whole calendar later
+func (x *searchIndex) update(change Change) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(change.Event.id)
+	if change.Type != EventDeleted && x.loaded[change.Event.calendarID] {
+		x.add(change.Event)
+	}
+}
+
+// add indexes event. Index must be locked
+func (x *searchIndex) add(event Event) {
+	postings := eventPostings(event)
+	terms := make([]string, 0, len(postings))
+	for term, positions := range postings {
+		events, ok := x.postings[term]
+		if !ok {
+			events = map[string][]posting{}
+			x.postings[term] = events
+			i := sort.SearchStrings(x.vocabulary, term)
+			x.vocabulary = append(x.vocabulary, "")
+			copy(x.vocabulary[i+1:], x.vocabulary[i:])
+			x.vocabulary[i] = term
+		}
+		events[event.id] = positions
+		terms = append(terms, term)
+	}
+	x.events[event.id] = indexedEvent{calendarID: event.calendarID, terms: terms}
+}
+
+// remove drops event from index. Index must be locked
+func (x *searchIndex) remove(id string) {
+	indexed, ok := x.events[id]
+	if !ok {
+		return
+	}
+	for _, term := range indexed.terms {
+		events := x.postings[term]
+		delete(events, id)
+		if len(events) == 0 {
+			delete(x.postings, term)
+			i := sort.SearchStrings(x.vocabulary, term)
+			x.vocabulary = append(x.vocabulary[:i], x.vocabulary[i+1:]...)
+		}
+	}
+	delete(x.events, id)
+}
+
+// idf is inverse document frequency of term, rare terms weigh more. Index must be locked
+func (x *searchIndex) idf(term string) float64 {
+	return math.Log(1 + float64(len(x.events))/float64(len(x.postings[term])))
+}
+
+// searchTerm is word or quoted phrase of search query
+type searchTerm struct {
+	words  []string
+	phrase bool
+}
+
+// parseSearch splits query into quoted phrases and single words
+func parseSearch(query string) ([]searchTerm, error) {
+	terms := []searchTerm{}
+	for i, part := range strings.Split(query, `"`) {
+		words := tokenize(part)
+		if i%2 == 1 && len(words) > 1 {
+			terms = append(terms, searchTerm{words: words, phrase: true})
+			continue
+		}
+		for _, word := range words {
+			terms = append(terms, searchTerm{words: []string{word}})
+		}
+	}
+	if len(terms) == 0 {
+		return nil, invalidf("Search query must contain words")
+	}
+	if len(terms) > maxSearchTerms {
+		return nil, invalidf("Search query cant have more than %d words and phrases", maxSearchTerms)
+	}
+	return terms, nil
+}
+
+// scores returns scores of events of calendars matching all terms. Index must be locked
+func (x *searchIndex) scores(terms []searchTerm, calendarIDs map[string]bool) map[string]float64 {
+	var found map[string]float64
+	for _, term := range terms {
+		var matched map[string]float64
+		if term.phrase {
+			matched = x.matchPhrase(term.words)
+		} else {
+			matched = x.matchWord(term.words[0])
+		}
+
+		next := map[string]float64{}
+		for id, score := range matched {
+			if !calendarIDs[x.events[id].calendarID] {
+				continue
+			}
+			if found == nil {
+				next[id] = score
+			} else if previous, ok := found[id]; ok {
+				next[id] = previous + score
+			}
+		}
+		found = next
+		if len(found) == 0 {
+			break
+		}
+	}
+	return found
+}
+
+// matchWord scores events having terms starting with word, exact matches score higher.
+// Index must be locked
+func (x *searchIndex) matchWord(word string) map[string]float64 {
+	matched := map[string]float64{}
+	for i := sort.SearchStrings(x.vocabulary, word); i < len(x.vocabulary); i++ {
+		term := x.vocabulary[i]
+		if !strings.HasPrefix(term, word) {
+			break
+		}
+		weight := x.idf(term)
+		if term != word {
+			weight *= prefixWeight
+		}
+		for id, positions := range x.postings[term] {
+			for _, p := range positions {
+				matched[id] += p.weight * weight
+			}
+		}
+	}
+	return matched
+}
+
+// matchPhrase scores events having words of phrase in a row. Index must be locked
+func (x *searchIndex) matchPhrase(words []string) map[string]float64 {
+	weight := 0.0
+	for _, word := range words {
+		if _, ok := x.postings[word]; !ok {
+			return nil
+		}
+		weight += x.idf(word)
+	}
+
+	matched := map[string]float64{}
+	for id, positions := range x.postings[words[0]] {
+	next:
+		for _, p := range positions {
+			for i, word := range words[1:] {
+				if !hasPosition(x.postings[word][id], p.position+i+1) {
+					continue next
+				}
+			}
+			matched[id] += p.weight * weight * phraseWeight
+		}
+	}
+	return matched
+}
+
+func hasPosition(positions []posting, position int) bool {
+	for _, p := range positions {
+		if p.position == position {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchQuery describes full-text search of events
+type SearchQuery struct {
+	// Query is words matched by prefix and phrases in double quotes matched exactly,
+	// events must match all of them regardless of case
+	Query string
+	// From and To limit results to events happening within range, both or none must be given.
+	// Bounds are like in EventQuery
+	From string
+	To   string
+	// TimeZone is IANA name of zone days of range belong to, UTC if empty
+	TimeZone string
+	// Calendars are IDs of searched calendars, all calendars user can read if empty
+	Calendars []string
+	// Limit is max number of results, 20 by default
+	Limit int
+}
+
+// SearchResult is event matching search with its relevance
+type SearchResult struct {
+	// Event is matched event, for recurring event with range given it is first occurrence in range
+	Event Event
+	// Score is relevance of event, higher is better
+	Score float64
+}
+
+// Search returns events of calendars user can read matching query, most relevant first.
+// Title, text, location, category, tags and names of attendees are searched
+func (c *Calendar) Search(userID string, query SearchQuery) ([]SearchResult, error) {
+	terms, err := parseSearch(query.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if limit < 0 || limit > maxSearchLimit {
+		return nil, invalidf("Limit must be between 1 and %d", maxSearchLimit)
+	}
+
+	var from, to time.Time
+	ranged := query.From != "" || query.To != ""
+	if ranged {
+		from, to, _, err = parseRange(query.From, query.To, query.TimeZone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	calendarIDs := query.Calendars
+	if len(calendarIDs) == 0 {
+		c.mu.RLock()
+		collections, err := c.collections(userID)
+		c.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		for _, collection := range collections {
+			calendarIDs = append(calendarIDs, collection.ID)
+		}
+	}
+	calendarIDs, err = c.readable(userID, calendarIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	searched := map[string]bool{}
+	for _, id := range calendarIDs {
+		searched[id] = true
+		if err := c.index.load(id, func() ([]Event, error) { return c.storage.Events(id) }); err != nil {
+			return nil, err
+		}
+	}
+
+	c.index.mu.Lock()
+	scores := c.index.scores(terms, searched)
+	c.index.mu.Unlock()
+
+	results := []SearchResult{}
+	for id, score := range scores {
+		event, err := c.storage.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if ranged {
+			if event.recurrence == nil && !event.overlaps(from, to) {
+				continue
+			}
+			if event.recurrence != nil {
+				occurrences := event.occurrences(from, to)
+				if len(occurrences) == 0 {
+					continue
+				}
+				event = occurrences[0]
+			}
+		}
+		results = append(results, SearchResult{Event: event, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if !results[i].Event.start.Equal(results[j].Event.start) {
+			return results[i].Event.start.Before(results[j].Event.start)
+		}
+		return results[i].Event.id < results[j].Event.id
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,373 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// floatingSlack covers shift of floating all-day events between most distant time zones
+const floatingSlack = 26 * time.Hour
+
+// Storage represents a backend that keeps calendar events.
+// Implementations must be safe for concurrent use
+type Storage interface {
+	// Insert saves new event
+	Insert(event Event) error
+	// Update replaces stored event with the same ID
+	Update(event Event) error
+	// Delete removes event by ID
+	Delete(id string) error
+	// Get returns event by ID
+	Get(id string) (Event, error)
+	// Events returns all events of calendar
+	Events(calendarID string) ([]Event, error)
+	// Range returns events of calendar that may happen within [from, to):
+	// all recurring events and single events close to the range.
+	// Exact overlap is checked by caller
+	Range(calendarID string, from time.Time, to time.Time) ([]Event, error)
+	// Reminded returns events of all users having reminders
+	Reminded() ([]Event, error)
+	// SaveCollection saves new calendar or replaces stored one with the same ID
+	SaveCollection(collection Collection) error
+	// DeleteCollection removes calendar by ID, its events are deleted by caller
+	DeleteCollection(id string) error
+	// GetCollection returns calendar by ID, ErrCalendarNotFound if it was never saved
+	GetCollection(id string) (Collection, error)
+	// Collections returns all saved calendars
+	Collections() ([]Collection, error)
+	// Stats returns numbers of stored objects
+	Stats() (StorageStats, error)
+	// Close releases resources held by storage
+	Close() error
+}
+
+// Transactional is implemented by storages able to save several changes at once.
+// Changes made after Begin are visible at once, but they are saved only by Commit,
+// so they survive crash all together or not at all. If Commit fails transaction stays open,
+// so caller can revert changes before Discard. Calendar must be locked for writing
+// for whole transaction
+type Transactional interface {
+	Begin()
+	Commit() error
+	Discard()
+}
+
+// noTransaction stands for transaction of storage saving every change at once
+type noTransaction struct{}
+
+func (noTransaction) Begin()        {}
+func (noTransaction) Commit() error { return nil }
+func (noTransaction) Discard()      {}
+
+// StorageStats are numbers of objects kept by storage
+type StorageStats struct {
+	// Events is number of all stored events
+	Events int
+	// RecurringEvents is number of events with recurrence rule
+	RecurringEvents int
+	// RemindedEvents is number of events having reminders
+	RemindedEvents int
+	// Calendars is number of saved calendars, default calendars are saved only once changed
+	Calendars int
+}
+
+// calendarIndex keeps events of single calendar
+type calendarIndex struct {
+	// single are non-recurring events ordered by start and ID
+	single []Event
+	// recurring are recurring events by ID
+	recurring map[string]Event
+	// lengths counts single events by duration, so longest shrinks when they are removed
+	lengths map[time.Duration]int
+	// longest is max duration of stored single event, bounds range lookups
+	longest time.Duration
+}
+
+func eventBefore(a Event, b Event) bool {
+	if !a.start.Equal(b.start) {
+		return a.start.Before(b.start)
+	}
+	return a.id < b.id
+}
+
+func (u *calendarIndex) insert(event Event) {
+	if event.recurrence != nil {
+		u.recurring[event.id] = event
+		return
+	}
+
+	length := event.end.Sub(event.start)
+	u.lengths[length]++
+	if length > u.longest {
+		u.longest = length
+	}
+	ind := sort.Search(len(u.single), func(i int) bool {
+		return !eventBefore(u.single[i], event)
+	})
+	u.single = append(u.single, Event{})
+	copy(u.single[ind+1:], u.single[ind:])
+	u.single[ind] = event
+}
+
+func (u *calendarIndex) remove(event Event) {
+	if event.recurrence != nil {
+		delete(u.recurring, event.id)
+		return
+	}
+
+	ind := sort.Search(len(u.single), func(i int) bool {
+		return !eventBefore(u.single[i], event)
+	})
+	if ind >= len(u.single) || u.single[ind].id != event.id {
+		return
+	}
+	u.single = append(u.single[:ind], u.single[ind+1:]...)
+
+	length := event.end.Sub(event.start)
+	if u.lengths[length]--; u.lengths[length] > 0 {
+		return
+	}
+	delete(u.lengths, length)
+	if length == u.longest {
+		u.longest = 0
+		for other := range u.lengths {
+			u.longest = max(u.longest, other)
+		}
+	}
+}
+
+// MemoryStorage keeps events in memory indexed by ID and by calendar with ordering by start
+type MemoryStorage struct {
+	mu        sync.RWMutex
+	byID      map[string]Event
+	calendars map[string]*calendarIndex
+	// reminded are IDs of events having reminders
+	reminded    map[string]struct{}
+	collections map[string]Collection
+}
+
+// NewMemoryStorage creates new in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		byID:        map[string]Event{},
+		calendars:   map[string]*calendarIndex{},
+		reminded:    map[string]struct{}{},
+		collections: map[string]Collection{},
+	}
+}
+
+func (s *MemoryStorage) calendar(calendarID string) *calendarIndex {
+	index, ok := s.calendars[calendarID]
+	if !ok {
+		index = &calendarIndex{recurring: map[string]Event{}, lengths: map[time.Duration]int{}}
+		s.calendars[calendarID] = index
+	}
+	return index
+}
+
+// Insert saves new event
+func (s *MemoryStorage) Insert(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[event.id]; ok {
+		return fmt.Errorf("%w: ID %s already exists", ErrConflict, event.id)
+	}
+	s.byID[event.id] = event
+	s.calendar(event.calendarID).insert(event)
+	s.remind(event)
+	return nil
+}
+
+// remind tracks whether event has reminders
+func (s *MemoryStorage) remind(event Event) {
+	if len(event.reminders) != 0 {
+		s.reminded[event.id] = struct{}{}
+	} else {
+		delete(s.reminded, event.id)
+	}
+}
+
+// Update replaces stored event with the same ID
+func (s *MemoryStorage) Update(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.byID[event.id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.calendar(stored.calendarID).remove(stored)
+	s.byID[event.id] = event
+	s.calendar(event.calendarID).insert(event)
+	s.remind(event)
+	return nil
+}
+
+// Delete removes event by ID
+func (s *MemoryStorage) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.calendar(stored.calendarID).remove(stored)
+	delete(s.byID, id)
+	delete(s.reminded, id)
+	return nil
+}
+
+// Get returns event by ID
+func (s *MemoryStorage) Get(id string) (Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	event, ok := s.byID[id]
+	if !ok {
+		return Event{}, ErrNotFound
+	}
+	return event, nil
+}
+
+// Reminded returns events of all users having reminders
+func (s *MemoryStorage) Reminded() ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]Event, 0, len(s.reminded))
+	for id := range s.reminded {
+		events = append(events, s.byID[id])
+	}
+	return events, nil
+}
+
+// Events returns all events of calendar
+func (s *MemoryStorage) Events(calendarID string) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index, ok := s.calendars[calendarID]
+	if !ok {
+		return []Event{}, nil
+	}
+	calendarEvents := make([]Event, 0, len(index.single)+len(index.recurring))
+	calendarEvents = append(calendarEvents, index.single...)
+	for _, event := range index.recurring {
+		calendarEvents = append(calendarEvents, event)
+	}
+	return calendarEvents, nil
+}
+
+// Range returns events of calendar that may happen within [from, to)
+func (s *MemoryStorage) Range(calendarID string, from time.Time, to time.Time) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	index, ok := s.calendars[calendarID]
+	if !ok {
+		return []Event{}, nil
+	}
+
+	low := from.Add(-index.longest - floatingSlack)
+	high := to.Add(floatingSlack)
+	first := sort.Search(len(index.single), func(i int) bool {
+		return !index.single[i].start.Before(low)
+	})
+
+	found := []Event{}
+	for _, event := range index.single[first:] {
+		if !event.start.Before(high) {
+			break
+		}
+		found = append(found, event)
+	}
+	for _, event := range index.recurring {
+		if event.start.Before(high) && !event.endedBefore(from.Add(-floatingSlack)) {
+			found = append(found, event)
+		}
+	}
+	return found, nil
+}
+
+// SaveCollection saves new calendar or replaces stored one with the same ID
+func (s *MemoryStorage) SaveCollection(collection Collection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.collections[collection.ID] = collection.clone()
+	return nil
+}
+
+// DeleteCollection removes calendar by ID
+func (s *MemoryStorage) DeleteCollection(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.collections[id]; !ok {
+		return ErrCalendarNotFound
+	}
+	delete(s.collections, id)
+	delete(s.calendars, id)
+	return nil
+}
+
+// GetCollection returns calendar by ID
+func (s *MemoryStorage) GetCollection(id string) (Collection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	collection, ok := s.collections[id]
+	if !ok {
+		return Collection{}, ErrCalendarNotFound
+	}
+	return collection.clone(), nil
+}
+
+// Collections returns all saved calendars
+func (s *MemoryStorage) Collections() ([]Collection, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	collections := make([]Collection, 0, len(s.collections))
+	for _, collection := range s.collections {
+		collections = append(collections, collection.clone())
+	}
+	return collections, nil
+}
+
+// all returns all stored events
+func (s *MemoryStorage) all() []Event {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]Event, 0, len(s.byID))
+	for _, event := range s.byID {
+		events = append(events, event)
+	}
+	return events
+}
+
+// Stats returns numbers of stored objects
+func (s *MemoryStorage) Stats() (StorageStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := StorageStats{
+		Events:         len(s.byID),
+		RemindedEvents: len(s.reminded),
+		Calendars:      len(s.collections),
+	}
+	for _, index := range s.calendars {
+		stats.RecurringEvents += len(index.recurring)
+	}
+	return stats, nil
+}
+
+// Close does nothing for in-memory storage
+func (s *MemoryStorage) Close() error {
+	return nil
+}
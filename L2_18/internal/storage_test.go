@@ -0,0 +1,337 @@
+package calendar
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scanStorage is linear slice storage used as baseline for indexed one
+type scanStorage struct {
+	events []Event
+}
+
+func (s *scanStorage) Insert(event Event) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *scanStorage) Update(event Event) error {
+	for i := range s.events {
+		if s.events[i].id == event.id {
+			s.events[i] = event
+			return nil
+		}
+	}
+	return fmt.Errorf("Event not found")
+}
+
+func (s *scanStorage) Delete(id string) error {
+	for i := range s.events {
+		if s.events[i].id == id {
+			s.events = append(s.events[:i], s.events[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Event not found")
+}
+
+func (s *scanStorage) Get(id string) (Event, error) {
+	for _, event := range s.events {
+		if event.id == id {
+			return event, nil
+		}
+	}
+	return Event{}, fmt.Errorf("Event not found")
+}
+
+func (s *scanStorage) Events(calendarID string) ([]Event, error) {
+	found := []Event{}
+	for _, event := range s.events {
+		if event.calendarID == calendarID {
+			found = append(found, event)
+		}
+	}
+	return found, nil
+}
+
+func (s *scanStorage) Range(calendarID string, from time.Time, to time.Time) ([]Event, error) {
+	return s.Events(calendarID)
+}
+
+func (s *scanStorage) Reminded() ([]Event, error) {
+	found := []Event{}
+	for _, event := range s.events {
+		if len(event.reminders) != 0 {
+			found = append(found, event)
+		}
+	}
+	return found, nil
+}
+
+func (s *scanStorage) SaveCollection(collection Collection) error {
+	return fmt.Errorf("Calendars are not supported")
+}
+
+func (s *scanStorage) DeleteCollection(id string) error {
+	return ErrCalendarNotFound
+}
+
+func (s *scanStorage) GetCollection(id string) (Collection, error) {
+	return Collection{}, ErrCalendarNotFound
+}
+
+func (s *scanStorage) Collections() ([]Collection, error) {
+	return []Collection{}, nil
+}
+
+func (s *scanStorage) Stats() (StorageStats, error) {
+	return StorageStats{Events: len(s.events)}, nil
+}
+
+func (s *scanStorage) Close() error {
+	return nil
+}
+
+// randomEvent creates event of one of users within 2024 year
+func randomEvent(rnd *rand.Rand, id int, users int) Event {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(rnd.Intn(366*24)) * time.Hour)
+	userID := fmt.Sprintf("u%d", rnd.Intn(users))
+	event := Event{
+		id:         fmt.Sprintf("%08d", id),
+		userID:     userID,
+		calendarID: userID,
+		start:      start,
+		end:        start.Add(time.Duration(rnd.Intn(4)) * time.Hour),
+		timeZone:   "UTC",
+		text:       fmt.Sprintf("event %d", id),
+	}
+	if rnd.Intn(10) == 0 {
+		event.allDay = true
+		event.start = startOfDay(start)
+		event.end = event.start.AddDate(0, 0, 1+rnd.Intn(3))
+	}
+	return event
+}
+
+func TestMemoryStorageMatchesScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	indexed := NewCalendarWithStorage(NewMemoryStorage())
+	scanned := NewCalendarWithStorage(&scanStorage{})
+
+	for i := 0; i < 5000; i++ {
+		event := randomEvent(rnd, i, 5)
+		if err := indexed.storage.Insert(event); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+		scanned.storage.Insert(event)
+
+		switch rnd.Intn(10) {
+		case 0:
+			id := fmt.Sprintf("%08d", rnd.Intn(i+1))
+			indexed.storage.Delete(id)
+			scanned.storage.Delete(id)
+		case 1:
+			moved := randomEvent(rnd, rnd.Intn(i+1), 5)
+			if stored, err := indexed.storage.Get(moved.id); err == nil {
+				moved.userID = stored.userID
+				moved.calendarID = stored.calendarID
+				indexed.storage.Update(moved)
+				scanned.storage.Update(moved)
+			}
+		}
+	}
+
+	want, _ := scanned.Stats()
+	if got, err := indexed.Stats(); err != nil || got.Events != want.Events {
+		t.Errorf("Stats() = %+v, %v, want %d events", got, err, want.Events)
+	}
+
+	for _, timeZone := range []string{"", "Pacific/Kiritimati", "Pacific/Pago_Pago"} {
+		for day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); day.Year() == 2024; day = day.AddDate(0, 0, 5) {
+			for user := 0; user < 5; user++ {
+				userID := fmt.Sprintf("u%d", user)
+				want, _ := scanned.GetEventsByDay(userID, day.Format(dateLayout), timeZone)
+				got, err := indexed.GetEventsByDay(userID, day.Format(dateLayout), timeZone)
+				if err != nil {
+					t.Fatalf("GetEventsByDay() error = %v", err)
+				}
+				if ids(got) != ids(want) {
+					t.Fatalf("GetEventsByDay(%s, %s, %q) = %v, want %v", userID, day.Format(dateLayout), timeZone, ids(got), ids(want))
+				}
+			}
+		}
+	}
+}
+
+func TestMemoryStorageLongestShrinks(t *testing.T) {
+	storage := NewMemoryStorage()
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	short := Event{id: "short", calendarID: "u", start: start, end: start.Add(time.Hour)}
+	long := Event{id: "long", calendarID: "u", start: start, end: start.AddDate(0, 0, 30)}
+	for _, event := range []Event{short, long} {
+		if err := storage.Insert(event); err != nil {
+			t.Fatalf("Insert() error = %v", err)
+		}
+	}
+
+	moved := long
+	moved.end = moved.start.AddDate(0, 0, 2)
+	if err := storage.Update(moved); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if got := storage.calendars["u"].longest; got != 48*time.Hour {
+		t.Errorf("longest after update = %v, want 48h", got)
+	}
+	if err := storage.Delete("long"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if got := storage.calendars["u"].longest; got != time.Hour {
+		t.Errorf("longest after delete = %v, want 1h", got)
+	}
+}
+
+func TestRecurrenceSkipMatchesWalk(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	first := time.Date(2024, 2, 29, 23, 30, 0, 0, location)
+	rules := []string{
+		"FREQ=DAILY",
+		"FREQ=DAILY;INTERVAL=3;COUNT=500",
+		"FREQ=WEEKLY;BYDAY=MO,TH,SU;COUNT=200",
+		"FREQ=WEEKLY;INTERVAL=2",
+		"FREQ=MONTHLY;BYMONTHDAY=31;COUNT=20",
+		"FREQ=MONTHLY;INTERVAL=2",
+		"FREQ=YEARLY",
+		"FREQ=YEARLY;COUNT=3",
+	}
+	for _, rule := range rules {
+		r, err := parseRecurrence(rule)
+		if err != nil {
+			t.Fatalf("parseRecurrence(%q) error = %v", rule, err)
+		}
+		event := Event{id: "e", start: first, end: first.Add(3 * time.Hour), timeZone: location.String(), recurrence: r}
+
+		for from := first.AddDate(0, 0, -3); from.Year() < 2037; from = from.Add(241 * time.Hour) {
+			to := from.AddDate(0, 0, 9)
+			want := []string{}
+			r.starts(first, first, func(start time.Time) bool {
+				if !start.Before(to) {
+					return false
+				}
+				if start.Add(3 * time.Hour).After(from) {
+					want = append(want, start.Format(time.RFC3339))
+				}
+				return true
+			})
+			got := []string{}
+			for _, occurrence := range event.occurrences(from, to) {
+				got = append(got, occurrence.start.Format(time.RFC3339))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("%s occurrences(%v, %v) = %v, want %v", rule, from, to, got, want)
+			}
+		}
+	}
+}
+
+func ids(events []Event) string {
+	eventIDs := make([]string, 0, len(events))
+	for _, event := range events {
+		eventIDs = append(eventIDs, event.id)
+	}
+	sort.Strings(eventIDs)
+	return fmt.Sprint(eventIDs)
+}
+
+const (
+	benchEvents = 1000000
+	benchUsers  = 10000
+)
+
+var (
+	benchOnce    sync.Once
+	benchIndexed *Calendar
+	benchScanned *Calendar
+)
+
+// benchCalendars fills indexed and linear calendars with 1M events across 10k users
+func benchCalendars() (*Calendar, *Calendar) {
+	benchOnce.Do(func() {
+		rnd := rand.New(rand.NewSource(1))
+		indexed := NewMemoryStorage()
+		scanned := &scanStorage{events: make([]Event, 0, benchEvents)}
+		for i := 0; i < benchEvents; i++ {
+			event := randomEvent(rnd, i, benchUsers)
+			indexed.Insert(event)
+			scanned.Insert(event)
+		}
+		benchIndexed = NewCalendarWithStorage(indexed)
+		benchScanned = NewCalendarWithStorage(scanned)
+	})
+	return benchIndexed, benchScanned
+}
+
+func benchmarkQuery(b *testing.B, query func(c *Calendar, userID string) ([]Event, error)) {
+	indexed, scanned := benchCalendars()
+	for _, bench := range []struct {
+		name     string
+		calendar *Calendar
+	}{
+		{"indexed", indexed},
+		{"linear", scanned},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := query(bench.calendar, fmt.Sprintf("u%d", i%benchUsers)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetEventsByDay(b *testing.B) {
+	benchmarkQuery(b, func(c *Calendar, userID string) ([]Event, error) {
+		return c.GetEventsByDay(userID, "2024-06-15", "")
+	})
+}
+
+func BenchmarkGetEventsByMonth(b *testing.B) {
+	benchmarkQuery(b, func(c *Calendar, userID string) ([]Event, error) {
+		return c.GetEventsByMonth(userID, "2024-06-01", "")
+	})
+}
+
+func BenchmarkGetEventsInRange(b *testing.B) {
+	benchmarkQuery(b, func(c *Calendar, userID string) ([]Event, error) {
+		page, err := c.GetEventsInRange(userID, EventQuery{From: "2024-03-01", To: "2024-03-10"})
+		return page.Events, err
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	indexed, scanned := benchCalendars()
+	for _, bench := range []struct {
+		name    string
+		storage Storage
+	}{
+		{"indexed", indexed.storage},
+		{"linear", scanned.storage},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.storage.Get(fmt.Sprintf("%08d", (i*7919)%benchEvents)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package calendar
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zoneTransition is change of UTC offset of time zone
+type zoneTransition struct {
+	// at is first instant of new offset
+	at         time.Time
+	offsetFrom int
+	offsetTo   int
+	name       string
+	dst        bool
+}
+
+// zoneTransitions returns changes of offset of location within [from, to).
+// Offset is sampled daily, so changes undone within a day are missed
+func zoneTransitions(location *time.Location, from time.Time, to time.Time) []zoneTransition {
+	transitions := []zoneTransition{}
+	_, offset := from.In(location).Zone()
+	for t := from; t.Before(to); t = t.Add(24 * time.Hour) {
+		next := t.Add(24 * time.Hour)
+		if _, nextOffset := next.In(location).Zone(); nextOffset == offset {
+			continue
+		}
+		low, high := t, next
+		for high.Sub(low) > time.Second {
+			middle := low.Add(high.Sub(low) / 2)
+			if _, middleOffset := middle.In(location).Zone(); middleOffset == offset {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		local := high.In(location)
+		name, newOffset := local.Zone()
+		transitions = append(transitions, zoneTransition{at: high, offsetFrom: offset, offsetTo: newOffset, name: name, dst: local.IsDST()})
+		offset = newOffset
+	}
+	return transitions
+}
+
+// local returns wall clock time of transition before it happens, VTIMEZONE onsets use it
+func (z zoneTransition) local() time.Time {
+	return z.at.In(time.FixedZone("", z.offsetFrom))
+}
+
+// yearlyRule returns BYMONTH and BYDAY parts of RRULE matching transition every year,
+// like "BYMONTH=3;BYDAY=-1SU" for last Sunday of March
+func (z zoneTransition) yearlyRule(lastWeek bool) string {
+	local := z.local()
+	week := fmt.Sprint((local.Day()-1)/7 + 1)
+	if lastWeek {
+		week = "-1"
+	}
+	return fmt.Sprintf("BYMONTH=%d;BYDAY=%s%s", int(local.Month()), week, strings.ToUpper(local.Weekday().String()[:2]))
+}
+
+// isLastWeek reports whether transition happens on last such weekday of its month
+func (z zoneTransition) isLastWeek() bool {
+	local := z.local()
+	return local.AddDate(0, 0, 7).Month() != local.Month()
+}
+
+// repeats returns RRULE continuing transition every year if previous one happened a year
+// earlier on the same weekday of the same week of month at the same time, empty otherwise
+func (z zoneTransition) repeats(previous zoneTransition) string {
+	current, before := z.local(), previous.local()
+	if current.Year() != before.Year()+1 || current.Month() != before.Month() || current.Weekday() != before.Weekday() {
+		return ""
+	}
+	if current.Hour() != before.Hour() || current.Minute() != before.Minute() || current.Second() != before.Second() {
+		return ""
+	}
+	lastWeek := z.isLastWeek() && previous.isLastWeek()
+	if !lastWeek && (current.Day()-1)/7 != (before.Day()-1)/7 {
+		return ""
+	}
+	return "FREQ=YEARLY;" + z.yearlyRule(lastWeek)
+}
+
+// icalOffset formats UTC offset as +hhmm, seconds are added only if present
+func icalOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	if offset%60 != 0 {
+		return fmt.Sprintf("%c%02d%02d%02d", sign, offset/3600, offset/60%60, offset%60)
+	}
+	return fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)
+}
+
+// zoneYears is range of years time zone is used in
+type zoneYears struct {
+	first int
+	last  int
+}
+
+// writeVTimezones writes VTIMEZONE of every zone of timed events, so TZID of their times
+// is defined in feed itself. Offsets are listed for years events happen in and a year before.
+// Last yearly changes are continued by RRULE for recurring events going beyond them
+func writeVTimezones(w *icalWriter, events []Event) {
+	zones := map[string]zoneYears{}
+	for _, event := range events {
+		if event.allDay || event.timeZone == "" || event.timeZone == "UTC" {
+			continue
+		}
+		years, ok := zones[event.timeZone]
+		if !ok {
+			years = zoneYears{first: event.start.Year(), last: event.end.Year()}
+		}
+		years.first = min(years.first, event.start.Year())
+		years.last = max(years.last, event.end.Year())
+		zones[event.timeZone] = years
+	}
+
+	names := make([]string, 0, len(zones))
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		location, err := loadLocation(name)
+		if err != nil {
+			continue
+		}
+		years := zones[name]
+		from := time.Date(years.first-1, time.January, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(years.last+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+		w.line("BEGIN", "VTIMEZONE")
+		w.line("TZID", name)
+		start := from.In(location)
+		abbreviation, offset := start.Zone()
+		writeObservance(w, zoneTransition{at: from, offsetFrom: offset, offsetTo: offset, name: abbreviation, dst: start.IsDST()}, "")
+
+		transitions := zoneTransitions(location, from, to)
+		for i, transition := range transitions {
+			rule := ""
+			// Last two transitions are last changes to and from daylight saving time
+			if i >= len(transitions)-2 && i >= 2 {
+				rule = transition.repeats(transitions[i-2])
+			}
+			writeObservance(w, transition, rule)
+		}
+		w.line("END", "VTIMEZONE")
+	}
+}
+
+// writeObservance writes STANDARD or DAYLIGHT component starting at transition
+func writeObservance(w *icalWriter, transition zoneTransition, rule string) {
+	kind := "STANDARD"
+	if transition.dst {
+		kind = "DAYLIGHT"
+	}
+	w.line("BEGIN", kind)
+	w.line("DTSTART", transition.local().Format(icalDateTime))
+	w.line("TZOFFSETFROM", icalOffset(transition.offsetFrom))
+	w.line("TZOFFSETTO", icalOffset(transition.offsetTo))
+	if transition.name != "" {
+		w.line("TZNAME", icalEscaper.Replace(transition.name))
+	}
+	if rule != "" {
+		w.line("RRULE", rule)
+	}
+	w.line("END", kind)
+}
+
+// icalZone is time zone defined by VTIMEZONE of imported feed
+type icalZone struct {
+	observances []icalObservance
+}
+
+// icalObservance is STANDARD or DAYLIGHT component of VTIMEZONE
+type icalObservance struct {
+	// onset is first start of observance, wall clock is kept in UTC
+	onset  time.Time
+	offset int
+	// month, week and weekday are yearly rule of onsets like BYMONTH=3;BYDAY=-1SU, month is 0 without rule
+	month   time.Month
+	week    int
+	weekday time.Weekday
+}
+
+// parseICalOffset parses UTC offset like +0100 or -043000 into seconds
+func parseICalOffset(value string) (int, error) {
+	if (len(value) != 5 && len(value) != 7) || (value[0] != '+' && value[0] != '-') ||
+		strings.Trim(value[1:], "0123456789") != "" {
+		return 0, fmt.Errorf("Invalid UTC offset %q", value)
+	}
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i < len(value) {
+			part, _ := strconv.Atoi(value[1+2*i : 3+2*i])
+			seconds += part * unit
+		}
+	}
+	if value[0] == '-' {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+// parseYearlyRule reads month and nth weekday of RRULE like FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU.
+// Other rules are not supported, ok is false for them
+func parseYearlyRule(rule string) (month time.Month, week int, weekday time.Weekday, ok bool) {
+	var byDay string
+	for _, part := range strings.Split(rule, ";") {
+		key, value, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			if !strings.EqualFold(value, freqYearly) {
+				return 0, 0, 0, false
+			}
+		case "BYMONTH":
+			var number int
+			if _, err := fmt.Sscanf(value, "%d", &number); err != nil || number < 1 || number > 12 {
+				return 0, 0, 0, false
+			}
+			month = time.Month(number)
+		case "BYDAY":
+			byDay = strings.ToUpper(value)
+		}
+	}
+	if month == 0 || len(byDay) < 3 {
+		return 0, 0, 0, false
+	}
+	weekday, ok = weekdayCodes[byDay[len(byDay)-2:]]
+	if _, err := fmt.Sscanf(byDay[:len(byDay)-2], "%d", &week); err != nil || week == 0 || week < -5 || week > 5 {
+		return 0, 0, 0, false
+	}
+	return month, week, weekday, ok
+}
+
+// parseICalZone returns TZID and observances of VTIMEZONE, empty TZID if it is unusable
+func parseICalZone(properties []icalProperty, observances [][]icalProperty) (string, icalZone) {
+	var tzid string
+	for _, property := range properties {
+		if property.name == "TZID" {
+			tzid = property.value
+		}
+	}
+
+	zone := icalZone{}
+	for _, component := range observances {
+		observance := icalObservance{}
+		hasOnset, hasOffset := false, false
+		for _, property := range component {
+			var err error
+			switch property.name {
+			case "DTSTART":
+				observance.onset, err = time.Parse(icalDateTime, property.value)
+				hasOnset = err == nil
+			case "TZOFFSETTO":
+				observance.offset, err = parseICalOffset(property.value)
+				hasOffset = err == nil
+			case "RRULE":
+				if month, week, weekday, ok := parseYearlyRule(property.value); ok {
+					observance.month, observance.week, observance.weekday = month, week, weekday
+				}
+			}
+		}
+		if hasOnset && hasOffset {
+			zone.observances = append(zone.observances, observance)
+		}
+	}
+	if len(zone.observances) == 0 {
+		return "", icalZone{}
+	}
+	return tzid, zone
+}
+
+// onsetIn returns start of observance in year by its rule, zero time if it has no rule
+func (o icalObservance) onsetIn(year int) time.Time {
+	if o.month == 0 {
+		return time.Time{}
+	}
+	hour, minute, second := o.onset.Clock()
+	var day time.Time
+	if o.week > 0 {
+		day = time.Date(year, o.month, 1, hour, minute, second, 0, time.UTC)
+		day = day.AddDate(0, 0, (int(o.weekday)-int(day.Weekday())+7)%7+7*(o.week-1))
+	} else {
+		day = time.Date(year, o.month+1, 0, hour, minute, second, 0, time.UTC)
+		day = day.AddDate(0, 0, -(int(day.Weekday())-int(o.weekday)+7)%7+7*(o.week+1))
+	}
+	if day.Month() != o.month {
+		return time.Time{}
+	}
+	return day
+}
+
+// latestOnset returns last start of observance not after wall clock time, zero time if none
+func (o icalObservance) latestOnset(wall time.Time) time.Time {
+	if o.onset.After(wall) {
+		return time.Time{}
+	}
+	latest := o.onset
+	for _, year := range []int{wall.Year() - 1, wall.Year()} {
+		if onset := o.onsetIn(year); !onset.IsZero() && !onset.After(wall) && onset.After(latest) {
+			latest = onset
+		}
+	}
+	return latest
+}
+
+// location returns fixed zone of observance in effect at wall clock time. Times before
+// all observances get offset of the earliest one
+func (z icalZone) location(wall time.Time) *time.Location {
+	current := z.observances[0]
+	var currentOnset time.Time
+	for _, observance := range z.observances {
+		if observance.onset.Before(current.onset) && currentOnset.IsZero() {
+			current = observance
+		}
+		if onset := observance.latestOnset(wall); !onset.IsZero() && (currentOnset.IsZero() || onset.After(currentOnset)) {
+			current, currentOnset = observance, onset
+		}
+	}
+	return time.FixedZone("", current.offset)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,77 +11,175 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/venexene/calendar/auth"
+	"github.com/venexene/calendar/config"
 	"github.com/venexene/calendar/handlers"
 	"github.com/venexene/calendar/internal"
+	"github.com/venexene/calendar/notify"
+	"github.com/venexene/calendar/webhook"
 )
 
 func main() {
-	port := "8080"
-	if len(os.Args) > 1 {
-		port = os.Args[1]
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal(err)
 	}
+	level, _ := cfg.Level()
 
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+	// log package output goes through default slog logger, so all server logs are JSON
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(logger)
 
-	db := calendar.NewCalendar()
+	if !cfg.Debug {
+		gin.SetMode(gin.ReleaseMode)
+	}
 
-	router := gin.Default()
-	log.Printf("Created GIN router")
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	router.Use(handlers.CalendarMiddleware(db))
-	router.Use(handlers.LoggingMiddleware())
+	var storage calendar.Storage = calendar.NewMemoryStorage()
+	if cfg.Storage.Backend == config.StorageFile {
+		fileStorage, err := calendar.NewFileStorage(cfg.Storage.Path)
+		if err != nil {
+			log.Fatalf("Failed to open storage: %v", err)
+		}
+		storage = fileStorage
+		log.Printf("Using file storage %s", cfg.Storage.Path)
+	} else {
+		log.Printf("Using in-memory storage")
+	}
 
-	router.GET("/server_check", func(c *gin.Context) {
-		handlers.TestServerHandle(c)
-	})
+	db := calendar.NewCalendarWithStorage(storage)
+
+	var authenticator *auth.Authenticator
+	tokensPath := cfg.Auth.TokensFile
+	jwtSecret := cfg.Auth.JWTSecret
+	if cfg.Auth.Enabled() {
+		tokens := map[string]string{}
+		if tokensPath != "" {
+			loaded, err := auth.LoadTokens(tokensPath)
+			if err != nil {
+				log.Fatalf("Failed to load API tokens: %v", err)
+			}
+			tokens = loaded
+		}
+		created, err := auth.NewAuthenticator(tokens, []byte(jwtSecret))
+		if err != nil {
+			log.Fatalf("Failed to create authenticator: %v", err)
+		}
+		authenticator = created
+		log.Printf("Authentication enabled")
+	} else {
+		log.Printf("Authentication disabled, requests act for user_id they pass, listening on loopback only")
+	}
 
-	router.POST("/create_event", func(c *gin.Context) {
-		handlers.AddHandle(c)
-	})
+	var notifier notify.Notifier = notify.LogNotifier{}
+	if webhookURL := cfg.ReminderWebhook; webhookURL != "" {
+		notifier = notify.NewWebhookNotifier(webhookURL)
+		log.Printf("Reminders are posted to webhook %s", webhookURL)
+	} else {
+		log.Printf("Reminders are written to log")
+	}
 
-	router.POST("/update_event", func(c *gin.Context) {
-		handlers.UpdateHandle(c)
+	schedulerState := ""
+	if cfg.Storage.Backend == config.StorageFile {
+		schedulerState = cfg.Storage.Path + ".reminders"
+	}
+	scheduler, err := notify.NewScheduler(db, notifier, notify.SchedulerOptions{
+		StatePath:     schedulerState,
+		ShutdownGrace: time.Duration(cfg.ShutdownGrace),
 	})
+	if err != nil {
+		log.Fatalf("Failed to create reminder scheduler: %v", err)
+	}
 
-	router.POST("/delete_event", func(c *gin.Context) {
-		handlers.DeleteHandle(c)
-	})
+	schedulerDone := make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		scheduler.Run(ctx)
+	}()
+	log.Printf("Started reminder scheduler")
 
-	router.GET("/events_for_day", func(c *gin.Context) {
-		handlers.DayEventsHandle(c)
+	webhookState := ""
+	if cfg.Storage.Backend == config.StorageFile {
+		webhookState = cfg.Storage.Path + ".webhooks"
+	}
+	webhookNetworks, _ := cfg.WebhookNetworks()
+	dispatcher, err := webhook.NewDispatcher(webhook.Options{
+		AllowedNetworks: webhookNetworks,
+		StatePath:       webhookState,
 	})
+	if err != nil {
+		log.Fatalf("Failed to create webhook dispatcher: %v", err)
+	}
+	db.OnChange(dispatcher.Handle)
 
-	router.GET("/events_for_week", func(c *gin.Context) {
-		handlers.WeekEventsHandle(c)
-	})
+	var rateLimiter *handlers.RateLimiter
+	if cfg.RateLimit.RequestsPerSecond > 0 {
+		rateLimiter = handlers.NewRateLimiter(cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+		log.Printf("Rate limit is %g requests per second with bursts of %d", cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+	}
 
-	router.GET("/events_for_month", func(c *gin.Context) {
-		handlers.MonthEventsHandle(c)
+	router := handlers.NewRouter(db, handlers.Options{
+		Authenticator:  authenticator,
+		Webhooks:       dispatcher,
+		Logger:         logger,
+		Metrics:        handlers.NewMetrics(db),
+		CORSOrigins:    cfg.CORSOrigins,
+		RateLimiter:    rateLimiter,
+		TrustedProxies: cfg.TrustedProxies,
+		MaxBodyBytes:   cfg.MaxBodyBytes,
 	})
+	log.Printf("Created GIN router")
 
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: router,
+		Addr:         cfg.Listen,
+		Handler:      router,
+		ReadTimeout:  time.Duration(cfg.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.WriteTimeout),
 	}
+	// live feeds never finish on their own, so they are ended for shutdown to complete
+	srv.RegisterOnShutdown(db.Hub().Close)
 	log.Printf("Created server")
 
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLS.Enabled() {
+			err = srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("HTTP server error: %v", err)
 		}
 	}()
-	log.Printf("Started HTTP server on port %s", port)
+	if cfg.TLS.Enabled() {
+		log.Printf("Started HTTPS server on %s", cfg.Listen)
+	} else {
+		log.Printf("Started HTTP server on %s", cfg.Listen)
+	}
 
 	<-ctx.Done()
 	stop()
 	log.Println("Shutting down server...")
 
-	ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctxShutdown, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownGrace))
 	defer cancel()
 
 	if err := srv.Shutdown(ctxShutdown); err != nil {
 		log.Fatalf("Failed to shutdown server: %v", err)
 	}
 	log.Println("Shutdown server")
+
+	<-schedulerDone
+	log.Println("Stopped reminder scheduler")
+
+	dispatcher.Close()
+	log.Println("Stopped webhook deliveries")
+
+	if err := db.Close(); err != nil {
+		log.Fatalf("Failed to close storage: %v", err)
+	}
+	log.Println("Closed storage")
 }
@@ -0,0 +1,77 @@
+// Package notify delivers reminders of calendar events
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// Notifier delivers due reminder to its user.
+// Implementations must be safe for concurrent use
+type Notifier interface {
+	Notify(ctx context.Context, reminder calendar.Reminder) error
+}
+
+// LogNotifier writes reminders into log
+type LogNotifier struct {
+	// Logger receives reminders, standard logger if nil
+	Logger *log.Logger
+}
+
+// Notify writes reminder into log
+func (n LogNotifier) Notify(ctx context.Context, reminder calendar.Reminder) error {
+	logf := log.Printf
+	if n.Logger != nil {
+		logf = n.Logger.Printf
+	}
+	event := reminder.Event
+	logf("Reminder for user %s: %q starts at %s (%v before)",
+		event.UserID(), event.Text(), event.Start().Format(time.RFC3339), reminder.Before)
+	return nil
+}
+
+// WebhookNotifier posts reminders as JSON to URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates notifier posting reminders to given URL
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify posts reminder to webhook, any status except 2xx is an error
+func (n *WebhookNotifier) Notify(ctx context.Context, reminder calendar.Reminder) error {
+	body, err := json.Marshal(reminder)
+	if err != nil {
+		return fmt.Errorf("Failed to encode reminder: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
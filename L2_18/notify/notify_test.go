@@ -0,0 +1,224 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// recordNotifier keeps delivered reminders
+type recordNotifier struct {
+	mu    sync.Mutex
+	fired []string
+}
+
+func (n *recordNotifier) Notify(ctx context.Context, reminder calendar.Reminder) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.fired = append(n.fired, fmt.Sprintf("%s@%s", reminder.Event.Text(), reminder.At.UTC().Format("15:04")))
+	return nil
+}
+
+func (n *recordNotifier) list() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.fired...)
+}
+
+func TestSchedulerRestart(t *testing.T) {
+	c := calendar.NewCalendar()
+	if _, err := c.Add("u1", calendar.EventData{Start: "2024-01-10T09:00:00Z", Text: "meeting", Reminders: []string{"15m", "1h"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	statePath := filepath.Join(t.TempDir(), "reminders.state")
+	base := time.Date(2024, 1, 10, 7, 0, 0, 0, time.UTC)
+
+	notifier := &recordNotifier{}
+	scheduler, err := NewScheduler(c, notifier, SchedulerOptions{StatePath: statePath})
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	scheduler.fired = base
+	for _, minutes := range []int{30, 60, 90, 100} {
+		if err := scheduler.fire(context.Background(), base.Add(time.Duration(minutes)*time.Minute)); err != nil {
+			t.Fatalf("fire() error = %v", err)
+		}
+	}
+	if got := fmt.Sprint(notifier.list()); got != "[meeting@08:00]" {
+		t.Errorf("fired before restart = %s, want [meeting@08:00]", got)
+	}
+
+	restarted, err := NewScheduler(c, notifier, SchedulerOptions{StatePath: statePath})
+	if err != nil {
+		t.Fatalf("NewScheduler() after restart error = %v", err)
+	}
+	if stopped := base.Add(100 * time.Minute); !restarted.fired.Equal(stopped) {
+		t.Fatalf("restored state = %v, want %v", restarted.fired, stopped)
+	}
+	// reminder at 08:45 was missed while service was down, 08:00 must not fire again
+	if err := restarted.fire(context.Background(), base.Add(3*time.Hour)); err != nil {
+		t.Fatalf("fire() error = %v", err)
+	}
+	if got := fmt.Sprint(notifier.list()); got != "[meeting@08:00 meeting@08:45]" {
+		t.Errorf("fired after restart = %s, want [meeting@08:00 meeting@08:45]", got)
+	}
+}
+
+func TestSchedulerStops(t *testing.T) {
+	c := calendar.NewCalendar()
+	start := time.Now().Add(50 * time.Millisecond).UTC().Format(time.RFC3339Nano)
+	if _, err := c.Add("u1", calendar.EventData{Start: start, Text: "soon", Reminders: []string{"0m"}}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	notifier := &recordNotifier{}
+	scheduler, err := NewScheduler(c, notifier, SchedulerOptions{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(notifier.list()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not stop after cancellation")
+	}
+	if got := notifier.list(); len(got) != 1 {
+		t.Errorf("fired = %v, want single reminder", got)
+	}
+}
+
+// blockingNotifier delivers reminders only when context is done, like unreachable webhook
+type blockingNotifier struct {
+	recordNotifier
+	started chan struct{}
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, reminder calendar.Reminder) error {
+	select {
+	case n.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	n.recordNotifier.Notify(ctx, reminder)
+	return ctx.Err()
+}
+
+func TestSchedulerShutdownGrace(t *testing.T) {
+	c := calendar.NewCalendar()
+	for i := 0; i < 5; i++ {
+		if _, err := c.Add("u1", calendar.EventData{Start: "2024-01-10T09:00:00Z", Text: fmt.Sprint("meeting ", i), Reminders: []string{"15m"}}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	notifier := &blockingNotifier{started: make(chan struct{}, 1)}
+	scheduler, err := NewScheduler(c, notifier, SchedulerOptions{ShutdownGrace: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	scheduler.fired = time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scheduler.Run(ctx)
+	}()
+	<-notifier.started
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not stop after shutdown grace")
+	}
+	if got := notifier.list(); len(got) != 1 {
+		t.Errorf("attempted = %v, want rest of batch dropped", got)
+	}
+}
+
+func TestSchedulerMissedCap(t *testing.T) {
+	c := calendar.NewCalendar()
+	for hour := 1; hour <= 5; hour++ {
+		start := fmt.Sprintf("2024-01-10T%02d:00:00Z", hour)
+		if _, err := c.Add("u1", calendar.EventData{Start: start, Text: "meeting", Reminders: []string{"0m"}}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	notifier := &recordNotifier{}
+	scheduler, err := NewScheduler(c, notifier, SchedulerOptions{MaxMissed: 2})
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	scheduler.fired = time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	if err := scheduler.fire(context.Background(), time.Now()); err != nil {
+		t.Fatalf("fire() error = %v", err)
+	}
+	if got := fmt.Sprint(notifier.list()); got != "[meeting@04:00 meeting@05:00]" {
+		t.Errorf("fired missed reminders = %s, want latest two", got)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received struct {
+		Event struct {
+			ID   string `json:"id"`
+			Text string `json:"text"`
+		} `json:"event"`
+		Before string `json:"before"`
+		At     string `json:"at"`
+	}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("webhook body: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	c := calendar.NewCalendar()
+	id, err := c.Add("u1", calendar.EventData{Start: "2024-01-10T09:00:00Z", Text: "meeting", Reminders: []string{"1d"}})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	due, err := c.DueReminders(time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(due) != 1 {
+		t.Fatalf("DueReminders() = %v, %v", due, err)
+	}
+
+	notifier := NewWebhookNotifier(server.URL)
+	if err := notifier.Notify(context.Background(), due[0]); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	if received.Event.ID != id || received.Event.Text != "meeting" || received.Before != "1d" || received.At != "2024-01-09T09:00:00Z" {
+		t.Errorf("webhook received %+v", received)
+	}
+
+	status = http.StatusInternalServerError
+	if err := notifier.Notify(context.Background(), due[0]); err == nil {
+		t.Error("Notify() error = nil for failed webhook")
+	}
+}
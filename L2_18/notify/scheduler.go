@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/venexene/calendar/internal"
+)
+
+const (
+	defaultInterval      = 30 * time.Second
+	defaultShutdownGrace = 5 * time.Second
+	defaultMaxMissed     = 100
+)
+
+// SchedulerOptions configures Scheduler
+type SchedulerOptions struct {
+	// Interval is period of checking due reminders, 30 seconds by default
+	Interval time.Duration
+	// StatePath is file keeping time up to which reminders were fired, so they are not fired
+	// again after restart. Reminders missed while service was down are fired on start.
+	// State is kept in memory only if empty
+	StatePath string
+	// ShutdownGrace is how long reminders being delivered on cancellation may still be sent,
+	// 5 seconds by default. Reminders left when it passes are dropped
+	ShutdownGrace time.Duration
+	// MaxMissed caps number of reminders missed while service was down that are fired on start,
+	// the latest ones are kept. 100 by default
+	MaxMissed int
+}
+
+// Scheduler periodically fires due reminders of calendar through notifier
+type Scheduler struct {
+	calendar  *calendar.Calendar
+	notifier  Notifier
+	interval  time.Duration
+	statePath string
+	grace     time.Duration
+	maxMissed int
+	// fired is time up to which reminders were fired
+	fired time.Time
+	// started is creation time of scheduler, reminders due before it were missed
+	started time.Time
+}
+
+// NewScheduler creates scheduler firing reminders due after now or after time saved in state file
+func NewScheduler(calendarDB *calendar.Calendar, notifier Notifier, options SchedulerOptions) (*Scheduler, error) {
+	s := &Scheduler{
+		calendar:  calendarDB,
+		notifier:  notifier,
+		interval:  options.Interval,
+		statePath: options.StatePath,
+		grace:     options.ShutdownGrace,
+		maxMissed: options.MaxMissed,
+		fired:     time.Now(),
+	}
+	s.started = s.fired
+	if s.interval <= 0 {
+		s.interval = defaultInterval
+	}
+	if s.grace <= 0 {
+		s.grace = defaultShutdownGrace
+	}
+	if s.maxMissed <= 0 {
+		s.maxMissed = defaultMaxMissed
+	}
+
+	if s.statePath != "" {
+		data, err := os.ReadFile(s.statePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("Failed to read scheduler state: %w", err)
+		default:
+			fired, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid scheduler state in %s: %w", s.statePath, err)
+			}
+			s.fired = fired
+		}
+	}
+	return s, nil
+}
+
+// Run fires reminders until context is cancelled.
+// Batch of reminders being delivered on cancellation is continued for ShutdownGrace at most
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		delivery, cancel := s.deliveryContext(ctx)
+		err := s.fire(delivery, time.Now())
+		cancel()
+		if err != nil {
+			log.Printf("Reminder scheduler error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliveryContext returns context of delivering batch of reminders. It outlives cancellation
+// of ctx by grace, so batch is not cut off at once but doesnt hold up shutdown for long
+func (s *Scheduler) deliveryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	delivery, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(s.grace, cancel)
+	})
+	return delivery, func() {
+		stop()
+		cancel()
+	}
+}
+
+// fire delivers reminders due within [fired, now). Of reminders missed while service was down
+// only MaxMissed latest ones are delivered, delivery stops when ctx is done.
+// State is saved before delivery, so failed delivery is not repeated rather than doubled
+func (s *Scheduler) fire(ctx context.Context, now time.Time) error {
+	if !now.After(s.fired) {
+		return nil
+	}
+
+	due, err := s.calendar.DueReminders(s.fired, now)
+	if err != nil {
+		return fmt.Errorf("Failed to get due reminders: %w", err)
+	}
+	if err := s.save(now); err != nil {
+		return err
+	}
+	s.fired = now
+
+	missed := 0
+	for missed < len(due) && due[missed].At.Before(s.started) {
+		missed++
+	}
+	if missed > s.maxMissed {
+		log.Printf("Skipped %d oldest of %d reminders missed while service was down", missed-s.maxMissed, missed)
+		due = due[missed-s.maxMissed:]
+	}
+
+	for i, reminder := range due {
+		if ctx.Err() != nil {
+			log.Printf("Dropped %d reminders not delivered before shutdown", len(due)-i)
+			break
+		}
+		if err := s.notifier.Notify(ctx, reminder); err != nil {
+			log.Printf("Failed to deliver reminder of event %s: %v", reminder.Event.ID(), err)
+		}
+	}
+	return nil
+}
+
+// save atomically replaces state file with given time
+func (s *Scheduler) save(fired time.Time) error {
+	if s.statePath == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.statePath), filepath.Base(s.statePath)+".*")
+	if err != nil {
+		return fmt.Errorf("Failed to save scheduler state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(fired.UTC().Format(time.RFC3339Nano) + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to save scheduler state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to save scheduler state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to save scheduler state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.statePath); err != nil {
+		return fmt.Errorf("Failed to save scheduler state: %w", err)
+	}
+	return nil
+}
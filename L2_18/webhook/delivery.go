@@ -0,0 +1,267 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// Headers of webhook requests
+const (
+	// SignatureHeader is "sha256=" and hex HMAC-SHA256 of timestamp, "." and body keyed by webhook secret
+	SignatureHeader = "X-Calendar-Signature"
+	// TimestampHeader is Unix time of attempt, receivers should reject stale ones
+	TimestampHeader = "X-Calendar-Timestamp"
+	DeliveryHeader  = "X-Calendar-Delivery"
+	EventHeader     = "X-Calendar-Event"
+)
+
+// payload is JSON body of webhook request
+type payload struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	OccurredAt string         `json:"occurred_at"`
+	Event      calendar.Event `json:"event"`
+}
+
+// Sign returns signature of payload sent at timestamp, receivers compare it with SignatureHeader
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sortSubscriptions(subscriptions []Subscription) {
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if !subscriptions[i].CreatedAt.Equal(subscriptions[j].CreatedAt) {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		}
+		return subscriptions[i].ID < subscriptions[j].ID
+	})
+}
+
+// newDelivery adds pending delivery of change into log, dispatcher must be locked
+func (h *webhook) newDelivery(change calendar.Change) (*Delivery, []byte, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, nil, err
+	}
+	eventType := "event." + string(change.Type)
+	body, err := json.Marshal(payload{
+		ID:         id,
+		Type:       eventType,
+		OccurredAt: change.Time.UTC().Format(time.RFC3339Nano),
+		Event:      change.Event,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	delivery := &Delivery{
+		ID:        id,
+		WebhookID: h.ID,
+		Type:      eventType,
+		EventID:   change.Event.ID(),
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	h.deliveries = append(h.deliveries, delivery)
+	if len(h.deliveries) > deliveryLogSize {
+		h.deliveries = h.deliveries[len(h.deliveries)-deliveryLogSize:]
+	}
+	return delivery, body, nil
+}
+
+// delay returns pause before retry following given attempt
+func (d *Dispatcher) delay(attempt int) time.Duration {
+	delay := d.options.BaseDelay
+	for i := 1; i < attempt && delay < d.options.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.options.MaxDelay)
+}
+
+// work sends deliveries of ready webhooks until dispatcher is closed. Webhook is taken by
+// single worker at a time, so its deliveries keep order of changes
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		for len(d.ready) == 0 && !d.closed {
+			d.cond.Wait()
+		}
+		if d.closed {
+			return
+		}
+
+		hook := d.ready[0]
+		d.ready = d.ready[1:]
+		if len(hook.queue) == 0 {
+			hook.busy = false
+			continue
+		}
+		next := hook.queue[0]
+
+		d.mu.Unlock()
+		statusCode, err := d.send(hook, next.delivery, next.body)
+		d.mu.Lock()
+
+		d.record(hook, next.delivery, statusCode, err)
+	}
+}
+
+// record saves outcome of attempt and schedules next delivery of webhook or retry of this one.
+// Dispatcher must be locked
+func (d *Dispatcher) record(hook *webhook, delivery *Delivery, statusCode int, err error) {
+	retry := err != nil && !errors.Is(err, errBlockedAddress) &&
+		(statusCode == 0 || statusCode == http.StatusTooManyRequests || statusCode >= 500)
+	_, registered := d.webhooks[hook.ID]
+
+	delivery.Attempts++
+	delivery.StatusCode = statusCode
+	delivery.UpdatedAt = time.Now().UTC()
+	delivery.NextAttempt = nil
+	switch {
+	case err == nil:
+		delivery.Status = StatusDelivered
+		delivery.Error = ""
+	case !retry || !registered || delivery.Attempts >= d.options.MaxAttempts:
+		delivery.Status = StatusFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Error = err.Error()
+		delay := d.delay(delivery.Attempts)
+		next := delivery.UpdatedAt.Add(delay)
+		delivery.NextAttempt = &next
+		hook.retry = time.AfterFunc(delay, func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			hook.retry = nil
+			d.ready = append(d.ready, hook)
+			d.cond.Signal()
+		})
+		return
+	}
+
+	if len(hook.queue) != 0 {
+		hook.queue = hook.queue[1:]
+	}
+	if len(hook.queue) == 0 {
+		hook.queue = nil
+		hook.busy = false
+		return
+	}
+	d.ready = append(d.ready, hook)
+}
+
+// send makes single attempt and returns response status, error is set for non-2xx responses
+func (d *Dispatcher) send(hook *webhook, delivery *Delivery, body []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	req.Header.Set(EventHeader, delivery.Type)
+
+	resp, err := d.options.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("Webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// load reads webhooks saved in state file
+func (d *Dispatcher) load() error {
+	if d.options.StatePath == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(d.options.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read webhooks: %w", err)
+	}
+	var subscriptions []Subscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		return fmt.Errorf("Invalid webhooks in %s: %w", d.options.StatePath, err)
+	}
+	for _, subscription := range subscriptions {
+		events := map[calendar.ChangeType]bool{}
+		for _, kind := range subscription.Events {
+			events[kind] = true
+		}
+		d.webhooks[subscription.ID] = &webhook{Subscription: subscription, events: events}
+	}
+	return nil
+}
+
+// save replaces state file with current webhooks, dispatcher must be locked.
+// File is readable only by owner as it keeps secrets
+func (d *Dispatcher) save() error {
+	if d.options.StatePath == "" {
+		return nil
+	}
+
+	subscriptions := make([]Subscription, 0, len(d.webhooks))
+	for _, hook := range d.webhooks {
+		subscriptions = append(subscriptions, hook.Subscription)
+	}
+	sortSubscriptions(subscriptions)
+	data, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to save webhooks: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(d.options.StatePath), filepath.Base(d.options.StatePath)+".*")
+	if err != nil {
+		return fmt.Errorf("Failed to save webhooks: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to save webhooks: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Failed to save webhooks: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("Failed to save webhooks: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), d.options.StatePath); err != nil {
+		return fmt.Errorf("Failed to save webhooks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// errBlockedAddress is returned when webhook resolves to internal address, such attempts are not retried
+var errBlockedAddress = errors.New("Webhook address is not allowed")
+
+// internalAddress reports whether address belongs to host or its private network:
+// loopback, RFC 1918 and unique local, link-local or unspecified
+func internalAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsUnspecified()
+}
+
+// allowed reports whether webhooks may be sent to address
+func (o Options) allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !internalAddress(addr) {
+		return true
+	}
+	for _, network := range o.AllowedNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost rejects hosts of webhook URL naming internal addresses. Names are resolved only
+// when payload is sent, so their addresses are checked by dialer of default client
+func (o Options) checkHost(host string) error {
+	address := host
+	if lower := strings.ToLower(strings.TrimSuffix(host, ".")); lower == "localhost" || strings.HasSuffix(lower, ".localhost") {
+		address = "127.0.0.1"
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return nil
+	}
+	if !o.allowed(addr) {
+		return &calendar.ValidationError{Err: fmt.Errorf("Webhook host %s is internal address, it is not allowed", host)}
+	}
+	return nil
+}
+
+// newClient returns client refusing to connect to internal addresses not allowed by options.
+// Proxies are not used, so address of webhook itself is checked
+func newClient(options Options) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !options.allowed(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errBlockedAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
@@ -0,0 +1,352 @@
+// Package webhook delivers changes of calendar events to subscribed external systems
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/venexene/calendar/internal"
+)
+
+const (
+	defaultMaxAttempts = 6
+	defaultBaseDelay   = time.Second
+	defaultMaxDelay    = 5 * time.Minute
+	defaultWorkers     = 8
+	// deliveryLogSize is number of latest deliveries kept per webhook
+	deliveryLogSize = 100
+	// maxQueued is number of deliveries waiting for webhook, newer ones fail at once
+	maxQueued = 1000
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// ErrNotFound is returned when webhook does not exist or belongs to other user
+var ErrNotFound = errors.New("Webhook not found")
+
+// Subscription is webhook of user receiving changes of user events
+type Subscription struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	URL    string `json:"url"`
+	// Events are kinds of changes sent to webhook
+	Events []calendar.ChangeType `json:"events"`
+	// Secret signs payloads, it is shown only on registration
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is attempt to send single change to webhook
+type Delivery struct {
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	// Type is kind of change like "event.created"
+	Type        string     `json:"type"`
+	EventID     string     `json:"event_id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"status_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+}
+
+// Options configures Dispatcher
+type Options struct {
+	// MaxAttempts is number of tries of every delivery, 6 by default
+	MaxAttempts int
+	// BaseDelay is delay before first retry, doubled for every next one, 1 second by default
+	BaseDelay time.Duration
+	// MaxDelay caps delay between retries, 5 minutes by default
+	MaxDelay time.Duration
+	// Client sends payloads, client with 10 seconds timeout refusing internal addresses by default.
+	// Custom client has to check addresses it connects to itself
+	Client *http.Client
+	// AllowedNetworks are loopback, private or link-local networks webhooks may still be sent to,
+	// such addresses are rejected otherwise
+	AllowedNetworks []netip.Prefix
+	// Workers is number of deliveries sent at once, 8 by default
+	Workers int
+	// StatePath is JSON file keeping webhooks across restarts, they are kept in memory only if empty.
+	// Logs of deliveries are not saved, deliveries waiting on shutdown are lost
+	StatePath string
+}
+
+// queued is delivery waiting in queue of webhook with its payload
+type queued struct {
+	delivery *Delivery
+	body     []byte
+}
+
+// webhook is registered subscription with log of its deliveries
+type webhook struct {
+	Subscription
+	events     map[calendar.ChangeType]bool
+	deliveries []*Delivery
+	// queue keeps deliveries in order of changes, only its head is being sent
+	queue []queued
+	// busy is set while webhook waits in ready list, is being sent to or waits for retry
+	busy  bool
+	retry *time.Timer
+}
+
+// Dispatcher keeps webhooks in memory and delivers event changes to them.
+// Deliveries of every webhook are sent one by one in order of changes by fixed number of workers
+type Dispatcher struct {
+	mu       sync.Mutex
+	webhooks map[string]*webhook
+	options  Options
+	// ready are webhooks with queued deliveries waiting for worker
+	ready  []*webhook
+	cond   *sync.Cond
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+// NewDispatcher creates dispatcher with webhooks saved in state file
+func NewDispatcher(options Options) (*Dispatcher, error) {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultMaxAttempts
+	}
+	if options.BaseDelay <= 0 {
+		options.BaseDelay = defaultBaseDelay
+	}
+	if options.MaxDelay <= 0 {
+		options.MaxDelay = defaultMaxDelay
+	}
+	if options.Client == nil {
+		options.Client = newClient(options)
+	}
+	if options.Workers <= 0 {
+		options.Workers = defaultWorkers
+	}
+	d := &Dispatcher{
+		webhooks: map[string]*webhook{},
+		options:  options,
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	d.cond = sync.NewCond(&d.mu)
+	for i := 0; i < options.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d, nil
+}
+
+func newID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("Failed to generate ID: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Register adds webhook of user receiving given kinds of changes, all kinds if none given.
+// URLs of loopback, private and link-local hosts are rejected unless their network is allowed
+func (d *Dispatcher) Register(userID string, target string, events []string) (Subscription, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return Subscription{}, &calendar.ValidationError{Err: fmt.Errorf("Invalid webhook URL %q: absolute http or https URL expected", target)}
+	}
+	if err := d.options.checkHost(parsed.Hostname()); err != nil {
+		return Subscription{}, err
+	}
+
+	kinds := map[calendar.ChangeType]bool{}
+	subscribed := []calendar.ChangeType{}
+	for _, event := range events {
+		kind := calendar.ChangeType(event)
+		switch kind {
+		case calendar.EventCreated, calendar.EventUpdated, calendar.EventDeleted:
+		default:
+			return Subscription{}, &calendar.ValidationError{Err: fmt.Errorf("Unknown webhook event %q: expected created, updated or deleted", event)}
+		}
+		if !kinds[kind] {
+			kinds[kind] = true
+			subscribed = append(subscribed, kind)
+		}
+	}
+	if len(subscribed) == 0 {
+		subscribed = []calendar.ChangeType{calendar.EventCreated, calendar.EventUpdated, calendar.EventDeleted}
+		for _, kind := range subscribed {
+			kinds[kind] = true
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return Subscription{}, err
+	}
+	secret, err := newID()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	hook := &webhook{
+		Subscription: Subscription{
+			ID:        id,
+			UserID:    userID,
+			URL:       parsed.String(),
+			Events:    subscribed,
+			Secret:    secret,
+			CreatedAt: time.Now().UTC(),
+		},
+		events: kinds,
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.webhooks[id] = hook
+	if err := d.save(); err != nil {
+		delete(d.webhooks, id)
+		return Subscription{}, err
+	}
+	return hook.Subscription, nil
+}
+
+// find returns webhook by ID if it belongs to user, dispatcher must be locked
+func (d *Dispatcher) find(userID string, id string) (*webhook, error) {
+	hook, ok := d.webhooks[id]
+	if !ok || hook.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return hook, nil
+}
+
+// Subscriptions returns webhooks of user without secrets ordered by creation
+func (d *Dispatcher) Subscriptions(userID string) []Subscription {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	found := []Subscription{}
+	for _, hook := range d.webhooks {
+		if hook.UserID == userID {
+			subscription := hook.Subscription
+			subscription.Secret = ""
+			found = append(found, subscription)
+		}
+	}
+	sortSubscriptions(found)
+	return found
+}
+
+// Unregister removes webhook of user, its pending retries are dropped
+func (d *Dispatcher) Unregister(userID string, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hook, err := d.find(userID, id)
+	if err != nil {
+		return err
+	}
+	delete(d.webhooks, id)
+	if err := d.save(); err != nil {
+		d.webhooks[id] = hook
+		return err
+	}
+	hook.queue = nil
+	if hook.retry != nil && hook.retry.Stop() {
+		hook.retry = nil
+		hook.busy = false
+	}
+	return nil
+}
+
+// Deliveries returns latest deliveries of webhook, newest first
+func (d *Dispatcher) Deliveries(userID string, id string) ([]Delivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hook, err := d.find(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(hook.deliveries))
+	for i := len(hook.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *hook.deliveries[i])
+	}
+	return deliveries, nil
+}
+
+// Handle queues change for webhooks subscribed to it of users who can read event, the same
+// users live streams publish it to. It does not block, so it may be registered by Calendar.OnChange
+func (d *Dispatcher) Handle(change calendar.Change) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return
+	}
+
+	audience := make(map[string]bool, len(change.Audience))
+	for _, userID := range change.Audience {
+		audience[userID] = true
+	}
+	for _, hook := range d.webhooks {
+		if !audience[hook.UserID] || !hook.events[change.Type] {
+			continue
+		}
+
+		delivery, body, err := hook.newDelivery(change)
+		if err != nil {
+			continue
+		}
+		if len(hook.queue) >= maxQueued {
+			delivery.Status = StatusFailed
+			delivery.Error = "Too many deliveries are waiting for webhook"
+			continue
+		}
+		hook.queue = append(hook.queue, queued{delivery: delivery, body: body})
+		if !hook.busy {
+			hook.busy = true
+			d.ready = append(d.ready, hook)
+			d.cond.Signal()
+		}
+	}
+}
+
+// Close stops workers after running attempts, deliveries still waiting fail
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, hook := range d.webhooks {
+		if hook.retry != nil {
+			hook.retry.Stop()
+			hook.retry = nil
+		}
+		for _, item := range hook.queue {
+			item.delivery.Status = StatusFailed
+			item.delivery.NextAttempt = nil
+			if item.delivery.Attempts == 0 {
+				item.delivery.Error = "Delivery stopped on shutdown"
+			} else {
+				item.delivery.Error += " (retries stopped on shutdown)"
+			}
+		}
+		hook.queue = nil
+	}
+}
@@ -0,0 +1,398 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/venexene/calendar/internal"
+)
+
+// loopback lets tests deliver to local test servers
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+// newDispatcher creates dispatcher closed at the end of test
+func newDispatcher(t *testing.T, options Options) *Dispatcher {
+	t.Helper()
+	d, err := NewDispatcher(options)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	t.Cleanup(d.Close)
+	return d
+}
+
+// receiver is webhook endpoint answering with scripted statuses and recording accepted payloads
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	calls    int
+	received []string
+	errors   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	if want := Sign(r.secret, req.Header.Get(TimestampHeader), body); req.Header.Get(SignatureHeader) != want {
+		r.errors = append(r.errors, "bad signature")
+	}
+
+	status := http.StatusOK
+	if r.calls < len(r.statuses) {
+		status = r.statuses[r.calls]
+	}
+	r.calls++
+	if status == http.StatusOK {
+		var p struct {
+			ID    string `json:"id"`
+			Type  string `json:"type"`
+			Event struct {
+				Text string `json:"text"`
+			} `json:"event"`
+		}
+		json.Unmarshal(body, &p)
+		if p.ID != req.Header.Get(DeliveryHeader) || p.Type != req.Header.Get(EventHeader) {
+			r.errors = append(r.errors, "headers do not match payload")
+		}
+		r.received = append(r.received, p.Type+":"+p.Event.Text)
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) snapshot() ([]string, []string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.received...), append([]string(nil), r.errors...), r.calls
+}
+
+// waitDeliveries waits until no delivery of webhook is pending
+func waitDeliveries(t *testing.T, d *Dispatcher, userID string, id string, count int) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := d.Deliveries(userID, id)
+		if err != nil {
+			t.Fatalf("Deliveries() error = %v", err)
+		}
+		done := len(deliveries) == count
+		for _, delivery := range deliveries {
+			if delivery.Status == StatusPending {
+				done = false
+			}
+		}
+		if done {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("deliveries not finished: %+v", deliveries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDispatcherDelivers(t *testing.T) {
+	c := calendar.NewCalendar()
+	d := newDispatcher(t, Options{BaseDelay: time.Millisecond, AllowedNetworks: loopback})
+	c.OnChange(d.Handle)
+
+	all := &receiver{}
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+	deleted := &receiver{}
+	deletedServer := httptest.NewServer(deleted)
+	defer deletedServer.Close()
+
+	allHook, err := d.Register("u1", allServer.URL, nil)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	all.secret = allHook.Secret
+	deletedHook, err := d.Register("u1", deletedServer.URL, []string{"deleted"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	deleted.secret = deletedHook.Secret
+
+	id, err := c.Add("u1", calendar.EventData{Date: "2024-01-10", Text: "meeting"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	waitDeliveries(t, d, "u1", allHook.ID, 1)
+	if err := c.Update("u1", id, calendar.EventData{Text: "standup"}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	waitDeliveries(t, d, "u1", allHook.ID, 2)
+	if err := c.Delete("u1", id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := c.Add("u2", calendar.EventData{Date: "2024-01-10", Text: "other user"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	deliveries := waitDeliveries(t, d, "u1", allHook.ID, 3)
+	waitDeliveries(t, d, "u1", deletedHook.ID, 1)
+
+	received, errs, _ := all.snapshot()
+	if fmt.Sprint(received) != "[event.created:meeting event.updated:standup event.deleted:standup]" || len(errs) != 0 {
+		t.Errorf("all events webhook received %v, errors %v", received, errs)
+	}
+	received, errs, _ = deleted.snapshot()
+	if fmt.Sprint(received) != "[event.deleted:standup]" || len(errs) != 0 {
+		t.Errorf("deleted events webhook received %v, errors %v", received, errs)
+	}
+	if deliveries[0].Type != "event.deleted" || deliveries[0].Status != StatusDelivered || deliveries[0].Attempts != 1 || deliveries[0].EventID != id {
+		t.Errorf("latest delivery = %+v", deliveries[0])
+	}
+}
+
+func TestDispatcherSharedCalendar(t *testing.T) {
+	c := calendar.NewCalendar()
+	d := newDispatcher(t, Options{BaseDelay: time.Millisecond, AllowedNetworks: loopback})
+	c.OnChange(d.Handle)
+
+	shared := &receiver{}
+	sharedServer := httptest.NewServer(shared)
+	defer sharedServer.Close()
+	stranger := &receiver{}
+	strangerServer := httptest.NewServer(stranger)
+	defer strangerServer.Close()
+
+	sharedHook, err := d.Register("u2", sharedServer.URL, nil)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	shared.secret = sharedHook.Secret
+	strangerHook, err := d.Register("u3", strangerServer.URL, nil)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	stranger.secret = strangerHook.Secret
+
+	work, err := c.CreateCollection("u1", "work")
+	if err != nil {
+		t.Fatalf("CreateCollection() error = %v", err)
+	}
+	if _, err := c.ShareCollection("u1", work.ID, "u2", calendar.AccessRead); err != nil {
+		t.Fatalf("ShareCollection() error = %v", err)
+	}
+	if _, err := c.Add("u1", calendar.EventData{Calendar: work.ID, Date: "2024-01-10", Text: "review"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u1", calendar.EventData{Date: "2024-01-10", Text: "private"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, err := c.Add("u3", calendar.EventData{Date: "2024-01-10", Text: "own"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	waitDeliveries(t, d, "u2", sharedHook.ID, 1)
+	waitDeliveries(t, d, "u3", strangerHook.ID, 1)
+	received, errs, _ := shared.snapshot()
+	if fmt.Sprint(received) != "[event.created:review]" || len(errs) != 0 {
+		t.Errorf("webhook of shared calendar reader received %v, errors %v", received, errs)
+	}
+	received, errs, _ = stranger.snapshot()
+	if fmt.Sprint(received) != "[event.created:own]" || len(errs) != 0 {
+		t.Errorf("webhook of other user received %v, errors %v", received, errs)
+	}
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	c := calendar.NewCalendar()
+	d := newDispatcher(t, Options{BaseDelay: time.Millisecond, MaxAttempts: 3, AllowedNetworks: loopback})
+	c.OnChange(d.Handle)
+
+	flaky := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	flakyServer := httptest.NewServer(flaky)
+	defer flakyServer.Close()
+	broken := &receiver{statuses: []int{500, 500, 500, 500}}
+	brokenServer := httptest.NewServer(broken)
+	defer brokenServer.Close()
+	rejecting := &receiver{statuses: []int{http.StatusBadRequest}}
+	rejectingServer := httptest.NewServer(rejecting)
+	defer rejectingServer.Close()
+
+	hooks := map[*receiver]Subscription{}
+	for r, url := range map[*receiver]string{flaky: flakyServer.URL, broken: brokenServer.URL, rejecting: rejectingServer.URL} {
+		hook, err := d.Register("u1", url, nil)
+		if err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		r.secret = hook.Secret
+		hooks[r] = hook
+	}
+
+	if _, err := c.Add("u1", calendar.EventData{Date: "2024-01-10", Text: "meeting"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		receiver *receiver
+		status   string
+		attempts int
+		code     int
+	}{
+		{"flaky", flaky, StatusDelivered, 3, http.StatusOK},
+		{"broken", broken, StatusFailed, 3, http.StatusInternalServerError},
+		{"rejecting", rejecting, StatusFailed, 1, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		delivery := waitDeliveries(t, d, "u1", hooks[tt.receiver].ID, 1)[0]
+		if delivery.Status != tt.status || delivery.Attempts != tt.attempts || delivery.StatusCode != tt.code {
+			t.Errorf("%s delivery = %+v", tt.name, delivery)
+		}
+		if _, errs, calls := tt.receiver.snapshot(); calls != tt.attempts || len(errs) != 0 {
+			t.Errorf("%s webhook called %d times, errors %v", tt.name, calls, errs)
+		}
+	}
+}
+
+func TestDispatcherOrder(t *testing.T) {
+	c := calendar.NewCalendar()
+	d := newDispatcher(t, Options{BaseDelay: time.Millisecond, Workers: 2, AllowedNetworks: loopback})
+	c.OnChange(d.Handle)
+
+	receivers := []*receiver{
+		{statuses: []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusServiceUnavailable}},
+		{},
+		{},
+	}
+	hooks := []Subscription{}
+	for _, r := range receivers {
+		server := httptest.NewServer(r)
+		defer server.Close()
+		hook, err := d.Register("u1", server.URL, []string{"created"})
+		if err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		r.secret = hook.Secret
+		hooks = append(hooks, hook)
+	}
+
+	want := []string{}
+	for i := 0; i < 20; i++ {
+		text := fmt.Sprintf("event %d", i)
+		if _, err := c.Add("u1", calendar.EventData{Date: "2024-01-10", Text: text}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		want = append(want, "event.created:"+text)
+	}
+
+	for i, r := range receivers {
+		waitDeliveries(t, d, "u1", hooks[i].ID, len(want))
+		if received, errs, _ := r.snapshot(); fmt.Sprint(received) != fmt.Sprint(want) || len(errs) != 0 {
+			t.Errorf("webhook #%d received %v, errors %v", i, received, errs)
+		}
+	}
+}
+
+func TestDispatcherSubscriptions(t *testing.T) {
+	d := newDispatcher(t, Options{})
+
+	for _, tt := range []struct {
+		url    string
+		events []string
+	}{
+		{"ftp://example.com/hook", nil},
+		{"/relative", nil},
+		{"https://example.com/hook", []string{"moved"}},
+		{"http://127.0.0.1:8080/hook", nil},
+		{"http://LocalHost/hook", nil},
+		{"http://10.1.2.3/hook", nil},
+		{"http://192.168.0.1/hook", nil},
+		{"http://169.254.169.254/latest/meta-data", nil},
+		{"http://[::1]/hook", nil},
+		{"http://[::ffff:127.0.0.1]/hook", nil},
+		{"http://0.0.0.0/hook", nil},
+	} {
+		if _, err := d.Register("u1", tt.url, tt.events); !errors.Is(err, calendar.ErrValidation) {
+			t.Errorf("Register(%q, %v) error = %v, want validation error", tt.url, tt.events, err)
+		}
+	}
+
+	server := httptest.NewServer(&receiver{})
+	defer server.Close()
+	if _, err := d.options.Client.Get(server.URL); !errors.Is(err, errBlockedAddress) {
+		t.Errorf("default client reached loopback server, error = %v", err)
+	}
+	allowed := newDispatcher(t, Options{AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+	if _, err := allowed.Register("u1", "http://10.1.2.3/hook", nil); err != nil {
+		t.Errorf("Register() of allowed network error = %v", err)
+	}
+
+	hook, err := d.Register("u1", "https://example.com/hook", []string{"created", "created"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if hook.Secret == "" || fmt.Sprint(hook.Events) != "[created]" {
+		t.Errorf("registered webhook = %+v", hook)
+	}
+
+	if list := d.Subscriptions("u1"); len(list) != 1 || list[0].Secret != "" {
+		t.Errorf("Subscriptions() = %+v, want single webhook without secret", list)
+	}
+	if list := d.Subscriptions("u2"); len(list) != 0 {
+		t.Errorf("Subscriptions() of other user = %+v", list)
+	}
+	if _, err := d.Deliveries("u2", hook.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Deliveries() of other user error = %v", err)
+	}
+	if err := d.Unregister("u2", hook.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Unregister() of other user error = %v", err)
+	}
+	if err := d.Unregister("u1", hook.ID); err != nil {
+		t.Errorf("Unregister() error = %v", err)
+	}
+	if list := d.Subscriptions("u1"); len(list) != 0 {
+		t.Errorf("Subscriptions() after Unregister() = %+v", list)
+	}
+}
+
+func TestDispatcherState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log.webhooks")
+	d := newDispatcher(t, Options{StatePath: path})
+	kept, err := d.Register("u1", "https://example.com/kept", []string{"deleted"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	removed, err := d.Register("u1", "https://example.com/removed", nil)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := d.Unregister("u1", removed.ID); err != nil {
+		t.Fatalf("Unregister() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("state file = %v, %v, want file readable only by owner", info, err)
+	}
+
+	restored := newDispatcher(t, Options{StatePath: path})
+	list := restored.Subscriptions("u1")
+	if len(list) != 1 || list[0].ID != kept.ID || fmt.Sprint(list[0].Events) != "[deleted]" {
+		t.Errorf("restored webhooks = %+v", list)
+	}
+	if hook := restored.webhooks[kept.ID]; hook == nil || hook.Secret != kept.Secret || !hook.events[calendar.EventDeleted] || hook.events[calendar.EventCreated] {
+		t.Errorf("restored webhook = %+v", hook)
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDispatcher(Options{StatePath: path}); err == nil {
+		t.Errorf("NewDispatcher() accepted corrupted state")
+	}
+}